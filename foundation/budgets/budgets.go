@@ -0,0 +1,256 @@
+// Package budgets manages the account cost alerts.
+package budgets
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/budgets"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/costexplorer"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/sns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-tagging"
+)
+
+// Config holds the budget settings (the "budgets" stack config key, or
+// config.json). If Budgets is empty, the default $50 warning and $75 critical
+// budgets are created for BudgetNotificationEmail. Shutdown optionally stops
+// the platform when an alert reaches one of the topics.
+//
+// ActivateTags (default true) activates the shared tag keys as cost-allocation
+// tags. AWS only accepts keys that already appear in billing data, up to a day
+// after they are first used, so a new account deploys once without it.
+type Config struct {
+	BudgetNotificationEmail string    `json:"budget_notification_email,omitempty"`
+	Budgets                 []Budget  `json:"budgets,omitempty"`
+	Shutdown                *Shutdown `json:"shutdown,omitempty"`
+	ActivateTags            *bool     `json:"activate_tags,omitempty"`
+}
+
+// Budget is one monthly cost budget. It covers the whole account unless
+// Service (e.g. "Amazon Elastic Compute Cloud - Compute") or Tag (a
+// cost-allocation tag, e.g. "Stack=platform") narrows it.
+type Budget struct {
+	Name        string      `json:"name"`
+	Limit       float64     `json:"limit"`
+	Service     string      `json:"service,omitempty"`
+	Tag         string      `json:"tag,omitempty"`
+	Start       string      `json:"start,omitempty"`
+	Thresholds  []Threshold `json:"thresholds"`
+	Subscribers []string    `json:"subscribers,omitempty"`
+}
+
+// Threshold notifies when ACTUAL or FORECASTED spend passes Percent of the
+// limit. Subscribers, if set, replace the budget's for this threshold.
+type Threshold struct {
+	Percent     float64  `json:"percent"`
+	Type        string   `json:"type"`
+	Subscribers []string `json:"subscribers,omitempty"`
+}
+
+// Subscriber kinds: "email:<address>" or "sns:<topic>", where the topic is
+// created by the stack and may be subscribed to by anything else.
+const (
+	SubscriberEmail = "email"
+	SubscriberSNS   = "sns"
+)
+
+// Notification types.
+const (
+	Actual     = "ACTUAL"
+	Forecasted = "FORECASTED"
+)
+
+// budgets returns the budgets to create: the configured ones, or the
+// defaults for the notification email.
+func (c Config) budgets() []Budget {
+	if len(c.Budgets) > 0 {
+		return c.Budgets
+	}
+	email := []string{SubscriberEmail + ":" + c.BudgetNotificationEmail}
+	return []Budget{
+		{
+			Name:  "budget-50",
+			Limit: 50,
+			Thresholds: []Threshold{
+				{Percent: 80, Type: Actual},      // Alert at 80% ($40)
+				{Percent: 100, Type: Forecasted}, // Forecast to exceed $50
+			},
+			Subscribers: email,
+		},
+		{
+			Name:        "budget-75", // Critical
+			Limit:       75,
+			Thresholds:  []Threshold{{Percent: 100, Type: Actual}},
+			Subscribers: email,
+		},
+	}
+}
+
+// Topics returns the names of the SNS topics referenced by any subscriber.
+func (c Config) Topics() []string {
+	seen := map[string]bool{}
+	for _, b := range c.budgets() {
+		for _, t := range b.Thresholds {
+			for _, s := range b.subscribersFor(t) {
+				if kind, target, ok := strings.Cut(s, ":"); ok && kind == SubscriberSNS {
+					seen[target] = true
+				}
+			}
+		}
+	}
+	var topics []string
+	for name := range seen {
+		topics = append(topics, name)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// subscribersFor returns who a threshold notifies.
+func (b Budget) subscribersFor(t Threshold) []string {
+	if len(t.Subscribers) > 0 {
+		return t.Subscribers
+	}
+	return b.Subscribers
+}
+
+// limitAmount formats a limit the way the budgets API returns it ("50.0").
+func limitAmount(limit float64) string {
+	amount := strconv.FormatFloat(limit, 'f', -1, 64)
+	if !strings.Contains(amount, ".") {
+		amount += ".0"
+	}
+	return amount
+}
+
+// costFilters narrows the budget to its service or tag.
+func (b Budget) costFilters() budgets.BudgetCostFilterArray {
+	switch {
+	case b.Service != "":
+		return budgets.BudgetCostFilterArray{&budgets.BudgetCostFilterArgs{
+			Name:   pulumi.String("Service"),
+			Values: pulumi.StringArray{pulumi.String(b.Service)},
+		}}
+	case b.Tag != "":
+		key, value, _ := strings.Cut(b.Tag, "=")
+		return budgets.BudgetCostFilterArray{&budgets.BudgetCostFilterArgs{
+			Name:   pulumi.String("TagKeyValue"),
+			Values: pulumi.StringArray{pulumi.String("user:" + key + "$" + value)},
+		}}
+	}
+	return nil
+}
+
+// activateTags reports whether the shared tag keys are activated for cost
+// allocation.
+func (c Config) activateTags() bool {
+	return c.ActivateTags == nil || *c.ActivateTags
+}
+
+// Create registers an SNS topic per referenced "sns:" subscriber, allowing
+// AWS Budgets to publish to it, the configured monthly cost budgets, the
+// cost-allocation tag activations and, if configured, the budget-shutdown
+// Lambda. The returned map holds the topic ARNs and the Lambda name.
+func Create(ctx *pulumi.Context, config Config) (pulumi.Map, error) {
+	exports := pulumi.Map{}
+	topics := map[string]*sns.Topic{}
+	for _, name := range config.Topics() {
+		topic, err := sns.NewTopic(ctx, "budget-alerts-"+name, nil)
+		if err != nil {
+			return nil, err
+		}
+		policy := topic.Arn.ApplyT(func(arn string) (string, error) {
+			doc, err := json.Marshal(map[string]interface{}{
+				"Version": "2012-10-17",
+				"Statement": []map[string]interface{}{{
+					"Sid":       "AllowBudgets",
+					"Effect":    "Allow",
+					"Principal": map[string]string{"Service": "budgets.amazonaws.com"},
+					"Action":    "SNS:Publish",
+					"Resource":  arn,
+				}},
+			})
+			return string(doc), err
+		}).(pulumi.StringOutput)
+		_, err = sns.NewTopicPolicy(ctx, "budget-alerts-"+name, &sns.TopicPolicyArgs{
+			Arn:    topic.Arn,
+			Policy: policy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		topics[name] = topic
+		exports["BudgetTopicARN-"+name] = topic.Arn
+	}
+
+	created := map[string]*budgets.Budget{}
+	for _, b := range config.budgets() {
+		var notifications budgets.BudgetNotificationArray
+		for _, t := range b.Thresholds {
+			var emails, topicArns pulumi.StringArray
+			for _, s := range b.subscribersFor(t) {
+				kind, target, _ := strings.Cut(s, ":")
+				switch kind {
+				case SubscriberEmail:
+					emails = append(emails, pulumi.String(target))
+				case SubscriberSNS:
+					topicArns = append(topicArns, topics[target].Arn)
+				}
+			}
+			notifications = append(notifications, &budgets.BudgetNotificationArgs{
+				ComparisonOperator:       pulumi.String("GREATER_THAN"),
+				Threshold:                pulumi.Float64(t.Percent),
+				ThresholdType:            pulumi.String("PERCENTAGE"),
+				NotificationType:         pulumi.String(t.Type),
+				SubscriberEmailAddresses: emails,
+				SubscriberSnsTopicArns:   topicArns,
+			})
+		}
+
+		args := &budgets.BudgetArgs{
+			BudgetType:    pulumi.String("COST"),
+			LimitAmount:   pulumi.String(limitAmount(b.Limit)),
+			LimitUnit:     pulumi.String("USD"),
+			TimeUnit:      pulumi.String("MONTHLY"),
+			CostFilters:   b.costFilters(),
+			Notifications: notifications,
+		}
+		// Without a start, the budget starts with the current month.
+		if b.Start != "" {
+			args.TimePeriodStart = pulumi.String(b.Start + "_00:00")
+		}
+		budget, err := budgets.NewBudget(ctx, b.Name, args)
+		if err != nil {
+			return nil, err
+		}
+		created[b.Name] = budget
+	}
+
+	// Lets Cost Explorer and Tag budgets split spend by stack.
+	if config.activateTags() {
+		for _, key := range tagging.Keys {
+			_, err := costexplorer.NewCostAllocationTag(ctx, "cost-tag-"+strings.ToLower(key), &costexplorer.CostAllocationTagArgs{
+				TagKey: pulumi.String(key),
+				Status: pulumi.String("Active"),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if s := config.Shutdown; s != nil {
+		shutdownExports, err := createShutdown(ctx, *s, topics[s.Topic], created[s.Budget])
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range shutdownExports {
+			exports[name] = value
+		}
+	}
+	return exports, nil
+}
@@ -0,0 +1,223 @@
+package budgets
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-foundation/internal/mocks"
+)
+
+func TestCreateDefaults(t *testing.T) {
+	m := &mocks.Mocks{}
+	err := m.Run(func(ctx *pulumi.Context) error {
+		_, err := Create(ctx, Config{BudgetNotificationEmail: "alerts@example.com"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := m.ByType("aws:budgets/budget:Budget")
+	want := map[string]string{"budget-50": "50.0", "budget-75": "75.0"}
+	if len(created) != len(want) {
+		t.Fatalf("expected %d budgets, got %d", len(want), len(created))
+	}
+	for name, limit := range want {
+		budget, ok := created[name]
+		if !ok {
+			t.Errorf("missing %s", name)
+			continue
+		}
+		if got := budget.Inputs["limitAmount"].StringValue(); got != limit {
+			t.Errorf("%s has limit %s", name, got)
+		}
+		if _, ok := budget.Inputs["timePeriodStart"]; ok {
+			t.Errorf("%s has a fixed start", name)
+		}
+		for _, n := range budget.Inputs["notifications"].ArrayValue() {
+			emails := n.ObjectValue()["subscriberEmailAddresses"].ArrayValue()
+			if len(emails) != 1 || emails[0].StringValue() != "alerts@example.com" {
+				t.Errorf("%s notifies %v", name, emails)
+			}
+		}
+	}
+	if got := len(m.ByType("aws:sns/topic:Topic")); got != 0 {
+		t.Errorf("defaults should not create topics, got %d", got)
+	}
+}
+
+func TestCreateFromData(t *testing.T) {
+	config := Config{Budgets: []Budget{
+		{
+			Name:  "account",
+			Limit: 75,
+			Start: "2026-01-01",
+			Thresholds: []Threshold{
+				{Percent: 80, Type: Forecasted},
+				{Percent: 100, Type: Actual, Subscribers: []string{"email:oncall@example.com", "sns:critical"}},
+			},
+			Subscribers: []string{"email:alerts@example.com"},
+		},
+		{
+			Name:        "ec2",
+			Limit:       30.5,
+			Service:     "Amazon Elastic Compute Cloud - Compute",
+			Thresholds:  []Threshold{{Percent: 90, Type: Actual}},
+			Subscribers: []string{"sns:critical"},
+		},
+		{
+			Name:        "platform",
+			Limit:       40,
+			Tag:         "Stack=platform",
+			Thresholds:  []Threshold{{Percent: 100, Type: Forecasted}},
+			Subscribers: []string{"email:alerts@example.com"},
+		},
+	}}
+
+	m := &mocks.Mocks{}
+	var exports pulumi.Map
+	err := m.Run(func(ctx *pulumi.Context) error {
+		var err error
+		exports, err = Create(ctx, config)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.ByType("aws:sns/topic:Topic")["budget-alerts-critical"]; !ok {
+		t.Fatal("missing budget-alerts-critical topic")
+	}
+	if _, ok := m.ByType("aws:sns/topicPolicy:TopicPolicy")["budget-alerts-critical"]; !ok {
+		t.Error("missing budget-alerts-critical topic policy")
+	}
+	if _, ok := exports["BudgetTopicARN-critical"]; !ok {
+		t.Error("missing export BudgetTopicARN-critical")
+	}
+
+	created := m.ByType("aws:budgets/budget:Budget")
+	if len(created) != 3 {
+		t.Fatalf("expected 3 budgets, got %d", len(created))
+	}
+
+	account := created["account"].Inputs
+	if got := account["timePeriodStart"].StringValue(); got != "2026-01-01_00:00" {
+		t.Errorf("account budget starts %q", got)
+	}
+	if _, ok := account["costFilters"]; ok {
+		t.Errorf("account budget is filtered: %v", account["costFilters"])
+	}
+	notifications := account["notifications"].ArrayValue()
+	if len(notifications) != 2 {
+		t.Fatalf("expected 2 notifications, got %d", len(notifications))
+	}
+	forecast, actual := notifications[0].ObjectValue(), notifications[1].ObjectValue()
+	if forecast["notificationType"].StringValue() != "FORECASTED" || forecast["threshold"].NumberValue() != 80 {
+		t.Errorf("unexpected first notification %v", forecast)
+	}
+	if got := stringsOf(forecast["subscriberEmailAddresses"]); len(got) != 1 || got[0] != "alerts@example.com" {
+		t.Errorf("forecast notifies %v", got)
+	}
+	if got := stringsOf(actual["subscriberEmailAddresses"]); len(got) != 1 || got[0] != "oncall@example.com" {
+		t.Errorf("threshold subscribers did not replace the budget's: %v", got)
+	}
+	if got := actual["subscriberSnsTopicArns"].ArrayValue(); len(got) != 1 {
+		t.Errorf("actual notification has %d SNS topics", len(got))
+	}
+
+	ec2 := created["ec2"].Inputs
+	if got := ec2["limitAmount"].StringValue(); got != "30.5" {
+		t.Errorf("ec2 limit %q", got)
+	}
+	filter := ec2["costFilters"].ArrayValue()[0].ObjectValue()
+	if filter["name"].StringValue() != "Service" || stringsOf(filter["values"])[0] != "Amazon Elastic Compute Cloud - Compute" {
+		t.Errorf("ec2 cost filter %v", filter)
+	}
+
+	filter = created["platform"].Inputs["costFilters"].ArrayValue()[0].ObjectValue()
+	if filter["name"].StringValue() != "TagKeyValue" || stringsOf(filter["values"])[0] != "user:Stack$platform" {
+		t.Errorf("platform cost filter %v", filter)
+	}
+}
+
+func stringsOf(v resource.PropertyValue) []string {
+	var out []string
+	if !v.IsArray() {
+		return nil
+	}
+	for _, item := range v.ArrayValue() {
+		out = append(out, item.StringValue())
+	}
+	return out
+}
+
+func TestCreateShutdown(t *testing.T) {
+	config := Config{
+		Budgets: []Budget{{
+			Name:        "budget-75",
+			Limit:       75,
+			Thresholds:  []Threshold{{Percent: 100, Type: Actual}},
+			Subscribers: []string{"email:alerts@example.com", "sns:critical"},
+		}},
+		Shutdown: &Shutdown{
+			Topic:        "critical",
+			Budget:       "budget-75",
+			InstanceTags: map[string]string{"Name": "k3s-server-v6"},
+			Allow:        []string{"k3s-server-v6"},
+			DryRun:       true,
+		},
+	}
+	if errs := Validate(config); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	m := &mocks.Mocks{}
+	var exports pulumi.Map
+	err := m.Run(func(ctx *pulumi.Context) error {
+		var err error
+		exports, err = Create(ctx, config)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn, ok := m.ByType("aws:lambda/function:Function")["budget-shutdown"]
+	if !ok {
+		t.Fatal("missing budget-shutdown function")
+	}
+	if got := fn.Inputs["runtime"].StringValue(); got != "provided.al2023" {
+		t.Errorf("runtime %q", got)
+	}
+	env := fn.Inputs["environment"].ObjectValue()["variables"].ObjectValue()
+	for key, want := range map[string]string{
+		"INSTANCE_TAGS": "Name=k3s-server-v6",
+		"ALLOW_LIST":    "k3s-server-v6",
+		"DRY_RUN":       "true",
+	} {
+		if got := env[resource.PropertyKey(key)].StringValue(); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := env["BUDGET_NAME"]; !ok {
+		t.Error("missing BUDGET_NAME")
+	}
+
+	sub, ok := m.ByType("aws:sns/topicSubscription:TopicSubscription")["budget-shutdown"]
+	if !ok || sub.Inputs["protocol"].StringValue() != "lambda" {
+		t.Errorf("missing lambda subscription: %v", sub.Inputs)
+	}
+	if _, ok := m.ByType("aws:lambda/permission:Permission")["budget-shutdown-sns"]; !ok {
+		t.Error("missing permission for SNS to invoke the function")
+	}
+	policy := m.ByType("aws:iam/rolePolicy:RolePolicy")["budget-shutdown-ec2"].Inputs["policy"].StringValue()
+	if !strings.Contains(policy, `"ec2:ResourceTag/Name":"k3s-server-v6"`) {
+		t.Errorf("stop permission is not limited to the tagged instance:\n%s", policy)
+	}
+	if _, ok := exports["BudgetShutdownFunction"]; !ok {
+		t.Error("missing export BudgetShutdownFunction")
+	}
+}
@@ -0,0 +1,154 @@
+package budgets
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/budgets"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/sns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// defaultShutdownCode is the Lambda binary built from lambda/budget-shutdown.
+const defaultShutdownCode = "lambda/budget-shutdown/bootstrap"
+
+// Shutdown subscribes the budget-shutdown Lambda to an "sns:" topic: when an
+// alert for Budget arrives, it stops the running instances carrying every
+// InstanceTags tag whose ID or Name tag is in Allow. With DryRun it only
+// logs what it would stop.
+type Shutdown struct {
+	Topic        string            `json:"topic"`
+	Budget       string            `json:"budget,omitempty"`
+	InstanceTags map[string]string `json:"instance_tags"`
+	Allow        []string          `json:"allow"`
+	DryRun       bool              `json:"dry_run,omitempty"`
+	Code         string            `json:"code,omitempty"`
+}
+
+// code returns the path of the Lambda bootstrap binary.
+func (s Shutdown) code() string {
+	if s.Code == "" {
+		return defaultShutdownCode
+	}
+	return s.Code
+}
+
+// policy grants describing every instance and stopping only the tagged ones.
+func (s Shutdown) policy() (string, error) {
+	tagConditions := map[string]string{}
+	for key, value := range s.InstanceTags {
+		tagConditions["ec2:ResourceTag/"+key] = value
+	}
+	doc, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Sid":      "FindInstances",
+				"Effect":   "Allow",
+				"Action":   "ec2:DescribeInstances",
+				"Resource": "*",
+			},
+			{
+				"Sid":       "StopTaggedInstances",
+				"Effect":    "Allow",
+				"Action":    "ec2:StopInstances",
+				"Resource":  "arn:aws:ec2:*:*:instance/*",
+				"Condition": map[string]interface{}{"StringEquals": tagConditions},
+			},
+		},
+	})
+	return string(doc), err
+}
+
+// createShutdown registers the budget-shutdown Lambda, its role and its
+// subscription to the alert topic.
+func createShutdown(ctx *pulumi.Context, s Shutdown, topic *sns.Topic, budget *budgets.Budget) (pulumi.Map, error) {
+	role, err := iam.NewRole(ctx, "budget-shutdown", &iam.RoleArgs{
+		AssumeRolePolicy: pulumi.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Action": "sts:AssumeRole",
+				"Principal": {"Service": "lambda.amazonaws.com"},
+				"Effect": "Allow"
+			}]
+		}`),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = iam.NewRolePolicyAttachment(ctx, "budget-shutdown-logs", &iam.RolePolicyAttachmentArgs{
+		Role:      role.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	policy, err := s.policy()
+	if err != nil {
+		return nil, err
+	}
+	_, err = iam.NewRolePolicy(ctx, "budget-shutdown-ec2", &iam.RolePolicyArgs{
+		Role:   role.Name,
+		Policy: pulumi.String(policy),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for key, value := range s.InstanceTags {
+		tags = append(tags, key+"="+value)
+	}
+	sort.Strings(tags)
+	dryRun := "false"
+	if s.DryRun {
+		dryRun = "true"
+	}
+	env := pulumi.StringMap{
+		"INSTANCE_TAGS": pulumi.String(strings.Join(tags, ",")),
+		"ALLOW_LIST":    pulumi.String(strings.Join(s.Allow, ",")),
+		"DRY_RUN":       pulumi.String(dryRun),
+	}
+	if budget != nil {
+		env["BUDGET_NAME"] = budget.Name
+	}
+
+	fn, err := lambda.NewFunction(ctx, "budget-shutdown", &lambda.FunctionArgs{
+		Runtime:       pulumi.String("provided.al2023"),
+		Handler:       pulumi.String("bootstrap"),
+		Architectures: pulumi.StringArray{pulumi.String("arm64")},
+		Code: pulumi.NewAssetArchive(map[string]interface{}{
+			"bootstrap": pulumi.NewFileAsset(s.code()),
+		}),
+		Role:    role.Arn,
+		Timeout: pulumi.Int(30),
+		Environment: &lambda.FunctionEnvironmentArgs{
+			Variables: env,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = lambda.NewPermission(ctx, "budget-shutdown-sns", &lambda.PermissionArgs{
+		Action:    pulumi.String("lambda:InvokeFunction"),
+		Function:  fn.Name,
+		Principal: pulumi.String("sns.amazonaws.com"),
+		SourceArn: topic.Arn,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = sns.NewTopicSubscription(ctx, "budget-shutdown", &sns.TopicSubscriptionArgs{
+		Topic:    topic.Arn,
+		Protocol: pulumi.String("lambda"),
+		Endpoint: fn.Arn,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pulumi.Map{"BudgetShutdownFunction": fn.Name}, nil
+}
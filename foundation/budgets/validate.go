@@ -0,0 +1,177 @@
+package budgets
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// topicPattern is what AWS accepts in an SNS topic name, less the prefix.
+var topicPattern = regexp.MustCompile(`^[\w-]{1,200}$`)
+
+// tagPattern matches a cost-allocation tag filter, Key=Value.
+var tagPattern = regexp.MustCompile(`^[^=]+=.+$`)
+
+// Validate checks the budget settings before anything is registered and
+// returns every problem found.
+func Validate(config Config) []error {
+	errs := validateBudgets(config)
+	if config.Shutdown != nil {
+		errs = append(errs, validateShutdown(config)...)
+	}
+	return errs
+}
+
+// validateBudgets checks the budgets, or the notification email the default
+// budgets use.
+func validateBudgets(config Config) []error {
+	if len(config.Budgets) == 0 {
+		email := config.BudgetNotificationEmail
+		if email == "" {
+			return []error{errors.New("budget_notification_email is required when no budgets are declared")}
+		}
+		if err := validateEmail(email); err != nil {
+			return []error{fmt.Errorf("budget_notification_email %q is not a valid email address", email)}
+		}
+		return nil
+	}
+
+	var errs []error
+	seen := map[string]bool{}
+	for i, b := range config.Budgets {
+		label := fmt.Sprintf("budget %q", b.Name)
+		if strings.TrimSpace(b.Name) == "" {
+			label = fmt.Sprintf("budget %d", i)
+			errs = append(errs, fmt.Errorf("%s has no name", label))
+		} else if seen[b.Name] {
+			errs = append(errs, fmt.Errorf("%s is declared twice", label))
+		}
+		seen[b.Name] = true
+
+		if b.Limit <= 0 {
+			errs = append(errs, fmt.Errorf("%s: limit must be a positive amount of USD, got %v", label, b.Limit))
+		}
+		if b.Service != "" && b.Tag != "" {
+			errs = append(errs, fmt.Errorf("%s: set service or tag, not both", label))
+		}
+		if b.Tag != "" && !tagPattern.MatchString(b.Tag) {
+			errs = append(errs, fmt.Errorf("%s: tag %q must be of the form <key>=<value>", label, b.Tag))
+		}
+		if b.Start != "" {
+			if _, err := time.Parse("2006-01-02", b.Start); err != nil {
+				errs = append(errs, fmt.Errorf("%s: start %q must be a YYYY-MM-DD date", label, b.Start))
+			}
+		}
+		if len(b.Thresholds) == 0 {
+			errs = append(errs, fmt.Errorf("%s has no thresholds", label))
+		}
+		for _, s := range b.Subscribers {
+			if err := validateSubscriber(s); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", label, err))
+			}
+		}
+		for _, t := range b.Thresholds {
+			tlabel := fmt.Sprintf("%s threshold %v%% %s", label, t.Percent, t.Type)
+			if t.Type != Actual && t.Type != Forecasted {
+				errs = append(errs, fmt.Errorf("%s: type must be %s or %s", tlabel, Actual, Forecasted))
+			}
+			if t.Percent <= 0 {
+				errs = append(errs, fmt.Errorf("%s: percent must be positive", tlabel))
+			}
+			for _, s := range t.Subscribers {
+				if err := validateSubscriber(s); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", tlabel, err))
+				}
+			}
+			subscribers := b.subscribersFor(t)
+			if len(subscribers) == 0 {
+				errs = append(errs, fmt.Errorf("%s has no subscribers", tlabel))
+			}
+			// AWS allows 10 email addresses and 1 SNS topic per notification.
+			emails, topics := 0, 0
+			for _, s := range subscribers {
+				switch kind, _, _ := strings.Cut(s, ":"); kind {
+				case SubscriberEmail:
+					emails++
+				case SubscriberSNS:
+					topics++
+				}
+			}
+			if emails > 10 || topics > 1 {
+				errs = append(errs, fmt.Errorf("%s: at most 10 email subscribers and 1 SNS topic, got %d and %d", tlabel, emails, topics))
+			}
+		}
+	}
+	return errs
+}
+
+// validateShutdown checks the shutdown refers to a declared topic and budget
+// and cannot stop arbitrary instances.
+func validateShutdown(config Config) []error {
+	s := config.Shutdown
+	var errs []error
+	found := false
+	for _, topic := range config.Topics() {
+		found = found || topic == s.Topic
+	}
+	if !found {
+		errs = append(errs, fmt.Errorf("shutdown: topic %q is not an sns: subscriber of any budget (topics: %s)", s.Topic, strings.Join(config.Topics(), ", ")))
+	}
+	if s.Budget != "" {
+		found = false
+		for _, b := range config.budgets() {
+			found = found || b.Name == s.Budget
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("shutdown: budget %q is not declared", s.Budget))
+		}
+	}
+	if len(s.InstanceTags) == 0 {
+		errs = append(errs, errors.New("shutdown: instance_tags is required"))
+	}
+	for key := range s.InstanceTags {
+		if key == "" || strings.ContainsAny(key, "=,") {
+			errs = append(errs, fmt.Errorf("shutdown: instance tag key %q is invalid", key))
+		}
+	}
+	if len(s.Allow) == 0 {
+		errs = append(errs, errors.New("shutdown: allow must list the instance IDs or Name tags that may be stopped"))
+	}
+	return errs
+}
+
+// validateSubscriber checks an "email:<address>" or "sns:<topic>" subscriber.
+func validateSubscriber(subscriber string) error {
+	kind, target, ok := strings.Cut(subscriber, ":")
+	if !ok || target == "" {
+		return fmt.Errorf("subscriber %q must be of the form %s:<address> or %s:<topic>", subscriber, SubscriberEmail, SubscriberSNS)
+	}
+	switch kind {
+	case SubscriberEmail:
+		if validateEmail(target) != nil {
+			return fmt.Errorf("subscriber %q is not a valid email address", subscriber)
+		}
+	case SubscriberSNS:
+		if !topicPattern.MatchString(target) {
+			return fmt.Errorf("subscriber %q: topic name must be letters, digits, - and _", subscriber)
+		}
+	default:
+		return fmt.Errorf("subscriber %q: unknown kind %q (known kinds: %s, %s)", subscriber, kind, SubscriberEmail, SubscriberSNS)
+	}
+	return nil
+}
+
+// validateEmail accepts a bare address only.
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return err
+	}
+	if addr.Address != email {
+		return fmt.Errorf("%q is not a bare address", email)
+	}
+	return nil
+}
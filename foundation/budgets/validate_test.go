@@ -0,0 +1,105 @@
+package budgets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		ok    bool
+	}{
+		{"alerts@example.com", true},
+		{"", false},
+		{"not-an-email", false},
+		{"Alerts <alerts@example.com>", false},
+	}
+	for _, tt := range tests {
+		errs := Validate(Config{BudgetNotificationEmail: tt.email})
+		if ok := len(errs) == 0; ok != tt.ok {
+			t.Errorf("Validate(%q) = %v, want ok=%v", tt.email, errs, tt.ok)
+		}
+	}
+}
+
+func TestValidateBudgets(t *testing.T) {
+	valid := Budget{
+		Name:        "account",
+		Limit:       50,
+		Thresholds:  []Threshold{{Percent: 80, Type: Actual}},
+		Subscribers: []string{"email:alerts@example.com", "sns:critical"},
+	}
+	if errs := Validate(Config{Budgets: []Budget{valid}}); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	config := Config{Budgets: []Budget{
+		valid,
+		valid,
+		{
+			Limit:   0,
+			Service: "Amazon Elastic Compute Cloud - Compute",
+			Tag:     "Stack",
+			Start:   "01/01/2026",
+		},
+		{
+			Name:  "bad-thresholds",
+			Limit: 10,
+			Thresholds: []Threshold{
+				{Percent: 0, Type: "ACTUALS", Subscribers: []string{"pager:me"}},
+				{Percent: 100, Type: Actual, Subscribers: []string{"sns:a", "sns:b", "email:x"}},
+			},
+		},
+	}}
+	var all []string
+	for _, err := range Validate(config) {
+		all = append(all, err.Error())
+	}
+	joined := strings.Join(all, "\n")
+	for _, want := range []string{
+		`budget "account" is declared twice`,
+		`budget 2 has no name`,
+		`limit must be a positive amount`,
+		`set service or tag, not both`,
+		`tag "Stack" must be of the form <key>=<value>`,
+		`start "01/01/2026" must be a YYYY-MM-DD date`,
+		`budget 2 has no thresholds`,
+		`type must be ACTUAL or FORECASTED`,
+		`percent must be positive`,
+		`unknown kind "pager"`,
+		`subscriber "email:x" is not a valid email address`,
+		`at most 10 email subscribers and 1 SNS topic, got 1 and 2`,
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("errors do not mention %s:\n%s", want, joined)
+		}
+	}
+}
+
+func TestValidateShutdown(t *testing.T) {
+	config := Config{
+		Budgets: []Budget{{
+			Name:        "budget-75",
+			Limit:       75,
+			Thresholds:  []Threshold{{Percent: 100, Type: Actual}},
+			Subscribers: []string{"sns:critical"},
+		}},
+		Shutdown: &Shutdown{Topic: "warning", Budget: "budget-100"},
+	}
+	var all []string
+	for _, err := range Validate(config) {
+		all = append(all, err.Error())
+	}
+	joined := strings.Join(all, "\n")
+	for _, want := range []string{
+		`topic "warning" is not an sns: subscriber of any budget (topics: critical)`,
+		`budget "budget-100" is not declared`,
+		`instance_tags is required`,
+		`allow must list`,
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("errors do not mention %s:\n%s", want, joined)
+		}
+	}
+}
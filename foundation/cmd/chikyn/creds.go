@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"teamchikynbitts-foundation/identity"
+	"teamchikynbitts-foundation/onboarding"
+)
+
+// runCreds implements `chikyn creds`.
+func runCreds(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("creds", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	user := fs.String("user", "", "only this user (display name or username)")
+	out := fs.String("out", "", "write one <username>.txt bundle per user to this directory instead of printing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	stack, err := sf.selectStack(ctx)
+	if err != nil {
+		return err
+	}
+	users, err := loadUsers(ctx, stack, sf.dir)
+	if err != nil {
+		return err
+	}
+	if *user != "" {
+		users = filterUsers(users, *user)
+		if len(users) == 0 {
+			return fmt.Errorf("no user %q in stack %s", *user, stack.Name())
+		}
+	}
+	outputs, err := stackOutputs(ctx, stack)
+	if err != nil {
+		return err
+	}
+
+	bundles, bundleErr := onboarding.Bundles(users, outputs)
+	if *out != "" {
+		paths, err := onboarding.Write(*out, bundles)
+		for _, path := range paths {
+			fmt.Println("wrote", path)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, b := range bundles {
+			fmt.Println("------------------------------------------------------------------")
+			if _, err := b.WriteTo(os.Stdout); err != nil {
+				return err
+			}
+		}
+	}
+	return bundleErr
+}
+
+// filterUsers returns the users whose display name or username is name.
+func filterUsers(users []identity.User, name string) []identity.User {
+	var found []identity.User
+	for _, u := range users {
+		if u.Name == name || identity.ResourceName(u.Name) == name {
+			found = append(found, u)
+		}
+	}
+	return found
+}
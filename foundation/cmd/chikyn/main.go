@@ -0,0 +1,57 @@
+// Command chikyn operates the foundation stack through the Pulumi Automation
+// API. Run it from foundation/ (or pass -dir).
+//
+//	chikyn creds [-stack dev] [-dir .] [-user NAME] [-out DIR]
+//	chikyn user list [-stack dev] [-dir .]
+//	chikyn user add [-groups a,b] [-access-key] [-yes] "<Full Name>"
+//	chikyn user remove [-yes] "<Full Name>"
+//
+// creds prints every user's onboarding bundle (login URL, username,
+// temporary password and access key), or writes one file per user with 0600
+// permissions to -out.
+//
+// user edits the users list (the users stack config key, or users.json) and
+// runs a preview and up of the foundation stack. add prints the new user's
+// onboarding bundle; remove first disables the user, deactivating their keys
+// and removing their login profile, then deletes them.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+const usage = `usage: chikyn <command> [flags]
+
+commands:
+  creds   print or write per-user onboarding bundles
+  user    add, remove or list users and deploy the change
+
+Run "chikyn <command> -h" for the command's flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "creds":
+		err = runCreds(ctx, os.Args[2:])
+	case "user":
+		err = runUser(ctx, os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "chikyn: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "chikyn %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
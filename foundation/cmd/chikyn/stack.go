@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+
+	"teamchikynbitts-foundation/identity"
+	"teamchikynbitts-foundation/internal/jsonfile"
+)
+
+// project is the foundation Pulumi project name, which prefixes its config
+// keys.
+const project = "teamchikynbitts-foundation"
+
+// stackFlags are the flags shared by every command.
+type stackFlags struct {
+	stack string
+	dir   string
+}
+
+func (f *stackFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.stack, "stack", "dev", "foundation stack name")
+	fs.StringVar(&f.dir, "dir", ".", "foundation project directory")
+}
+
+// selectStack opens the existing foundation stack in f.dir.
+func (f *stackFlags) selectStack(ctx context.Context) (auto.Stack, error) {
+	return auto.SelectStackLocalSource(ctx, f.stack, f.dir)
+}
+
+// readInput decodes the key the way the program does: from stack config if
+// set, falling back to file in dir. It reports which one was used.
+func readInput(ctx context.Context, stack auto.Stack, dir, key, file string, v any) (fromConfig bool, err error) {
+	all, err := stack.GetAllConfig(ctx)
+	if err != nil {
+		return false, err
+	}
+	if value, ok := all[project+":"+key]; ok {
+		return true, jsonfile.Decode("config "+key, []byte(value.Value), v)
+	}
+	path := filepath.Join(dir, file)
+	if _, err := os.Stat(path); err != nil {
+		return false, fmt.Errorf("%s is not configured in stack %s and %s does not exist", key, stack.Name(), path)
+	}
+	return false, jsonfile.Load(path, v)
+}
+
+// loadUsers reads the users list.
+func loadUsers(ctx context.Context, stack auto.Stack, dir string) ([]identity.User, error) {
+	var users []identity.User
+	_, err := readInput(ctx, stack, dir, "users", "users.json", &users)
+	return users, err
+}
+
+// saveUsers writes the users list back where it was read from: the secret
+// users config key, or users.json.
+func saveUsers(ctx context.Context, stack auto.Stack, dir string, users []identity.User, toConfig bool) error {
+	content, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	if toConfig {
+		return stack.SetConfig(ctx, "users", auto.ConfigValue{Value: string(content), Secret: true})
+	}
+	return os.WriteFile(filepath.Join(dir, "users.json"), append(content, '\n'), 0o600)
+}
+
+// stackOutputs returns the stack outputs, secrets in plaintext, as strings.
+func stackOutputs(ctx context.Context, stack auto.Stack) (map[string]string, error) {
+	outputs, err := stack.Outputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for key, output := range outputs {
+		switch v := output.Value.(type) {
+		case string:
+			values[key] = v
+		default:
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = string(raw)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+
+	"teamchikynbitts-foundation/identity"
+	"teamchikynbitts-foundation/onboarding"
+)
+
+// userOps edits the users list of a foundation stack and deploys the change.
+type userOps struct {
+	stack auto.Stack
+	dir   string
+	out   io.Writer
+	// confirm is asked before every `pulumi up`; nil applies without asking.
+	confirm func(prompt string) bool
+}
+
+// runUser implements `chikyn user add|remove|list`.
+func runUser(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: chikyn user <add|remove|list> [flags]")
+	}
+	fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	yes := fs.Bool("yes", false, "apply without asking for confirmation")
+	var groups string
+	var accessKey bool
+	if args[0] == "add" {
+		fs.StringVar(&groups, "groups", "", "comma-separated groups from groups.json")
+		fs.BoolVar(&accessKey, "access-key", false, "also create a programmatic access key")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	stack, err := sf.selectStack(ctx)
+	if err != nil {
+		return err
+	}
+	ops := &userOps{stack: stack, dir: sf.dir, out: os.Stdout}
+	if !*yes {
+		ops.confirm = promptYes
+	}
+
+	switch args[0] {
+	case "list":
+		return ops.list(ctx)
+	case "add", "remove":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: chikyn user %s [flags] \"<Full Name>\"", args[0])
+		}
+		if args[0] == "remove" {
+			return ops.remove(ctx, fs.Arg(0))
+		}
+		user := identity.User{Name: fs.Arg(0), Groups: splitList(groups)}
+		if accessKey {
+			user.AccessKey = &identity.AccessKey{Generation: 1}
+		}
+		return ops.add(ctx, user)
+	default:
+		return fmt.Errorf("unknown user command %q (want add, remove or list)", args[0])
+	}
+}
+
+// list prints every user with their groups.
+func (o *userOps) list(ctx context.Context) error {
+	users, err := loadUsers(ctx, o.stack, o.dir)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		var notes []string
+		if u.AccessKey != nil {
+			notes = append(notes, fmt.Sprintf("access key gen %d", u.AccessKey.Generation))
+		}
+		if u.Disabled {
+			notes = append(notes, "disabled")
+		}
+		line := fmt.Sprintf("%-20s %-24s %s", identity.ResourceName(u.Name), u.Name, strings.Join(u.Groups, ","))
+		if len(notes) > 0 {
+			line += " (" + strings.Join(notes, ", ") + ")"
+		}
+		fmt.Fprintln(o.out, strings.TrimRight(line, " "))
+	}
+	return nil
+}
+
+// add appends user to the users list, deploys it and prints the new user's
+// onboarding bundle. The list is left unchanged if the deploy is declined.
+func (o *userOps) add(ctx context.Context, user identity.User) error {
+	var users []identity.User
+	inConfig, err := readInput(ctx, o.stack, o.dir, "users", "users.json", &users)
+	if err != nil {
+		return err
+	}
+	var catalogue identity.Catalogue
+	if _, err := readInput(ctx, o.stack, o.dir, "groups", "groups.json", &catalogue); err != nil {
+		return err
+	}
+	updated, err := withUser(users, user, catalogue)
+	if err != nil {
+		return err
+	}
+
+	if err := o.apply(ctx, users, updated, inConfig, "add "+user.Name); err != nil {
+		return err
+	}
+
+	outputs, err := stackOutputs(ctx, o.stack)
+	if err != nil {
+		return err
+	}
+	bundles, err := onboarding.Bundles([]identity.User{user}, outputs)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(o.out)
+	_, err = bundles[0].WriteTo(o.out)
+	return err
+}
+
+// withUser returns a copy of users with user appended, or every reason the
+// resulting list is invalid.
+func withUser(users []identity.User, user identity.User, catalogue identity.Catalogue) ([]identity.User, error) {
+	updated := append(append([]identity.User{}, users...), user)
+	if errs := identity.Validate(updated, nil, catalogue); len(errs) > 0 {
+		return nil, fmt.Errorf("cannot add %q:\n%w", user.Name, errors.Join(errs...))
+	}
+	return updated, nil
+}
+
+// remove offboards the named user in two deploys: the first disables them
+// (access keys deactivated, login profile and memberships removed), the
+// second deletes the IAM user.
+func (o *userOps) remove(ctx context.Context, name string) error {
+	var users []identity.User
+	inConfig, err := readInput(ctx, o.stack, o.dir, "users", "users.json", &users)
+	if err != nil {
+		return err
+	}
+	index := findUser(users, name)
+	if index < 0 {
+		return fmt.Errorf("no user %q in stack %s", name, o.stack.Name())
+	}
+	name = users[index].Name
+
+	if !users[index].Disabled {
+		disabled := append([]identity.User{}, users...)
+		disabled[index].Disabled = true
+		if err := o.apply(ctx, users, disabled, inConfig, "disable "+name); err != nil {
+			return err
+		}
+		users = disabled
+	}
+
+	remaining := append(append([]identity.User{}, users[:index]...), users[index+1:]...)
+	return o.apply(ctx, users, remaining, inConfig, "remove "+name)
+}
+
+// findUser returns the index of the user with the given full or IAM user
+// name, or -1.
+func findUser(users []identity.User, name string) int {
+	index := -1
+	for i, u := range users {
+		if u.Name == name || identity.ResourceName(u.Name) == name {
+			index = i
+		}
+	}
+	return index
+}
+
+// apply saves updated, previews and deploys it. If the preview fails or the
+// deploy is declined, the previous list is restored.
+func (o *userOps) apply(ctx context.Context, previous, updated []identity.User, inConfig bool, what string) (err error) {
+	if err := saveUsers(ctx, o.stack, o.dir, updated, inConfig); err != nil {
+		return err
+	}
+	deployed := false
+	defer func() {
+		if deployed {
+			return
+		}
+		if restoreErr := saveUsers(ctx, o.stack, o.dir, previous, inConfig); restoreErr != nil {
+			err = errors.Join(err, fmt.Errorf("restoring the users list: %w", restoreErr))
+		}
+	}()
+
+	fmt.Fprintf(o.out, "Previewing %s on stack %s...\n", what, o.stack.Name())
+	preview, err := o.stack.Preview(ctx, optpreview.Diff())
+	if err != nil {
+		return fmt.Errorf("preview: %w", err)
+	}
+	fmt.Fprintln(o.out, preview.StdOut)
+	if o.confirm != nil && !o.confirm(fmt.Sprintf("Apply %s?", what)) {
+		return errors.New("aborted")
+	}
+
+	// Once up has started the stack may be partly updated, so the new list
+	// is kept even if it fails; re-running the command resumes.
+	deployed = true
+	if _, err := o.stack.Up(ctx, optup.ProgressStreams(o.out)); err != nil {
+		return fmt.Errorf("up: %w", err)
+	}
+	return nil
+}
+
+// promptYes asks a yes/no question on the terminal.
+func promptYes(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
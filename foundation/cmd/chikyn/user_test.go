@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"teamchikynbitts-foundation/identity"
+)
+
+// fakeFoundation stands in for the foundation program: it exports what
+// CreateUsers would for every enabled user, without touching AWS.
+func fakeFoundation(ctx *pulumi.Context) error {
+	var users []identity.User
+	config.New(ctx, "").RequireSecretObject("users", &users)
+	for _, u := range users {
+		if u.Disabled {
+			continue
+		}
+		name := identity.ResourceName(u.Name)
+		ctx.Export("UserARN-"+name, pulumi.String("arn:aws:iam::123456789012:user/"+name))
+		ctx.Export("ConsolePassword-"+name, pulumi.ToSecret(pulumi.String("pw-"+name)))
+	}
+	return nil
+}
+
+// testUsers and testCatalogue are the users and groups testStack configures.
+var (
+	testUsers     = []identity.User{{Name: "Joshua Hayes", Groups: []string{"technical"}}}
+	testCatalogue = identity.Catalogue{"technical": {ManagedPolicies: []string{"arn:aws:iam::aws:policy/AdministratorAccess"}}}
+)
+
+// testStack creates the stack on a file backend in a temp dir. It needs the
+// pulumi CLI.
+func testStack(t *testing.T) auto.Stack {
+	t.Helper()
+	if _, err := exec.LookPath("pulumi"); err != nil {
+		t.Skip("pulumi CLI not installed")
+	}
+	dir := t.TempDir()
+	ctx := context.Background()
+	stack, err := auto.UpsertStackInlineSource(ctx, "test", project, fakeFoundation,
+		auto.WorkDir(dir),
+		auto.Project(workspace.Project{
+			Name:    tokens.PackageName(project),
+			Runtime: workspace.NewProjectRuntimeInfo("go", nil),
+			Backend: &workspace.ProjectBackend{URL: "file://" + filepath.ToSlash(dir)},
+		}),
+		auto.SecretsProvider("passphrase"),
+		auto.EnvVars(map[string]string{"PULUMI_CONFIG_PASSPHRASE": "test"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]any{"users": testUsers, "groups": testCatalogue} {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stack.SetConfig(ctx, key, auto.ConfigValue{Value: string(raw), Secret: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return stack
+}
+
+func TestUserAddAndRemove(t *testing.T) {
+	stack := testStack(t)
+	ctx := context.Background()
+	var out bytes.Buffer
+	ops := &userOps{stack: stack, dir: t.TempDir(), out: &out}
+
+	if err := ops.add(ctx, identity.User{Name: "Abby Adkins", Groups: []string{"technical"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Username:           abby-adkins") ||
+		!strings.Contains(out.String(), "Temporary password: pw-abby-adkins") {
+		t.Errorf("add did not print the onboarding bundle:\n%s", out.String())
+	}
+	users, err := loadUsers(ctx, stack, ops.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[1].Name != "Abby Adkins" {
+		t.Fatalf("users after add: %+v", users)
+	}
+
+	out.Reset()
+	if err := ops.remove(ctx, "abby-adkins"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "disable Abby Adkins") || !strings.Contains(out.String(), "remove Abby Adkins") {
+		t.Errorf("remove did not disable before removing:\n%s", out.String())
+	}
+	users, err = loadUsers(ctx, stack, ops.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "Joshua Hayes" {
+		t.Errorf("users after remove: %+v", users)
+	}
+	outputs, err := stackOutputs(ctx, stack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := outputs["UserARN-abby-adkins"]; ok {
+		t.Error("removed user is still deployed")
+	}
+}
+
+func TestUserAddRejectsInvalidUser(t *testing.T) {
+	_, err := withUser(testUsers, identity.User{Name: "joshua hayes", Groups: []string{"admins"}}, testCatalogue)
+	if err == nil || !strings.Contains(err.Error(), "already used") || !strings.Contains(err.Error(), `unknown group "admins"`) {
+		t.Errorf("expected duplicate and unknown group errors, got %v", err)
+	}
+
+	updated, err := withUser(testUsers, identity.User{Name: "Abby Adkins", Groups: []string{"technical"}}, testCatalogue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 2 || updated[1].Name != "Abby Adkins" || len(testUsers) != 1 {
+		t.Errorf("users after add: %+v, before: %+v", updated, testUsers)
+	}
+}
+
+func TestFindUser(t *testing.T) {
+	users := append(append([]identity.User{}, testUsers...), identity.User{Name: "Abby Adkins"})
+	for name, want := range map[string]int{"Abby Adkins": 1, "abby-adkins": 1, "joshua-hayes": 0, "Ann Adkins": -1} {
+		if got := findUser(users, name); got != want {
+			t.Errorf("findUser(%q) = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestRunUserUsage(t *testing.T) {
+	if err := runUser(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "usage: chikyn user") {
+		t.Errorf("expected a usage error, got %v", err)
+	}
+}
+
+func TestUserAddDeclined(t *testing.T) {
+	stack := testStack(t)
+	ctx := context.Background()
+	ops := &userOps{stack: stack, dir: t.TempDir(), out: &bytes.Buffer{}, confirm: func(string) bool { return false }}
+
+	if err := ops.add(ctx, identity.User{Name: "Abby Adkins"}); err == nil {
+		t.Fatal("expected the declined add to fail")
+	}
+	users, err := loadUsers(ctx, stack, ops.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Errorf("declined add changed the users list: %+v", users)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if got := strings.Join(splitList(" technical, ,billing "), "|"); got != "technical|billing" {
+		t.Errorf("got %q", got)
+	}
+	if got := splitList(""); got != nil {
+		t.Errorf("got %v", got)
+	}
+}
@@ -0,0 +1,537 @@
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Bot is a single entry of the bots list. Scopes grant it exactly what it
+// needs, e.g. "ecr-push:josh-app" or "pulumi-deploy:platform". Bots get no
+// long-lived access key unless AccessKey is set; prefer a GitHub OIDC role.
+type Bot struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	AccessKey bool     `json:"access_key,omitempty"`
+}
+
+// botPrefix starts the IAM user name of every bot, so bots can't collide
+// with people.
+const botPrefix = "bot-"
+
+// Scope kinds understood in Bot.Scopes and Workflow.Scopes.
+const (
+	ScopeECRPush       = "ecr-push"
+	ScopePulumiDeploy  = "pulumi-deploy"
+	ScopePulumiPreview = "pulumi-preview"
+)
+
+// deployStatements builds the policy statements `pulumi up` needs for each
+// stack in an account and region. State lives in the Pulumi Cloud backend,
+// so no AWS permissions are needed for it. Writes are limited to the
+// resources each stack manages, by ARN, IAM path or name prefix, and bots
+// and roles may only be created or changed with the permissions boundary.
+var deployStatements = map[string]func(accountID, region string) []map[string]interface{}{
+	"foundation": foundationDeployStatements,
+	"platform":   platformDeployStatements,
+}
+
+// foundationDeployStatements covers the foundation's users, groups and bots
+// under Path, its github-* and budget-shutdown-* roles, the EnforceMFA policy
+// and the bots' deploy policies under Path, the GitHub OIDC provider, the
+// budget alerts and Lambda, and the app repositories. The boundary policy
+// itself is left to administrators.
+func foundationDeployStatements(accountID, region string) []map[string]interface{} {
+	iamArn := "arn:aws:iam::" + accountID + ":"
+	users := iamArn + "user" + Path + "*"
+	bots := iamArn + "user" + Path + botPrefix + "*"
+	groups := iamArn + "group" + Path + "*"
+	roles := []string{iamArn + "role/github-*", iamArn + "role/budget-shutdown-*"}
+	return []map[string]interface{}{
+		statement("IAMRead", []string{"iam:Get*", "iam:List*"}, "*"),
+		withCondition(statement("IAMBoundedPrincipals", []string{
+			"iam:AttachRolePolicy",
+			"iam:AttachUserPolicy",
+			"iam:CreateRole",
+			"iam:CreateUser",
+			"iam:DeleteRolePolicy",
+			"iam:DeleteUserPolicy",
+			"iam:DetachRolePolicy",
+			"iam:DetachUserPolicy",
+			"iam:PutRolePermissionsBoundary",
+			"iam:PutRolePolicy",
+			"iam:PutUserPermissionsBoundary",
+			"iam:PutUserPolicy",
+		}, append([]string{bots}, roles...)...), "StringEquals", "iam:PermissionsBoundary", BoundaryARN(accountID)),
+		// People carry no boundary and get their permissions only from groups.
+		statement("IAMPeople", []string{"iam:CreateUser"}, users),
+		statement("IAMUsers", []string{
+			"iam:CreateAccessKey",
+			"iam:CreateLoginProfile",
+			"iam:DeactivateMFADevice",
+			"iam:DeleteAccessKey",
+			"iam:DeleteLoginProfile",
+			"iam:DeleteSSHPublicKey",
+			"iam:DeleteServiceSpecificCredential",
+			"iam:DeleteSigningCertificate",
+			"iam:DeleteUser",
+			"iam:TagUser",
+			"iam:UntagUser",
+			"iam:UpdateAccessKey",
+			"iam:UpdateLoginProfile",
+			"iam:UpdateUser",
+		}, users),
+		statement("IAMMFADevices", []string{"iam:DeleteVirtualMFADevice"}, iamArn+"mfa/*"),
+		statement("IAMGroups", []string{
+			"iam:AddUserToGroup",
+			"iam:AttachGroupPolicy",
+			"iam:CreateGroup",
+			"iam:DeleteGroup",
+			"iam:DeleteGroupPolicy",
+			"iam:DetachGroupPolicy",
+			"iam:PutGroupPolicy",
+			"iam:RemoveUserFromGroup",
+			"iam:UpdateGroup",
+		}, groups),
+		statement("IAMRoles", []string{
+			"iam:DeleteRole",
+			"iam:TagRole",
+			"iam:UntagRole",
+			"iam:UpdateAssumeRolePolicy",
+			"iam:UpdateRole",
+		}, roles...),
+		withCondition(statement("IAMPassRole", []string{"iam:PassRole"}, iamArn+"role/budget-shutdown-*"),
+			"StringEquals", "iam:PassedToService", "lambda.amazonaws.com"),
+		statement("IAMPolicies", []string{
+			"iam:CreatePolicy",
+			"iam:CreatePolicyVersion",
+			"iam:DeletePolicy",
+			"iam:DeletePolicyVersion",
+			"iam:TagPolicy",
+			"iam:UntagPolicy",
+		}, iamArn+"policy/EnforceMFA", iamArn+"policy"+Path+"*"),
+		statement("IAMOIDCProvider", []string{
+			"iam:AddClientIDToOpenIDConnectProvider",
+			"iam:CreateOpenIDConnectProvider",
+			"iam:DeleteOpenIDConnectProvider",
+			"iam:RemoveClientIDFromOpenIDConnectProvider",
+			"iam:TagOpenIDConnectProvider",
+			"iam:UntagOpenIDConnectProvider",
+			"iam:UpdateOpenIDConnectProviderThumbprint",
+		}, iamArn+"oidc-provider/"+githubIssuer),
+		statement("IAMPasswordPolicy", []string{"iam:DeleteAccountPasswordPolicy", "iam:UpdateAccountPasswordPolicy"}, "*"),
+		withCondition(statement("IAMServiceLinkedRoles", []string{"iam:CreateServiceLinkedRole"}, "*"),
+			"StringEquals", "iam:AWSServiceName", "replication.ecr.amazonaws.com"),
+		statement("Budgets", []string{"budgets:*"}, "arn:aws:budgets::"+accountID+":budget/*"),
+		statement("CostAllocationTags", []string{"ce:ListCostAllocationTags", "ce:UpdateCostAllocationTagsStatus"}, "*"),
+		statement("ECRRepositories", []string{"ecr:*"}, fmt.Sprintf("arn:aws:ecr:%s:%s:repository/*", region, accountID)),
+		statement("ECRRegistry", []string{"ecr:DescribeRegistry", "ecr:PutReplicationConfiguration"}, "*"),
+		statement("Lambda", []string{"lambda:*"}, fmt.Sprintf("arn:aws:lambda:%s:%s:function:budget-shutdown-*", region, accountID)),
+		statement("SNS", []string{"sns:*"}, fmt.Sprintf("arn:aws:sns:%s:%s:budget-alerts-*", region, accountID)),
+	}
+}
+
+// platformDeployStatements covers the platform's k3s-* resources and
+// snapshot bucket and the account's EC2 resources. A platform may be deployed
+// next to a registry replica, so its ARNs match any region.
+func platformDeployStatements(accountID, _ string) []map[string]interface{} {
+	arn := func(service, resource string) string {
+		return fmt.Sprintf("arn:aws:%s:*:%s:%s", service, accountID, resource)
+	}
+	roles := "arn:aws:iam::" + accountID + ":role/k3s-*"
+	return []map[string]interface{}{
+		statement("IAMRead", []string{"iam:Get*", "iam:List*"}, "*"),
+		withCondition(statement("IAMBoundedRoles", []string{
+			"iam:AttachRolePolicy",
+			"iam:CreateRole",
+			"iam:DeleteRolePolicy",
+			"iam:DetachRolePolicy",
+			"iam:PutRolePermissionsBoundary",
+			"iam:PutRolePolicy",
+		}, roles), "StringEquals", "iam:PermissionsBoundary", BoundaryARN(accountID)),
+		statement("IAMRoles", []string{
+			"iam:DeleteRole",
+			"iam:TagRole",
+			"iam:UntagRole",
+			"iam:UpdateAssumeRolePolicy",
+		}, roles),
+		statement("IAMInstanceProfiles", []string{
+			"iam:AddRoleToInstanceProfile",
+			"iam:CreateInstanceProfile",
+			"iam:DeleteInstanceProfile",
+			"iam:RemoveRoleFromInstanceProfile",
+			"iam:TagInstanceProfile",
+			"iam:UntagInstanceProfile",
+		}, "arn:aws:iam::"+accountID+":instance-profile/k3s-*"),
+		withCondition(statement("IAMPassRole", []string{"iam:PassRole"}, roles),
+			"StringEquals", "iam:PassedToService", []string{
+				"ec2.amazonaws.com",
+				"events.amazonaws.com",
+				"scheduler.amazonaws.com",
+				"ssm.amazonaws.com",
+			}),
+		withCondition(statement("IAMServiceLinkedRoles", []string{"iam:CreateServiceLinkedRole"}, "*"),
+			"StringEquals", "iam:AWSServiceName", []string{
+				"autoscaling.amazonaws.com",
+				"elasticloadbalancing.amazonaws.com",
+				"spot.amazonaws.com",
+			}),
+		statement("Describe", []string{
+			"autoscaling:Describe*",
+			"ec2:Describe*",
+			"elasticloadbalancing:Describe*",
+			"events:Describe*",
+			"events:List*",
+			"scheduler:Get*",
+			"scheduler:List*",
+			"ssm:GetCommandInvocation",
+		}, "*"),
+		statement("EC2", []string{"ec2:*"}, arn("ec2", "*"), "arn:aws:ec2:*::image/*"),
+		statement("AutoScaling", []string{"autoscaling:*"}, arn("autoscaling", "autoScalingGroup:*:autoScalingGroupName/k3s-*")),
+		statement("LoadBalancers", []string{"elasticloadbalancing:*"},
+			arn("elasticloadbalancing", "loadbalancer/net/k3s-*"),
+			arn("elasticloadbalancing", "listener/net/k3s-*"),
+			arn("elasticloadbalancing", "targetgroup/k3s-*"),
+		),
+		statement("Events", []string{"events:*"}, arn("events", "rule/k3s-*")),
+		statement("Scheduler", []string{"scheduler:*"}, arn("scheduler", "schedule/*/k3s-*")),
+		statement("SnapshotBucket", []string{"s3:*"}, "arn:aws:s3:::k3s-snapshots-*", "arn:aws:s3:::k3s-snapshots-*/*"),
+		statement("SSMDocuments", []string{
+			"ssm:AddTagsToResource",
+			"ssm:CreateDocument",
+			"ssm:DeleteDocument",
+			"ssm:DescribeDocument",
+			"ssm:DescribeDocumentPermission",
+			"ssm:GetDocument",
+			"ssm:ListTagsForResource",
+			"ssm:RemoveTagsFromResource",
+			"ssm:UpdateDocument",
+			"ssm:UpdateDocumentDefaultVersion",
+		}, arn("ssm", "document/k3s-*"), arn("ssm", "automation-definition/k3s-*")),
+		statement("SSMCommands", []string{"ssm:SendCommand"}, "arn:aws:ssm:*::document/AWS-RunShellScript", arn("ec2", "instance/*")),
+		statement("SSMAssociations", []string{
+			"ssm:CreateAssociation",
+			"ssm:DeleteAssociation",
+			"ssm:DescribeAssociation",
+			"ssm:UpdateAssociation",
+		}, arn("ssm", "association/*"), "arn:aws:ssm:*::document/AWS-RunShellScript", arn("ec2", "instance/*")),
+		tunnelStatement("SSMTunnel", accountID),
+	}
+}
+
+// tunnelStatement lets the platform's deployers and previews port-forward to
+// the k3s API through SSM (access.tunnel), but not open a shell.
+func tunnelStatement(sid, accountID string) map[string]interface{} {
+	return withCondition(statement(sid, []string{"ssm:StartSession"},
+		"arn:aws:ssm:*::document/AWS-StartPortForwardingSession",
+		"arn:aws:ec2:*:"+accountID+":instance/*",
+	), "BoolIfExists", "ssm:SessionDocumentAccessCheck", "true")
+}
+
+// statement returns an Allow statement for actions on resources.
+func statement(sid string, actions []string, resources ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"Sid":      sid,
+		"Effect":   "Allow",
+		"Action":   actions,
+		"Resource": resources,
+	}
+}
+
+// withCondition adds the condition key operator value to s.
+func withCondition(s map[string]interface{}, operator, key string, value interface{}) map[string]interface{} {
+	s["Condition"] = map[string]interface{}{
+		operator: map[string]interface{}{key: value},
+	}
+	return s
+}
+
+// previewActions lists the read-only AWS actions `pulumi preview` needs for
+// each stack.
+var previewActions = map[string][]string{
+	"foundation": {
+		"budgets:Describe*",
+		"budgets:View*",
+		"ce:ListCostAllocationTags",
+		"ecr:Describe*",
+		"ecr:Get*",
+		"ecr:List*",
+		"iam:Get*",
+		"iam:List*",
+		"lambda:Get*",
+		"lambda:List*",
+		"sns:Get*",
+		"sns:List*",
+	},
+	"platform": {
+		"autoscaling:Describe*",
+		"ec2:Describe*",
+		"elasticloadbalancing:Describe*",
+		"events:Describe*",
+		"events:List*",
+		"iam:Get*",
+		"iam:List*",
+		"s3:Get*",
+		"s3:List*",
+		"scheduler:Get*",
+		"scheduler:List*",
+		"ssm:DescribeAssociation",
+		"ssm:DescribeDocument",
+		"ssm:DescribeDocumentPermission",
+		"ssm:GetDocument",
+		"ssm:ListAssociations",
+		"ssm:ListTagsForResource",
+	},
+}
+
+// ecrPushActions are the repository-level actions `docker push` and the
+// ecr-scan gate need.
+var ecrPushActions = []string{
+	"ecr:BatchCheckLayerAvailability",
+	"ecr:BatchGetImage",
+	"ecr:CompleteLayerUpload",
+	"ecr:DescribeImageScanFindings",
+	"ecr:DescribeImages",
+	"ecr:GetDownloadUrlForLayer",
+	"ecr:InitiateLayerUpload",
+	"ecr:PutImage",
+	"ecr:UploadLayerPart",
+}
+
+// parseScope splits "kind:target".
+func parseScope(scope string) (kind, target string, err error) {
+	kind, target, ok := strings.Cut(scope, ":")
+	if !ok || target == "" {
+		return "", "", fmt.Errorf("scope %q must be of the form <kind>:<target>", scope)
+	}
+	switch kind {
+	case ScopeECRPush:
+	case ScopePulumiDeploy, ScopePulumiPreview:
+		if _, ok := deployStatements[target]; !ok {
+			return "", "", fmt.Errorf("scope %q: unknown stack %q (known stacks: %s)", scope, target, strings.Join(stackNames(), ", "))
+		}
+	default:
+		return "", "", fmt.Errorf("scope %q: unknown kind %q (known kinds: %s, %s, %s)", scope, kind, ScopeECRPush, ScopePulumiDeploy, ScopePulumiPreview)
+	}
+	return kind, target, nil
+}
+
+// Repositories returns the ECR repositories the bot may push to.
+func (b Bot) Repositories() []string {
+	return scopeRepositories(b.Scopes)
+}
+
+// scopeRepositories returns the targets of the ecr-push scopes.
+func scopeRepositories(scopes []string) []string {
+	var repos []string
+	for _, scope := range scopes {
+		if kind, target, err := parseScope(scope); err == nil && kind == ScopeECRPush {
+			repos = append(repos, target)
+		}
+	}
+	return repos
+}
+
+// ScopePolicy builds the IAM policy document granting scopes in the given
+// account and region. It returns nil if there are no scopes.
+func ScopePolicy(scopes []string, accountID, region string) (map[string]interface{}, error) {
+	var statements []map[string]interface{}
+	var repoArns []string
+	for _, scope := range scopes {
+		kind, target, err := parseScope(scope)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case ScopeECRPush:
+			repoArns = append(repoArns, fmt.Sprintf("arn:aws:ecr:%s:%s:repository/%s", region, accountID, target))
+		case ScopePulumiDeploy:
+			for _, s := range deployStatements[target](accountID, region) {
+				s["Sid"] = "Deploy" + strings.ToUpper(target[:1]) + target[1:] + s["Sid"].(string)
+				statements = append(statements, s)
+			}
+		case ScopePulumiPreview:
+			statements = append(statements, map[string]interface{}{
+				"Sid":      "Preview" + strings.ToUpper(target[:1]) + target[1:],
+				"Effect":   "Allow",
+				"Action":   previewActions[target],
+				"Resource": "*",
+			})
+			// The platform's Kubernetes resources are only reachable through the tunnel.
+			if target == "platform" {
+				statements = append(statements, tunnelStatement("PreviewPlatformTunnel", accountID))
+			}
+		}
+	}
+	if len(repoArns) > 0 {
+		statements = append([]map[string]interface{}{
+			{
+				"Sid":      "ECRLogin",
+				"Effect":   "Allow",
+				"Action":   "ecr:GetAuthorizationToken",
+				"Resource": "*",
+			},
+			{
+				"Sid":      "ECRPush",
+				"Effect":   "Allow",
+				"Action":   ecrPushActions,
+				"Resource": repoArns,
+			},
+		}, statements...)
+	}
+	if len(statements) == 0 {
+		return nil, nil
+	}
+	return map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": statements,
+	}, nil
+}
+
+// CreateBots creates an IAM user for every bot, puts them all in the "bots"
+// group and gives each an inline policy generated from its scopes. A deploy
+// scope is too big for a user's inline policy, so each gets a managed policy
+// under Path instead. The group itself grants nothing. Access keys are only
+// created for bots that opt in. The returned map holds the per-bot stack
+// outputs.
+func CreateBots(ctx *pulumi.Context, bots []Bot) (pulumi.Map, error) {
+	exports := pulumi.Map{}
+	var botNames []string
+
+	caller, err := aws.GetCallerIdentity(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	region, err := aws.GetRegion(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bot := range bots {
+		resourceName := botPrefix + ResourceName(bot.Name)
+
+		user, err := iam.NewUser(ctx, resourceName, &iam.UserArgs{
+			Name: pulumi.String(resourceName),
+			Path: pulumi.String(Path),
+			Tags: pulumi.StringMap{
+				"Type": pulumi.String("Bot"),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		exports["UserARN-"+resourceName] = user.Arn
+
+		// Create Access Keys (opt-in)
+		if bot.AccessKey {
+			key, err := iam.NewAccessKey(ctx, "key-"+resourceName, &iam.AccessKeyArgs{
+				User: user.Name,
+			})
+			if err != nil {
+				return nil, err
+			}
+			exports["AccessKeyId-"+resourceName] = key.ID()
+			exports["SecretAccessKey-"+resourceName] = pulumi.ToSecret(key.Secret)
+		}
+
+		// Grant exactly the declared scopes
+		var inline []string
+		for _, scope := range bot.Scopes {
+			kind, target, err := parseScope(scope)
+			if err != nil {
+				return nil, err
+			}
+			if kind != ScopePulumiDeploy {
+				inline = append(inline, scope)
+				continue
+			}
+			if err := createDeployPolicy(ctx, resourceName+"-deploy-"+target, user, scope, caller.AccountId, region.Name); err != nil {
+				return nil, err
+			}
+		}
+		policy, err := ScopePolicy(inline, caller.AccountId, region.Name)
+		if err != nil {
+			return nil, err
+		}
+		if policy != nil {
+			policyJSON, err := json.Marshal(policy)
+			if err != nil {
+				return nil, err
+			}
+			_, err = iam.NewUserPolicy(ctx, resourceName+"-scopes", &iam.UserPolicyArgs{
+				User:   user.Name,
+				Name:   pulumi.String("scopes"),
+				Policy: pulumi.String(string(policyJSON)),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Collect bot names for group membership
+		botNames = append(botNames, resourceName)
+	}
+
+	// Create Bots Group
+	botsGroup, err := iam.NewGroup(ctx, "bots", &iam.GroupArgs{
+		Name: pulumi.String("bots"),
+		Path: pulumi.String(Path),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Add Bots to Group
+	for _, bName := range botNames {
+		_, err := iam.NewUserGroupMembership(ctx, "membership-"+bName, &iam.UserGroupMembershipArgs{
+			User: pulumi.String(bName),
+			Groups: pulumi.StringArray{
+				botsGroup.Name,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exports, nil
+}
+
+func stackNames() []string {
+	names := make([]string, 0, len(deployStatements))
+	for name := range deployStatements {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// createDeployPolicy attaches the managed policy granting a deploy scope to
+// user.
+func createDeployPolicy(ctx *pulumi.Context, name string, user *iam.User, scope, accountID, region string) error {
+	doc, err := ScopePolicy([]string{scope}, accountID, region)
+	if err != nil {
+		return err
+	}
+	docJSON, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	policy, err := iam.NewPolicy(ctx, name, &iam.PolicyArgs{
+		Name:   pulumi.String(name),
+		Path:   pulumi.String(Path),
+		Policy: pulumi.String(string(docJSON)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = iam.NewUserPolicyAttachment(ctx, name, &iam.UserPolicyAttachmentArgs{
+		User:      user.Name,
+		PolicyArn: policy.Arn,
+	})
+	return err
+}
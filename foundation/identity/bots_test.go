@@ -0,0 +1,69 @@
+package identity
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestScopePolicy(t *testing.T) {
+	scopes := []string{"ecr-push:josh-app", "pulumi-deploy:foundation", "pulumi-preview:platform"}
+	policy, err := ScopePolicy(scopes, "123456789012", "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"Sid":"ECRLogin"`,
+		`"Resource":["arn:aws:ecr:us-east-1:123456789012:repository/josh-app"]`,
+		`"Sid":"DeployFoundationIAMBoundedPrincipals"`,
+		`"iam:PermissionsBoundary":"arn:aws:iam::123456789012:policy/teamchikynbitts-boundary"`,
+		`"Resource":["arn:aws:iam::123456789012:user/teamchikynbitts/bot-*","arn:aws:iam::123456789012:role/github-*","arn:aws:iam::123456789012:role/budget-shutdown-*"]`,
+		`{"Action":["iam:CreateUser"],"Effect":"Allow","Resource":["arn:aws:iam::123456789012:user/teamchikynbitts/*"],"Sid":"DeployFoundationIAMPeople"}`,
+		`"Resource":["arn:aws:lambda:us-east-1:123456789012:function:budget-shutdown-*"]`,
+		`"Sid":"PreviewPlatform"`,
+		`{"Action":["ssm:StartSession"],"Condition":{"BoolIfExists":{"ssm:SessionDocumentAccessCheck":"true"}},"Effect":"Allow","Resource":["arn:aws:ssm:*::document/AWS-StartPortForwardingSession","arn:aws:ec2:*:123456789012:instance/*"],"Sid":"PreviewPlatformTunnel"}`,
+	} {
+		if !strings.Contains(string(doc), want) {
+			t.Errorf("policy does not contain %s:\n%s", want, doc)
+		}
+	}
+	if strings.Contains(string(doc), "AdministratorAccess") {
+		t.Errorf("policy grants admin:\n%s", doc)
+	}
+}
+
+func TestDeployScopesAreScoped(t *testing.T) {
+	for _, stack := range stackNames() {
+		for _, s := range deployStatements[stack]("123456789012", "us-east-1") {
+			resources := s["Resource"].([]string)
+			if len(resources) != 1 || resources[0] != "*" {
+				continue
+			}
+			_, conditional := s["Condition"]
+			for _, action := range s["Action"].([]string) {
+				if strings.HasSuffix(action, ":*") || (!conditional && strings.HasPrefix(action, "iam:") &&
+					!strings.HasPrefix(action, "iam:Get") && !strings.HasPrefix(action, "iam:List") && !strings.Contains(action, "PasswordPolicy")) {
+					t.Errorf("%s %s: %s is granted on every resource", stack, s["Sid"], action)
+				}
+			}
+		}
+	}
+}
+
+func TestScopePolicyWithoutScopes(t *testing.T) {
+	policy, err := ScopePolicy(nil, "123456789012", "us-east-1")
+	if err != nil || policy != nil {
+		t.Errorf("expected no policy, got %v, %v", policy, err)
+	}
+}
+
+func TestRepositories(t *testing.T) {
+	bot := Bot{Scopes: []string{"ecr-push:a", "pulumi-deploy:platform", "ecr-push:b"}}
+	if got := strings.Join(bot.Repositories(), ","); got != "a,b" {
+		t.Errorf("got %s", got)
+	}
+}
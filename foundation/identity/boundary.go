@@ -0,0 +1,110 @@
+package identity
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// BoundaryName is the permissions boundary every bot and IAM role of both
+// stacks carries. The deploy scopes may only create or change bots and roles
+// that carry it, so a deploy role cannot mint itself an unbounded principal.
+// People don't carry it, so administrators can still change it.
+const BoundaryName = "teamchikynbitts-boundary"
+
+// BoundaryARN is the ARN of the permissions boundary in accountID.
+func BoundaryARN(accountID string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:policy/%s", accountID, BoundaryName)
+}
+
+// boundaryPolicyDocument allows everything a principal's own policies grant,
+// except changing or removing the boundary and creating bots or roles
+// without it.
+var boundaryPolicyDocument = map[string]interface{}{
+	"Version": "2012-10-17",
+	"Statement": []map[string]interface{}{
+		{
+			"Sid":      "AllowWithinPolicies",
+			"Effect":   "Allow",
+			"Action":   "*",
+			"Resource": "*",
+		},
+		{
+			"Sid":    "DenyBoundaryChanges",
+			"Effect": "Deny",
+			"Action": []string{
+				"iam:CreatePolicyVersion",
+				"iam:DeletePolicy",
+				"iam:DeletePolicyVersion",
+				"iam:SetDefaultPolicyVersion",
+			},
+			"Resource": "arn:aws:iam::*:policy/" + BoundaryName,
+		},
+		{
+			"Sid":    "DenyBoundaryRemoval",
+			"Effect": "Deny",
+			"Action": []string{
+				"iam:DeleteRolePermissionsBoundary",
+				"iam:DeleteUserPermissionsBoundary",
+			},
+			"Resource": "*",
+		},
+		{
+			"Sid":    "DenyUnboundedPrincipals",
+			"Effect": "Deny",
+			"Action": []string{
+				"iam:CreateRole",
+				"iam:CreateUser",
+				"iam:PutRolePermissionsBoundary",
+				"iam:PutUserPermissionsBoundary",
+			},
+			"Resource": []string{
+				"arn:aws:iam::*:role/*",
+				"arn:aws:iam::*:user" + Path + botPrefix + "*",
+			},
+			"Condition": map[string]interface{}{
+				"StringNotLike": map[string]interface{}{
+					"iam:PermissionsBoundary": "arn:aws:iam::*:policy/" + BoundaryName,
+				},
+			},
+		},
+	},
+}
+
+// CreateBoundary creates the permissions boundary and adds a stack
+// transformation that sets it on every bot and IAM role registered
+// afterwards. The boundary itself can only be changed outside the deploy
+// scopes, by an administrator.
+func CreateBoundary(ctx *pulumi.Context) (*iam.Policy, error) {
+	boundary, err := iam.NewPolicy(ctx, "permissions-boundary", &iam.PolicyArgs{
+		Name:        pulumi.String(BoundaryName),
+		Description: pulumi.String("Permissions boundary of every user and role the teamchikynbitts stacks manage."),
+		Policy:      pulumi.Any(boundaryPolicyDocument),
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		switch props := args.Props.(type) {
+		case *iam.UserArgs:
+			if props != nil && props.PermissionsBoundary == nil && strings.HasPrefix(args.Name, botPrefix) {
+				copied := *props
+				copied.PermissionsBoundary = boundary.Arn
+				return &pulumi.ResourceTransformationResult{Props: &copied, Opts: args.Opts}
+			}
+		case *iam.RoleArgs:
+			if props != nil && props.PermissionsBoundary == nil {
+				copied := *props
+				copied.PermissionsBoundary = boundary.Arn
+				return &pulumi.ResourceTransformationResult{Props: &copied, Opts: args.Opts}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return boundary, nil
+}
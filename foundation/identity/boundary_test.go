@@ -0,0 +1,63 @@
+package identity
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-foundation/internal/mocks"
+)
+
+func TestCreateBoundary(t *testing.T) {
+	m := &mocks.Mocks{}
+	err := m.Run(func(ctx *pulumi.Context) error {
+		if _, err := CreateBoundary(ctx); err != nil {
+			return err
+		}
+		if _, err := iam.NewRole(ctx, "github-deploy", &iam.RoleArgs{AssumeRolePolicy: pulumi.String("{}")}); err != nil {
+			return err
+		}
+		if _, err := iam.NewUser(ctx, "bot-ci", &iam.UserArgs{}); err != nil {
+			return err
+		}
+		if _, err := iam.NewUser(ctx, "user-joshua-hayes", &iam.UserArgs{}); err != nil {
+			return err
+		}
+		_, err := iam.NewGroup(ctx, "bots", &iam.GroupArgs{})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, ok := m.ByType("aws:iam/policy:Policy")["permissions-boundary"]
+	if !ok {
+		t.Fatal("missing permissions-boundary")
+	}
+	if got := policy.Inputs["name"].StringValue(); got != BoundaryName {
+		t.Errorf("boundary is named %q", got)
+	}
+	doc, err := json.Marshal(policy.Inputs["policy"].Mappable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Sid":"DenyBoundaryChanges"`, `"Sid":"DenyBoundaryRemoval"`, `"Sid":"DenyUnboundedPrincipals"`} {
+		if !strings.Contains(string(doc), want) {
+			t.Errorf("boundary has no %s:\n%s", want, doc)
+		}
+	}
+
+	want := "arn:aws:mock:permissions-boundary"
+	for _, r := range []pulumi.MockResourceArgs{m.ByType("aws:iam/role:Role")["github-deploy"], m.ByType("aws:iam/user:User")["bot-ci"]} {
+		if got := r.Inputs["permissionsBoundary"]; !got.IsString() || got.StringValue() != want {
+			t.Errorf("%s %s has permissions boundary %v, want %s", r.TypeToken, r.Name, got, want)
+		}
+	}
+	// People, administrators included, stay free to change the boundary.
+	if got := m.ByType("aws:iam/user:User")["user-joshua-hayes"].Inputs["permissionsBoundary"]; !got.IsNull() {
+		t.Errorf("user-joshua-hayes has permissions boundary %v", got)
+	}
+}
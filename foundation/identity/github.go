@@ -0,0 +1,202 @@
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// GitHub OIDC issuer and the audience aws-actions/configure-aws-credentials
+// requests tokens for.
+const (
+	githubIssuer   = "token.actions.githubusercontent.com"
+	githubAudience = "sts.amazonaws.com"
+)
+
+// repositoryPattern matches a GitHub "owner/name" repository.
+var repositoryPattern = regexp.MustCompile(`^[\w.-]+/[\w.-]+$`)
+
+// GitHub describes the repository whose Actions workflows assume roles in
+// the account through OIDC instead of using long-lived access keys.
+type GitHub struct {
+	Repository string     `json:"repository"`
+	Workflows  []Workflow `json:"workflows"`
+}
+
+// Workflow is one role GitHub Actions can assume. The role may only be
+// assumed from the listed branches, deployment environments and, if
+// PullRequests is set, pull request runs. Scopes are the same as Bot.Scopes.
+type Workflow struct {
+	Name         string   `json:"name"`
+	Branches     []string `json:"branches,omitempty"`
+	Environments []string `json:"environments,omitempty"`
+	PullRequests bool     `json:"pull_requests,omitempty"`
+	Scopes       []string `json:"scopes"`
+}
+
+// RoleName is the IAM role name of the workflow.
+func (w Workflow) RoleName() string {
+	return "github-" + ResourceName(w.Name)
+}
+
+// Repositories returns the ECR repositories the workflow may push to.
+func (w Workflow) Repositories() []string {
+	return scopeRepositories(w.Scopes)
+}
+
+// Subjects returns the OIDC "sub" claims allowed to assume the role.
+func (w Workflow) Subjects(repository string) []string {
+	var subjects []string
+	for _, branch := range w.Branches {
+		subjects = append(subjects, fmt.Sprintf("repo:%s:ref:refs/heads/%s", repository, branch))
+	}
+	for _, env := range w.Environments {
+		subjects = append(subjects, fmt.Sprintf("repo:%s:environment:%s", repository, env))
+	}
+	if w.PullRequests {
+		subjects = append(subjects, fmt.Sprintf("repo:%s:pull_request", repository))
+	}
+	return subjects
+}
+
+// TrustPolicy builds the role trust policy letting the workflow assume the
+// role through the given OIDC provider.
+func (w Workflow) TrustPolicy(repository, providerArn string) map[string]interface{} {
+	return map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Effect": "Allow",
+				"Principal": map[string]interface{}{
+					"Federated": providerArn,
+				},
+				"Action": "sts:AssumeRoleWithWebIdentity",
+				"Condition": map[string]interface{}{
+					"StringEquals": map[string]interface{}{
+						githubIssuer + ":aud": githubAudience,
+					},
+					"StringLike": map[string]interface{}{
+						githubIssuer + ":sub": w.Subjects(repository),
+					},
+				},
+			},
+		},
+	}
+}
+
+// Validate checks the repository and every workflow, returning every problem
+// found.
+func (g GitHub) Validate() []error {
+	var errs []error
+	if len(g.Workflows) > 0 && !repositoryPattern.MatchString(g.Repository) {
+		errs = append(errs, fmt.Errorf("github: repository %q must be of the form <owner>/<name>", g.Repository))
+	}
+	seen := map[string]string{}
+	for _, w := range g.Workflows {
+		if strings.TrimSpace(w.Name) == "" {
+			errs = append(errs, fmt.Errorf("github workflow has an empty name"))
+			continue
+		}
+		role := w.RoleName()
+		if !iamNamePattern.MatchString(role) {
+			errs = append(errs, fmt.Errorf("github workflow %q: IAM role name %q must be 1-64 characters of letters, digits and +=,.@_-", w.Name, role))
+		}
+		if other, ok := seen[role]; ok {
+			errs = append(errs, fmt.Errorf("github workflow %q: IAM role name %q is already used by workflow %q", w.Name, role, other))
+		}
+		seen[role] = w.Name
+		if len(w.Subjects(g.Repository)) == 0 {
+			errs = append(errs, fmt.Errorf("github workflow %q: set at least one of branches, environments or pull_requests", w.Name))
+		}
+		for _, subject := range append(append([]string{}, w.Branches...), w.Environments...) {
+			if strings.TrimSpace(subject) == "" || strings.Contains(subject, ":") {
+				errs = append(errs, fmt.Errorf("github workflow %q: invalid branch or environment %q", w.Name, subject))
+			}
+		}
+		if len(w.Scopes) == 0 {
+			errs = append(errs, fmt.Errorf("github workflow %q has no scopes", w.Name))
+		}
+		for _, scope := range w.Scopes {
+			if _, _, err := parseScope(scope); err != nil {
+				errs = append(errs, fmt.Errorf("github workflow %q: %w", w.Name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// CreateGitHubRoles registers the GitHub Actions OIDC provider and one role
+// per workflow, each with an inline policy generated from its scopes. Nothing
+// is created when there are no workflows. The returned map holds the role
+// ARNs to configure in the workflows.
+func CreateGitHubRoles(ctx *pulumi.Context, github GitHub) (pulumi.Map, error) {
+	exports := pulumi.Map{}
+	if len(github.Workflows) == 0 {
+		return exports, nil
+	}
+
+	caller, err := aws.GetCallerIdentity(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	region, err := aws.GetRegion(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := iam.NewOpenIdConnectProvider(ctx, "github-oidc", &iam.OpenIdConnectProviderArgs{
+		Url:           pulumi.String("https://" + githubIssuer),
+		ClientIdLists: pulumi.StringArray{pulumi.String(githubAudience)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	exports["GitHubOIDCProviderARN"] = provider.Arn
+
+	for _, workflow := range github.Workflows {
+		roleName := workflow.RoleName()
+
+		trust := provider.Arn.ApplyT(func(arn string) (string, error) {
+			doc, err := json.Marshal(workflow.TrustPolicy(github.Repository, arn))
+			return string(doc), err
+		}).(pulumi.StringOutput)
+
+		role, err := iam.NewRole(ctx, roleName, &iam.RoleArgs{
+			Name:             pulumi.String(roleName),
+			AssumeRolePolicy: trust,
+			Tags: pulumi.StringMap{
+				"Type": pulumi.String("GitHubActions"),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		exports["GitHubRoleARN-"+ResourceName(workflow.Name)] = role.Arn
+
+		policy, err := ScopePolicy(workflow.Scopes, caller.AccountId, region.Name)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			continue
+		}
+		policyJSON, err := json.Marshal(policy)
+		if err != nil {
+			return nil, err
+		}
+		_, err = iam.NewRolePolicy(ctx, roleName+"-scopes", &iam.RolePolicyArgs{
+			Role:   role.Name,
+			Name:   pulumi.String("scopes"),
+			Policy: pulumi.String(string(policyJSON)),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exports, nil
+}
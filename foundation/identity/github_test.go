@@ -0,0 +1,137 @@
+package identity
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-foundation/internal/jsonfile"
+	"teamchikynbitts-foundation/internal/mocks"
+)
+
+func TestWorkflowTrustPolicy(t *testing.T) {
+	w := Workflow{Name: "deploy", Branches: []string{"main"}, Environments: []string{"production"}, PullRequests: true}
+	doc, err := json.Marshal(w.TrustPolicy("owner/repo", "arn:aws:iam::123456789012:oidc-provider/token.actions.githubusercontent.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"Action":"sts:AssumeRoleWithWebIdentity"`,
+		`"token.actions.githubusercontent.com:aud":"sts.amazonaws.com"`,
+		`"token.actions.githubusercontent.com:sub":["repo:owner/repo:ref:refs/heads/main","repo:owner/repo:environment:production","repo:owner/repo:pull_request"]`,
+	} {
+		if !strings.Contains(string(doc), want) {
+			t.Errorf("trust policy does not contain %s:\n%s", want, doc)
+		}
+	}
+}
+
+func TestGitHubValidate(t *testing.T) {
+	github := GitHub{
+		Repository: "owner/repo",
+		Workflows: []Workflow{
+			{Name: "build", Branches: []string{"main"}, Scopes: []string{"ecr-push:josh-app"}},
+			{Name: "Build", Branches: []string{"*"}, Scopes: []string{"ecr-push:josh-app"}},
+			{Name: "idle", Scopes: []string{"pulumi-deploy:staging"}},
+			{Name: "env", Environments: []string{"prod:eu"}},
+		},
+	}
+	errs := github.Validate()
+	joined := ""
+	for _, err := range errs {
+		joined += err.Error() + "\n"
+	}
+	for _, want := range []string{
+		`"github-build" is already used by workflow "build"`,
+		`github workflow "idle": set at least one of branches, environments or pull_requests`,
+		`unknown stack "staging"`,
+		`invalid branch or environment "prod:eu"`,
+		`github workflow "env" has no scopes`,
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("errors do not mention %s:\n%s", want, joined)
+		}
+	}
+	if len(errs) != 5 {
+		t.Errorf("expected 5 errors, got %d:\n%s", len(errs), joined)
+	}
+}
+
+func TestCreateGitHubRoles(t *testing.T) {
+	var github GitHub
+	if err := jsonfile.Load("../github.json", &github); err != nil {
+		t.Fatal(err)
+	}
+	if errs := github.Validate(); len(errs) > 0 {
+		t.Fatalf("github.json is invalid: %v", errs)
+	}
+
+	m := &mocks.Mocks{}
+	var exports pulumi.Map
+	err := m.Run(func(ctx *pulumi.Context) error {
+		var err error
+		exports, err = CreateGitHubRoles(ctx, github)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, ok := m.ByType("aws:iam/openIdConnectProvider:OpenIdConnectProvider")["github-oidc"]
+	if !ok {
+		t.Fatal("missing github-oidc provider")
+	}
+	if got := provider.Inputs["url"].StringValue(); got != "https://token.actions.githubusercontent.com" {
+		t.Errorf("provider url is %q", got)
+	}
+
+	roles := m.ByType("aws:iam/role:Role")
+	policies := m.ByType("aws:iam/rolePolicy:RolePolicy")
+	for _, name := range []string{"build-apps", "pulumi-preview", "pulumi-deploy"} {
+		if _, ok := roles["github-"+name]; !ok {
+			t.Errorf("missing role github-%s", name)
+		}
+		if _, ok := policies["github-"+name+"-scopes"]; !ok {
+			t.Errorf("missing policy github-%s-scopes", name)
+		}
+		if _, ok := exports["GitHubRoleARN-"+name]; !ok {
+			t.Errorf("missing export GitHubRoleARN-%s", name)
+		}
+	}
+
+	deploy := roles["github-pulumi-deploy"].Inputs["assumeRolePolicy"].StringValue()
+	if !strings.Contains(deploy, "repo:joshuamdhayes/teamchikynbitts:environment:production") {
+		t.Errorf("deploy role is not restricted to the production environment:\n%s", deploy)
+	}
+	if strings.Contains(deploy, "pull_request") {
+		t.Errorf("deploy role can be assumed from pull requests:\n%s", deploy)
+	}
+	preview := policies["github-pulumi-preview-scopes"].Inputs["policy"].StringValue()
+	var doc struct{ Statement []struct{ Action []string } }
+	if err := json.Unmarshal([]byte(preview), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range doc.Statement {
+		for _, action := range s.Action {
+			if strings.HasSuffix(action, ":*") {
+				t.Errorf("preview role is not read-only: %s\n%s", action, preview)
+			}
+		}
+	}
+}
+
+func TestCreateGitHubRolesWithoutWorkflows(t *testing.T) {
+	m := &mocks.Mocks{}
+	err := m.Run(func(ctx *pulumi.Context) error {
+		_, err := CreateGitHubRoles(ctx, GitHub{})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Count(); got != 0 {
+		t.Errorf("expected no resources, got %d", got)
+	}
+}
@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Group is a functional IAM group in the catalogue. It gets every managed
+// policy in ManagedPolicies attached and every document in InlinePolicies
+// embedded, keyed by inline policy name.
+type Group struct {
+	ManagedPolicies []string                   `json:"managed_policies,omitempty"`
+	InlinePolicies  map[string]json.RawMessage `json:"inline_policies,omitempty"`
+}
+
+// Catalogue maps group names to their definitions (the "groups" stack config
+// key, or groups.json).
+type Catalogue map[string]Group
+
+// Names returns the group names in sorted order.
+func (c Catalogue) Names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// iamGroupPattern is what AWS accepts for an IAM group or inline policy name.
+var iamGroupPattern = regexp.MustCompile(`^[\w+=,.@-]{1,128}$`)
+
+// Validate checks every group has a valid name and at least one policy, that
+// managed policies are IAM policy ARNs and that inline policies are policy
+// documents.
+func (c Catalogue) Validate() []error {
+	var errs []error
+	for _, name := range c.Names() {
+		group := c[name]
+		if !iamGroupPattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("group %q: name must be 1-128 characters of letters, digits and +=,.@_-", name))
+		}
+		if name == "bots" {
+			errs = append(errs, fmt.Errorf(`group "bots" is reserved for bot users`))
+		}
+		if len(group.ManagedPolicies) == 0 && len(group.InlinePolicies) == 0 {
+			errs = append(errs, fmt.Errorf("group %q has no managed or inline policies", name))
+		}
+		for _, arn := range group.ManagedPolicies {
+			if !strings.HasPrefix(arn, "arn:aws:iam::") || !strings.Contains(arn, ":policy/") {
+				errs = append(errs, fmt.Errorf("group %q: %q is not an IAM policy ARN", name, arn))
+			}
+		}
+		for policyName, doc := range group.InlinePolicies {
+			if !iamGroupPattern.MatchString(policyName) {
+				errs = append(errs, fmt.Errorf("group %q: invalid inline policy name %q", name, policyName))
+			}
+			var parsed struct {
+				Version   string            `json:"Version"`
+				Statement []json.RawMessage `json:"Statement"`
+			}
+			if err := json.Unmarshal(doc, &parsed); err != nil || parsed.Version == "" || len(parsed.Statement) == 0 {
+				errs = append(errs, fmt.Errorf("group %q: inline policy %q must be a policy document with a Version and at least one Statement", name, policyName))
+			}
+		}
+	}
+	return errs
+}
+
+// CreateGroups creates one IAM group per catalogue entry with its managed
+// policy attachments and inline policies.
+func CreateGroups(ctx *pulumi.Context, catalogue Catalogue) (map[string]*iam.Group, error) {
+	groups := make(map[string]*iam.Group)
+	for groupName, def := range catalogue {
+		g, err := iam.NewGroup(ctx, groupName, &iam.GroupArgs{
+			Name: pulumi.String(groupName),
+			Path: pulumi.String(Path),
+		})
+		if err != nil {
+			return nil, err
+		}
+		groups[groupName] = g
+
+		for _, policyArn := range def.ManagedPolicies {
+			var opts []pulumi.ResourceOption
+			if len(def.ManagedPolicies) == 1 {
+				// Groups used to have exactly one attachment named
+				// "<group>-policy"; keep it rather than detach and reattach.
+				opts = append(opts, pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String(groupName + "-policy")}}))
+			}
+			_, err = iam.NewGroupPolicyAttachment(ctx, groupName+"-policy-"+policyNameFromArn(policyArn), &iam.GroupPolicyAttachmentArgs{
+				Group:     g.Name,
+				PolicyArn: pulumi.String(policyArn),
+			}, opts...)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for policyName, doc := range def.InlinePolicies {
+			_, err = iam.NewGroupPolicy(ctx, groupName+"-inline-"+policyName, &iam.GroupPolicyArgs{
+				Group:  g.Name,
+				Name:   pulumi.String(policyName),
+				Policy: pulumi.String(string(doc)),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return groups, nil
+}
+
+// policyNameFromArn returns the last path segment of a policy ARN, e.g.
+// "Billing" for arn:aws:iam::aws:policy/job-function/Billing.
+func policyNameFromArn(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
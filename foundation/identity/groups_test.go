@@ -0,0 +1,91 @@
+package identity
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-foundation/internal/mocks"
+)
+
+func TestShippedCatalogueIsValid(t *testing.T) {
+	if errs := loadCatalogue(t).Validate(); len(errs) > 0 {
+		t.Errorf("groups.json is invalid: %v", errs)
+	}
+}
+
+func TestCatalogueValidate(t *testing.T) {
+	catalogue := Catalogue{
+		"empty":    {},
+		"bots":     {ManagedPolicies: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}},
+		"bad arn":  {ManagedPolicies: []string{"ReadOnlyAccess"}},
+		"bad-doc":  {InlinePolicies: map[string]json.RawMessage{"p": json.RawMessage(`{"Statement": []}`)}},
+		"good-one": {InlinePolicies: map[string]json.RawMessage{"p": json.RawMessage(`{"Version": "2012-10-17", "Statement": [{}]}`)}},
+	}
+	var all []string
+	for _, err := range catalogue.Validate() {
+		all = append(all, err.Error())
+	}
+	joined := strings.Join(all, "\n")
+	for _, want := range []string{
+		`group "empty" has no managed or inline policies`,
+		`group "bots" is reserved`,
+		`group "bad arn": name must be`,
+		`"ReadOnlyAccess" is not an IAM policy ARN`,
+		`group "bad-doc": inline policy "p"`,
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("errors do not mention %s:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "good-one") {
+		t.Errorf("valid group reported:\n%s", joined)
+	}
+}
+
+func TestCreateGroups(t *testing.T) {
+	catalogue := loadCatalogue(t)
+
+	m := &mocks.Mocks{}
+	err := m.Run(func(ctx *pulumi.Context) error {
+		groups, err := CreateGroups(ctx, catalogue)
+		if err != nil {
+			return err
+		}
+		return EnforceMFA(ctx, groups)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachments := m.ByType("aws:iam/groupPolicyAttachment:GroupPolicyAttachment")
+	for _, name := range []string{
+		"technical-policy-AdministratorAccess",
+		"billing-policy-Billing",
+		"auditors-policy-ReadOnlyAccess",
+	} {
+		if _, ok := attachments[name]; !ok {
+			t.Errorf("missing attachment %s", name)
+		}
+	}
+
+	inline := m.ByType("aws:iam/groupPolicy:GroupPolicy")
+	for _, name := range []string{"app-developers-inline-ecr-push", "app-developers-inline-read-cluster"} {
+		policy, ok := inline[name]
+		if !ok {
+			t.Errorf("missing inline policy %s", name)
+			continue
+		}
+		if !json.Valid([]byte(policy.Inputs["policy"].StringValue())) {
+			t.Errorf("%s is not valid JSON", name)
+		}
+	}
+
+	for group := range catalogue {
+		if _, ok := attachments["mfa-enforcement-attach-"+group]; !ok {
+			t.Errorf("MFA not enforced on %s", group)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package identity manages the IAM side of the foundation stack: functional
+// groups, human users, least-privilege bots, the account password policy and
+// MFA enforcement.
+package identity
+
+import (
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// User is a single entry of the users list. Users only get a programmatic
+// access key if AccessKey is set. A Disabled user keeps its IAM user but loses
+// console access and group memberships, and its access keys are deactivated;
+// offboarding disables a user before removing it.
+type User struct {
+	Name      string     `json:"name"`
+	Groups    []string   `json:"groups"`
+	AccessKey *AccessKey `json:"access_key,omitempty"`
+	Disabled  bool       `json:"disabled,omitempty"`
+}
+
+// Path is the IAM path of every user and group the foundation manages; the
+// deploy scope may only change users and groups under it.
+const Path = "/teamchikynbitts/"
+
+// ResourceName sanitizes a display name for resource names (spaces to dashes,
+// lowercase). AWS does NOT allow spaces in IAM user names.
+func ResourceName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), " ", "-")
+}
+
+// CreateUsers creates an IAM user with a console login profile for every
+// human user, plus rotating access keys for those who opted in, and adds them
+// to their functional groups. Disabled users get neither a login profile nor
+// memberships and their keys are inactive. The returned map holds the
+// per-user stack outputs.
+func CreateUsers(ctx *pulumi.Context, users []User, groups map[string]*iam.Group) (pulumi.Map, error) {
+	exports := pulumi.Map{}
+	for _, userCfg := range users {
+		resourceName := ResourceName(userCfg.Name)
+
+		user, err := iam.NewUser(ctx, "user-"+resourceName, &iam.UserArgs{
+			Name: pulumi.String(resourceName),
+			Path: pulumi.String(Path),
+			// Users register MFA devices themselves; let removal clean them up.
+			ForceDestroy: pulumi.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		exports["UserARN-"+resourceName] = user.Arn
+
+		// Create Access Keys (opt-in)
+		if userCfg.AccessKey != nil {
+			if err := createAccessKeys(ctx, resourceName, user, *userCfg.AccessKey, !userCfg.Disabled, exports); err != nil {
+				return nil, err
+			}
+		}
+
+		if userCfg.Disabled {
+			continue
+		}
+
+		// Create User Login Profile (Enables Console Access)
+		profile, err := iam.NewUserLoginProfile(ctx, "profile-"+resourceName, &iam.UserLoginProfileArgs{
+			User:                  user.Name,
+			PasswordResetRequired: pulumi.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		exports["ConsolePassword-"+resourceName] = pulumi.ToSecret(profile.Password)
+
+		// Add to Groups defined in JSON
+		for _, gName := range userCfg.Groups {
+			if group, ok := groups[gName]; ok {
+				_, err := iam.NewUserGroupMembership(ctx, "membership-"+resourceName+"-"+gName, &iam.UserGroupMembershipArgs{
+					User: user.Name,
+					Groups: pulumi.StringArray{
+						group.Name,
+					},
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return exports, nil
+}
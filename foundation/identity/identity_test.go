@@ -0,0 +1,275 @@
+package identity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-foundation/internal/jsonfile"
+	"teamchikynbitts-foundation/internal/mocks"
+)
+
+func TestResourceName(t *testing.T) {
+	if got := ResourceName("Joshua Hayes"); got != "joshua-hayes" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func loadCatalogue(t *testing.T) Catalogue {
+	t.Helper()
+	var catalogue Catalogue
+	if err := jsonfile.Load("../groups.json", &catalogue); err != nil {
+		t.Fatal(err)
+	}
+	return catalogue
+}
+
+func TestCreateUsers(t *testing.T) {
+	catalogue := loadCatalogue(t)
+	var users []User
+	if err := jsonfile.Load("testdata/users.json", &users); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mocks.Mocks{}
+	var exports pulumi.Map
+	err := m.Run(func(ctx *pulumi.Context) error {
+		groups, err := CreateGroups(ctx, catalogue)
+		if err != nil {
+			return err
+		}
+		exports, err = CreateUsers(ctx, users, groups)
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"ConsolePassword-joshua-hayes", "SecretAccessKey-joshua-hayes"} {
+			if secret, err := mocks.IsSecret(exports[name]); err != nil || !secret {
+				t.Errorf("%s is not a secret output (err %v)", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(m.ByType("aws:iam/group:Group")); got != len(catalogue) {
+		t.Errorf("expected %d groups, got %d", len(catalogue), got)
+	}
+
+	iamUsers := m.ByType("aws:iam/user:User")
+	if len(iamUsers) != len(users) {
+		t.Fatalf("expected %d users, got %d", len(users), len(iamUsers))
+	}
+	for _, name := range []string{"joshua-hayes", "justin-rouse", "abby-adkins"} {
+		user, ok := iamUsers["user-"+name]
+		if !ok {
+			t.Errorf("missing user-%s", name)
+			continue
+		}
+		if got := user.Inputs["name"].StringValue(); got != name {
+			t.Errorf("user-%s has IAM name %q", name, got)
+		}
+		if tags := user.Inputs["tags"]; tags.IsObject() {
+			t.Errorf("user-%s sets its own tags %v; the shared ones come from the tagging module", name, tags)
+		}
+		for _, prefix := range []string{"UserARN-", "ConsolePassword-"} {
+			if _, ok := exports[prefix+name]; !ok {
+				t.Errorf("missing export %s%s", prefix, name)
+			}
+		}
+	}
+
+	// Only Joshua opted into an access key.
+	keys := m.ByType("aws:iam/accessKey:AccessKey")
+	if _, ok := keys["key-joshua-hayes"]; !ok || len(keys) != 1 {
+		t.Errorf("expected only key-joshua-hayes, got %d keys", len(keys))
+	}
+	for _, name := range []string{"AccessKeyId-joshua-hayes", "SecretAccessKey-joshua-hayes"} {
+		if _, ok := exports[name]; !ok {
+			t.Errorf("missing export %s", name)
+		}
+	}
+	if _, ok := exports["AccessKeyId-justin-rouse"]; ok {
+		t.Error("unexpected access key export for justin-rouse")
+	}
+	if got := len(m.ByType("aws:iam/userLoginProfile:UserLoginProfile")); got != len(users) {
+		t.Errorf("expected %d login profiles, got %d", len(users), got)
+	}
+
+	memberships := m.ByType("aws:iam/userGroupMembership:UserGroupMembership")
+	for _, name := range []string{
+		"membership-joshua-hayes-technical",
+		"membership-joshua-hayes-billing",
+		"membership-justin-rouse-technical",
+		"membership-abby-adkins-technical",
+	} {
+		if _, ok := memberships[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+	if len(memberships) != 4 {
+		t.Errorf("expected 4 memberships, got %d", len(memberships))
+	}
+}
+
+func TestCreateUsersDisabled(t *testing.T) {
+	catalogue := loadCatalogue(t)
+	users := []User{{
+		Name:      "Abby Adkins",
+		Groups:    []string{"technical"},
+		AccessKey: &AccessKey{Generation: 1},
+		Disabled:  true,
+	}}
+
+	m := &mocks.Mocks{}
+	var exports pulumi.Map
+	err := m.Run(func(ctx *pulumi.Context) error {
+		groups, err := CreateGroups(ctx, catalogue)
+		if err != nil {
+			return err
+		}
+		exports, err = CreateUsers(ctx, users, groups)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.ByType("aws:iam/user:User")["user-abby-adkins"]; !ok {
+		t.Error("disabled user should be kept until removed")
+	}
+	key, ok := m.ByType("aws:iam/accessKey:AccessKey")["key-abby-adkins"]
+	if !ok {
+		t.Fatal("missing key-abby-adkins")
+	}
+	if got := key.Inputs["status"].StringValue(); got != "Inactive" {
+		t.Errorf("disabled user's key has status %q", got)
+	}
+	if got := len(m.ByType("aws:iam/userLoginProfile:UserLoginProfile")); got != 0 {
+		t.Errorf("disabled user has %d login profiles", got)
+	}
+	if got := len(m.ByType("aws:iam/userGroupMembership:UserGroupMembership")); got != 0 {
+		t.Errorf("disabled user has %d group memberships", got)
+	}
+	if _, ok := exports["ConsolePassword-abby-adkins"]; ok {
+		t.Error("disabled user still exports a console password")
+	}
+}
+
+func TestCreateBots(t *testing.T) {
+	var bots []Bot
+	if err := jsonfile.Load("testdata/bots.json", &bots); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mocks.Mocks{}
+	var exports pulumi.Map
+	err := m.Run(func(ctx *pulumi.Context) error {
+		var err error
+		exports, err = CreateBots(ctx, bots)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot, ok := m.ByType("aws:iam/user:User")["bot-github-actions"]
+	if !ok {
+		t.Fatal("missing bot-github-actions")
+	}
+	if got := bot.Inputs["tags"].ObjectValue()["Type"].StringValue(); got != "Bot" {
+		t.Errorf("bot has Type tag %q", got)
+	}
+	if got := bot.Inputs["path"].StringValue(); got != Path {
+		t.Errorf("bot path %q, want %q", got, Path)
+	}
+	if group, ok := m.ByType("aws:iam/group:Group")["bots"]; !ok {
+		t.Error("missing bots group")
+	} else if got := group.Inputs["path"].StringValue(); got != Path {
+		t.Errorf("bots group path %q, want %q", got, Path)
+	}
+	if _, ok := m.ByType("aws:iam/userGroupMembership:UserGroupMembership")["membership-bot-github-actions"]; !ok {
+		t.Error("bot is not in the bots group")
+	}
+	if got := len(m.ByType("aws:iam/groupPolicyAttachment:GroupPolicyAttachment")); got != 0 {
+		t.Errorf("bots group should grant nothing, got %d policy attachments", got)
+	}
+	policy, ok := m.ByType("aws:iam/userPolicy:UserPolicy")["bot-github-actions-scopes"]
+	if !ok {
+		t.Fatal("missing scoped policy for bot-github-actions")
+	}
+	doc := policy.Inputs["policy"].StringValue()
+	for _, want := range []string{
+		"arn:aws:ecr:us-east-1:123456789012:repository/teamchikynbitts-app",
+		"arn:aws:ecr:us-east-1:123456789012:repository/josh-app",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("scoped policy does not contain %s:\n%s", want, doc)
+		}
+	}
+	if strings.Contains(doc, `"Sid":"Deploy`) {
+		t.Errorf("CI bot can deploy:\n%s", doc)
+	}
+	if _, ok := exports["UserARN-bot-github-actions"]; !ok {
+		t.Error("missing export UserARN-bot-github-actions")
+	}
+	if got := len(m.ByType("aws:iam/accessKey:AccessKey")); got != 0 {
+		t.Errorf("bots should not get access keys by default, got %d", got)
+	}
+	if _, ok := exports["AccessKeyId-bot-github-actions"]; ok {
+		t.Error("unexpected access key export for bot-github-actions")
+	}
+}
+
+func TestCreateBotsWithDeployScope(t *testing.T) {
+	m := &mocks.Mocks{}
+	err := m.Run(func(ctx *pulumi.Context) error {
+		_, err := CreateBots(ctx, []Bot{{Name: "Deployer", Scopes: []string{"ecr-push:josh-app", "pulumi-deploy:platform"}}})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inline := m.ByType("aws:iam/userPolicy:UserPolicy")["bot-deployer-scopes"].Inputs["policy"].StringValue()
+	if !strings.Contains(inline, "repository/josh-app") || strings.Contains(inline, `"Sid":"Deploy`) {
+		t.Errorf("inline policy:\n%s", inline)
+	}
+	policy, ok := m.ByType("aws:iam/policy:Policy")["bot-deployer-deploy-platform"]
+	if !ok {
+		t.Fatal("missing managed deploy policy")
+	}
+	if got := policy.Inputs["path"].StringValue(); got != Path {
+		t.Errorf("deploy policy path %q, want %q", got, Path)
+	}
+	if doc := policy.Inputs["policy"].StringValue(); !strings.Contains(doc, `"Sid":"DeployPlatform`) {
+		t.Errorf("deploy policy does not grant the platform deploy:\n%s", doc)
+	}
+	attachment, ok := m.ByType("aws:iam/userPolicyAttachment:UserPolicyAttachment")["bot-deployer-deploy-platform"]
+	if !ok || attachment.Inputs["policyArn"].StringValue() != "arn:aws:mock:bot-deployer-deploy-platform" {
+		t.Errorf("deploy policy is not attached: %v", attachment.Inputs)
+	}
+}
+
+func TestCreateBotsWithAccessKey(t *testing.T) {
+	m := &mocks.Mocks{}
+	var exports pulumi.Map
+	err := m.Run(func(ctx *pulumi.Context) error {
+		var err error
+		exports, err = CreateBots(ctx, []Bot{{Name: "Legacy CI", AccessKey: true}})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.ByType("aws:iam/accessKey:AccessKey")["key-bot-legacy-ci"]; !ok {
+		t.Error("missing key-bot-legacy-ci")
+	}
+	for _, prefix := range []string{"UserARN-", "AccessKeyId-", "SecretAccessKey-"} {
+		if _, ok := exports[prefix+"bot-legacy-ci"]; !ok {
+			t.Errorf("missing export %sbot-legacy-ci", prefix)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package identity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-foundation/internal/mocks"
+)
+
+func intPtr(i int) *int { return &i }
+
+// setNow pins the rotation clock for the duration of the test.
+func setNow(t *testing.T, date string) {
+	t.Helper()
+	fixed, err := time.Parse(dateLayout, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = func() time.Time { return fixed }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func TestKeyResourceName(t *testing.T) {
+	if got := keyResourceName("joshua-hayes", 1); got != "key-joshua-hayes" {
+		t.Errorf("generation 1: got %q", got)
+	}
+	if got := keyResourceName("joshua-hayes", 3); got != "key-joshua-hayes-gen3" {
+		t.Errorf("generation 3: got %q", got)
+	}
+}
+
+func TestCreateAccessKeysRotation(t *testing.T) {
+	tests := []struct {
+		name  string
+		today string
+		key   AccessKey
+		want  []string
+	}{
+		{
+			name:  "first generation",
+			today: "2026-10-01",
+			key:   AccessKey{Generation: 1},
+			want:  []string{"key-joshua-hayes"},
+		},
+		{
+			name:  "inside default grace window",
+			today: "2026-10-07",
+			key:   AccessKey{Generation: 2, RotatedOn: "2026-10-01"},
+			want:  []string{"key-joshua-hayes-gen2", "key-joshua-hayes"},
+		},
+		{
+			name:  "after grace window",
+			today: "2026-10-08",
+			key:   AccessKey{Generation: 2, RotatedOn: "2026-10-01"},
+			want:  []string{"key-joshua-hayes-gen2"},
+		},
+		{
+			name:  "no grace",
+			today: "2026-10-01",
+			key:   AccessKey{Generation: 3, RotatedOn: "2026-10-01", GraceDays: intPtr(0)},
+			want:  []string{"key-joshua-hayes-gen3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setNow(t, tt.today)
+			m := &mocks.Mocks{}
+			exports := pulumi.Map{}
+			err := m.Run(func(ctx *pulumi.Context) error {
+				user, err := iam.NewUser(ctx, "user-joshua-hayes", &iam.UserArgs{})
+				if err != nil {
+					return err
+				}
+				return createAccessKeys(ctx, "joshua-hayes", user, tt.key, true, exports)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := m.ByType("aws:iam/accessKey:AccessKey")
+			if len(keys) != len(tt.want) {
+				t.Errorf("expected %d keys, got %d", len(tt.want), len(keys))
+			}
+			for _, name := range tt.want {
+				if _, ok := keys[name]; !ok {
+					t.Errorf("missing %s", name)
+				}
+			}
+			_, previous := exports["PreviousAccessKeyId-joshua-hayes"]
+			if previous != (len(tt.want) == 2) {
+				t.Errorf("PreviousAccessKeyId export present: %v", previous)
+			}
+		})
+	}
+}
+
+func TestSecretAccessKeyIsSecret(t *testing.T) {
+	m := &mocks.Mocks{}
+	err := m.Run(func(ctx *pulumi.Context) error {
+		user, err := iam.NewUser(ctx, "user-joshua-hayes", &iam.UserArgs{})
+		if err != nil {
+			return err
+		}
+		exports := pulumi.Map{}
+		if err := createAccessKeys(ctx, "joshua-hayes", user, AccessKey{Generation: 1}, true, exports); err != nil {
+			return err
+		}
+		secret, err := mocks.IsSecret(exports["SecretAccessKey-joshua-hayes"])
+		if err != nil {
+			return err
+		}
+		if !secret {
+			t.Error("SecretAccessKey-joshua-hayes is not a secret output")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,105 @@
+package identity
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// mfaPolicyDocument denies everything except MFA setup until the caller has
+// signed in with MFA.
+var mfaPolicyDocument = map[string]interface{}{
+	"Version": "2012-10-17",
+	"Statement": []map[string]interface{}{
+		{
+			"Sid":    "AllowViewAccountInfo",
+			"Effect": "Allow",
+			"Action": []string{
+				"iam:GetAccountPasswordPolicy",
+				"iam:GetAccountSummary",
+				"iam:ListVirtualMFADevices",
+				"iam:ListUsers",
+			},
+			"Resource": "*",
+		},
+		{
+			"Sid":    "AllowManageOwnPasswordsAndMFA",
+			"Effect": "Allow",
+			"Action": []string{
+				"iam:ChangePassword",
+				"iam:GetUser",
+				"iam:CreateVirtualMFADevice",
+				"iam:EnableMFADevice",
+				"iam:ResyncMFADevice",
+				"iam:DeleteVirtualMFADevice",
+				"iam:ListMFADevices",
+			},
+			"Resource": []string{
+				"arn:aws:iam::*:user/${aws:username}",
+				"arn:aws:iam::*:mfa/${aws:username}",
+			},
+		},
+		{
+			"Sid":    "DenyAllExceptListedIfNoMFA",
+			"Effect": "Deny",
+			"NotAction": []string{
+				"iam:GetAccountPasswordPolicy",
+				"iam:GetAccountSummary",
+				"iam:ListVirtualMFADevices",
+				"iam:ChangePassword",
+				"iam:GetUser",
+				"iam:CreateVirtualMFADevice",
+				"iam:EnableMFADevice",
+				"iam:ResyncMFADevice",
+				"iam:DeleteVirtualMFADevice",
+				"iam:ListMFADevices",
+				"iam:ListUsers",
+			},
+			"Resource": "*",
+			"Condition": map[string]interface{}{
+				"BoolIfExists": map[string]interface{}{
+					"aws:MultiFactorAuthPresent": "false",
+				},
+			},
+		},
+	},
+}
+
+// CreatePasswordPolicy sets the account password policy.
+func CreatePasswordPolicy(ctx *pulumi.Context) error {
+	_, err := iam.NewAccountPasswordPolicy(ctx, "password-policy", &iam.AccountPasswordPolicyArgs{
+		MinimumPasswordLength:      pulumi.Int(12),
+		RequireNumbers:             pulumi.Bool(true),
+		RequireSymbols:             pulumi.Bool(true),
+		RequireLowercaseCharacters: pulumi.Bool(true),
+		RequireUppercaseCharacters: pulumi.Bool(true),
+		AllowUsersToChangePassword: pulumi.Bool(true),
+		HardExpiry:                 pulumi.Bool(false),
+		MaxPasswordAge:             pulumi.Int(90),
+		PasswordReusePrevention:    pulumi.Int(3),
+	})
+	return err
+}
+
+// EnforceMFA creates the EnforceMFA policy and attaches it to every
+// functional group.
+func EnforceMFA(ctx *pulumi.Context, groups map[string]*iam.Group) error {
+	mfaPolicy, err := iam.NewPolicy(ctx, "mfa-enforcement", &iam.PolicyArgs{
+		Name:        pulumi.String("EnforceMFA"),
+		Description: pulumi.String("Requires MFA for all actions except MFA setup."),
+		Policy:      pulumi.Any(mfaPolicyDocument),
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, g := range groups {
+		_, err = iam.NewGroupPolicyAttachment(ctx, "mfa-enforcement-attach-"+name, &iam.GroupPolicyAttachmentArgs{
+			Group:     g.Name,
+			PolicyArn: mfaPolicy.Arn,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
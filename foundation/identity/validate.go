@@ -0,0 +1,58 @@
+package identity
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// iamNamePattern is what AWS accepts for an IAM user name.
+var iamNamePattern = regexp.MustCompile(`^[\w+=,.@-]{1,64}$`)
+
+// Validate checks users and bots before anything is registered: every name
+// must sanitize to a valid, unique IAM user name, every bot scope must parse,
+// every group must be one in the catalogue, which must itself be valid, and
+// access key rotation settings must be complete.
+// It returns every problem found rather than stopping at the first.
+func Validate(users []User, bots []Bot, catalogue Catalogue) []error {
+	errs := catalogue.Validate()
+	seen := map[string]string{}
+
+	checkName := func(kind, name, iamName string) {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("%s has an empty name", kind))
+			return
+		}
+		if !iamNamePattern.MatchString(iamName) {
+			errs = append(errs, fmt.Errorf("%s %q: IAM user name %q must be 1-64 characters of letters, digits and +=,.@_-", kind, name, iamName))
+		}
+		if other, ok := seen[iamName]; ok {
+			errs = append(errs, fmt.Errorf("%s %q: IAM user name %q is already used by %s", kind, name, iamName, other))
+			return
+		}
+		seen[iamName] = fmt.Sprintf("%s %q", kind, name)
+	}
+
+	for _, user := range users {
+		checkName("user", user.Name, ResourceName(user.Name))
+		for _, g := range user.Groups {
+			if _, ok := catalogue[g]; !ok {
+				errs = append(errs, fmt.Errorf("user %q: unknown group %q (known groups: %s)", user.Name, g, strings.Join(catalogue.Names(), ", ")))
+			}
+		}
+		if user.AccessKey != nil {
+			for _, err := range user.AccessKey.validate() {
+				errs = append(errs, fmt.Errorf("user %q: %w", user.Name, err))
+			}
+		}
+	}
+	for _, bot := range bots {
+		checkName("bot", bot.Name, botPrefix+ResourceName(bot.Name))
+		for _, scope := range bot.Scopes {
+			if _, _, err := parseScope(scope); err != nil {
+				errs = append(errs, fmt.Errorf("bot %q: %w", bot.Name, err))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,90 @@
+package identity
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []User
+		bots  []Bot
+		want  []string
+	}{
+		{
+			name:  "valid",
+			users: []User{{Name: "Joshua Hayes", Groups: []string{"technical", "billing"}}},
+			bots: []Bot{
+				{Name: "GitHub Actions", Scopes: []string{"ecr-push:josh-app", "pulumi-preview:platform"}},
+				{Name: "Deployer", Scopes: []string{"pulumi-deploy:foundation"}},
+			},
+		},
+		{
+			name:  "unknown group",
+			users: []User{{Name: "Abby Adkins", Groups: []string{"sysadmins"}}},
+			want:  []string{`unknown group "sysadmins" (known groups: app-developers, auditors, billing, technical)`},
+		},
+		{
+			name:  "duplicate sanitized names",
+			users: []User{{Name: "Justin Rouse"}, {Name: "justin rouse"}},
+			want:  []string{`user "justin rouse": IAM user name "justin-rouse" is already used by user "Justin Rouse"`},
+		},
+		{
+			name: "invalid IAM names",
+			users: []User{
+				{Name: "Seán O'Brien"},
+				{Name: strings.Repeat("a", 65)},
+				{Name: " "},
+			},
+			bots: []Bot{{Name: "ci:deploy"}},
+			want: []string{
+				`user "Seán O'Brien"`,
+				`must be 1-64 characters`,
+				`user has an empty name`,
+				`bot "ci:deploy"`,
+			},
+		},
+		{
+			name: "bad access key rotation",
+			users: []User{
+				{Name: "Joshua Hayes", AccessKey: &AccessKey{Generation: 0}},
+				{Name: "Justin Rouse", AccessKey: &AccessKey{Generation: 2}},
+				{Name: "Abby Adkins", AccessKey: &AccessKey{Generation: 3, RotatedOn: "2026-10-01", GraceDays: intPtr(-1)}},
+			},
+			want: []string{
+				`user "Joshua Hayes": access_key generation must be at least 1`,
+				`user "Justin Rouse": access_key rotated_on "" must be a YYYY-MM-DD date`,
+				`user "Abby Adkins": access_key grace_days must not be negative`,
+			},
+		},
+		{
+			name: "bad scopes",
+			bots: []Bot{{Name: "CI", Scopes: []string{"admin", "s3-read:bucket", "pulumi-deploy:staging"}}},
+			want: []string{
+				`scope "admin" must be of the form <kind>:<target>`,
+				`unknown kind "s3-read"`,
+				`unknown stack "staging" (known stacks: foundation, platform)`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Validate(tt.users, tt.bots, loadCatalogue(t))
+			if len(tt.want) == 0 && len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			var all []string
+			for _, err := range errs {
+				all = append(all, err.Error())
+			}
+			joined := strings.Join(all, "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(joined, want) {
+					t.Errorf("errors do not mention %s:\n%s", want, joined)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Package jsonfile reads the foundation's JSON inputs strictly, so a misspelt
+// key is an error instead of a silently empty field.
+package jsonfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Load decodes the JSON file at path into v, rejecting unknown fields.
+func Load(path string, v any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return Decode(path, content, v)
+}
+
+// Decode decodes data into v, rejecting unknown fields. name identifies the
+// source in error messages.
+func Decode(name string, data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return nil
+}
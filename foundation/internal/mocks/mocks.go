@@ -0,0 +1,102 @@
+// Package mocks provides a recording Pulumi mock monitor for the foundation
+// packages' unit tests.
+package mocks
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/internals"
+)
+
+// Mocks records every resource registered during a test run so assertions can
+// be made about what a Pulumi program would create. Repositories are the ECR
+// repositories the mocked registry already holds.
+type Mocks struct {
+	Repositories []string
+
+	mu        sync.Mutex
+	resources []pulumi.MockResourceArgs
+}
+
+func (m *Mocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	m.mu.Lock()
+	m.resources = append(m.resources, args)
+	m.mu.Unlock()
+	outputs := args.Inputs.Copy()
+	if _, ok := outputs["arn"]; !ok {
+		outputs["arn"] = resource.NewStringProperty("arn:aws:mock:" + args.Name)
+	}
+	return args.Name + "_id", outputs, nil
+}
+
+// AccountID and Region are what the mocked AWS provider reports for the
+// caller's account and region.
+const (
+	AccountID = "123456789012"
+	Region    = "us-east-1"
+)
+
+func (m *Mocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	switch args.Token {
+	case "aws:index/getCallerIdentity:getCallerIdentity":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"accountId": AccountID,
+			"arn":       "arn:aws:iam::" + AccountID + ":user/test",
+			"userId":    "AIDATEST",
+		}), nil
+	case "aws:ecr/getRepositories:getRepositories":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"id":    Region,
+			"names": m.Repositories,
+		}), nil
+	case "aws:index/getRegion:getRegion":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"name": Region,
+		}), nil
+	}
+	return args.Args, nil
+}
+
+// Run executes fn against the mocks as the dev stack of the foundation
+// project.
+func (m *Mocks) Run(fn pulumi.RunFunc) error {
+	return pulumi.RunErr(fn, pulumi.WithMocks("teamchikynbitts-foundation", "dev", m))
+}
+
+// ByType returns the registered resources of the given type token, keyed by
+// resource name.
+func (m *Mocks) ByType(typ string) map[string]pulumi.MockResourceArgs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := map[string]pulumi.MockResourceArgs{}
+	for _, r := range m.resources {
+		if r.TypeToken == typ {
+			found[r.Name] = r
+		}
+	}
+	return found
+}
+
+// Resources returns every registered resource, in registration order.
+func (m *Mocks) Resources() []pulumi.MockResourceArgs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]pulumi.MockResourceArgs(nil), m.resources...)
+}
+
+// Count returns the number of registered resources.
+func (m *Mocks) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.resources)
+}
+
+// IsSecret waits for v, which must be an output, and reports whether it is
+// marked secret. Call it from inside Run.
+func IsSecret(v pulumi.Input) (bool, error) {
+	result, err := internals.UnsafeAwaitOutput(context.Background(), pulumi.ToOutput(v))
+	return result.Secret, err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"teamchikynbitts-budget-shutdown/shutdown"
+)
+
+// ec2Client adapts the EC2 API to shutdown.EC2.
+type ec2Client struct {
+	api *ec2.Client
+}
+
+func (c ec2Client) FindInstances(ctx context.Context, tags map[string]string) ([]shutdown.Instance, error) {
+	var filters []types.Filter
+	for key, value := range tags {
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + key), Values: []string{value}})
+	}
+	var instances []shutdown.Instance
+	pages := ec2.NewDescribeInstancesPaginator(c.api, &ec2.DescribeInstancesInput{Filters: filters})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range page.Reservations {
+			for _, inst := range reservation.Instances {
+				found := shutdown.Instance{ID: aws.ToString(inst.InstanceId)}
+				if inst.State != nil {
+					found.State = string(inst.State.Name)
+				}
+				for _, tag := range inst.Tags {
+					if aws.ToString(tag.Key) == "Name" {
+						found.Name = aws.ToString(tag.Value)
+					}
+				}
+				instances = append(instances, found)
+			}
+		}
+	}
+	return instances, nil
+}
+
+func (c ec2Client) StopInstances(ctx context.Context, ids []string) error {
+	_, err := c.api.StopInstances(ctx, &ec2.StopInstancesInput{InstanceIds: ids})
+	return err
+}
@@ -0,0 +1,33 @@
+// Command budget-shutdown is the Lambda behind the foundation stack's budget
+// shutdown: subscribed to a budget alert SNS topic, it stops the platform's
+// k3s instances. See package shutdown for the rules it follows.
+//
+// Build it for the provided.al2023 runtime before deploying the foundation:
+//
+//	GOOS=linux GOARCH=arm64 CGO_ENABLED=0 go build -tags lambda.norpc -o bootstrap .
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"teamchikynbitts-budget-shutdown/shutdown"
+)
+
+func main() {
+	cfg, err := shutdown.LoadConfig(os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler := shutdown.NewHandler(ec2Client{ec2.NewFromConfig(awsCfg)}, cfg)
+	lambda.Start(handler.Handle)
+}
@@ -0,0 +1,173 @@
+// Package shutdown stops the platform's EC2 instances when a budget alert
+// arrives over SNS. It only ever stops instances that carry every configured
+// tag and are on the allow-list, and in dry-run mode it only logs what it
+// would stop.
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Instance is the part of an EC2 instance the handler looks at.
+type Instance struct {
+	ID    string
+	Name  string
+	State string
+}
+
+// EC2 is the subset of EC2 the handler needs; main wires in the real client.
+type EC2 interface {
+	// FindInstances returns the instances carrying every tag in tags.
+	FindInstances(ctx context.Context, tags map[string]string) ([]Instance, error)
+	// StopInstances stops the given instances.
+	StopInstances(ctx context.Context, ids []string) error
+}
+
+// Config is read from the Lambda environment, set by the foundation stack.
+type Config struct {
+	// Tags select the candidate instances (INSTANCE_TAGS, "Key=Value,...").
+	Tags map[string]string
+	// Allow lists the instance IDs or Name tags that may be stopped
+	// (ALLOW_LIST, comma-separated). Nothing is stopped if it is empty.
+	Allow []string
+	// Budget, if set, is the only budget name whose alerts are acted on
+	// (BUDGET_NAME).
+	Budget string
+	// DryRun logs instead of stopping (DRY_RUN=true).
+	DryRun bool
+}
+
+// LoadConfig reads the configuration through getenv (os.Getenv in Lambda).
+func LoadConfig(getenv func(string) string) (Config, error) {
+	config := Config{
+		Tags:   map[string]string{},
+		Allow:  splitList(getenv("ALLOW_LIST")),
+		Budget: strings.TrimSpace(getenv("BUDGET_NAME")),
+	}
+	for _, pair := range splitList(getenv("INSTANCE_TAGS")) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return config, fmt.Errorf("INSTANCE_TAGS: %q must be of the form <key>=<value>", pair)
+		}
+		config.Tags[key] = value
+	}
+	if len(config.Tags) == 0 {
+		return config, fmt.Errorf("INSTANCE_TAGS is required")
+	}
+	switch dryRun := strings.ToLower(strings.TrimSpace(getenv("DRY_RUN"))); dryRun {
+	case "", "false", "0":
+	case "true", "1":
+		config.DryRun = true
+	default:
+		return config, fmt.Errorf("DRY_RUN: %q is not a boolean", dryRun)
+	}
+	return config, nil
+}
+
+// Result reports what one invocation did, by instance ID.
+type Result struct {
+	Stopped []string `json:"stopped"`
+	Skipped []string `json:"skipped"`
+	DryRun  bool     `json:"dry_run"`
+}
+
+// Handler handles the budget alert SNS events.
+type Handler struct {
+	EC2    EC2
+	Config Config
+	Logger *log.Logger
+}
+
+// NewHandler returns a handler logging to stderr.
+func NewHandler(client EC2, config Config) *Handler {
+	return &Handler{EC2: client, Config: config, Logger: log.New(os.Stderr, "", 0)}
+}
+
+// Handle stops the allowed running instances if any record is an alert for
+// the configured budget.
+func (h *Handler) Handle(ctx context.Context, event events.SNSEvent) (Result, error) {
+	result := Result{DryRun: h.Config.DryRun}
+	if !h.matches(event) {
+		h.Logger.Printf("no alert for budget %q in %d record(s), nothing to do", h.Config.Budget, len(event.Records))
+		return result, nil
+	}
+
+	instances, err := h.EC2.FindInstances(ctx, h.Config.Tags)
+	if err != nil {
+		return result, fmt.Errorf("finding instances: %w", err)
+	}
+	allowed := map[string]bool{}
+	for _, a := range h.Config.Allow {
+		allowed[a] = true
+	}
+
+	var stop []string
+	for _, inst := range instances {
+		switch {
+		case inst.State != "running" && inst.State != "pending":
+			h.Logger.Printf("%s (%s) is %s, nothing to stop", inst.ID, inst.Name, inst.State)
+		case !allowed[inst.ID] && !(inst.Name != "" && allowed[inst.Name]):
+			h.Logger.Printf("%s (%s) is not on the allow-list, skipping", inst.ID, inst.Name)
+			result.Skipped = append(result.Skipped, inst.ID)
+		default:
+			stop = append(stop, inst.ID)
+		}
+	}
+	sort.Strings(stop)
+
+	if len(stop) == 0 {
+		h.Logger.Printf("no allowed running instances match %v", h.Config.Tags)
+		return result, nil
+	}
+	if h.Config.DryRun {
+		h.Logger.Printf("dry run: would stop %s", strings.Join(stop, ", "))
+		result.Skipped = append(result.Skipped, stop...)
+		return result, nil
+	}
+	if err := h.EC2.StopInstances(ctx, stop); err != nil {
+		return result, fmt.Errorf("stopping %s: %w", strings.Join(stop, ", "), err)
+	}
+	h.Logger.Printf("stopped %s", strings.Join(stop, ", "))
+	result.Stopped = stop
+	return result, nil
+}
+
+// matches reports whether the event holds an alert the handler acts on.
+// AWS Budgets messages name the budget on a "Budget Name: <name>" line.
+func (h *Handler) matches(event events.SNSEvent) bool {
+	for _, record := range event.Records {
+		if h.Config.Budget == "" || budgetName(record.SNS.Message) == h.Config.Budget {
+			return true
+		}
+	}
+	return false
+}
+
+// budgetName returns the budget an AWS Budgets notification is about, or ""
+// if the message is not one.
+func budgetName(message string) string {
+	for _, line := range strings.Split(message, "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "Budget Name:"); ok {
+			return strings.TrimSpace(name)
+		}
+	}
+	return ""
+}
+
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,189 @@
+package shutdown
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// fakeEC2 serves instances from memory and records stop calls.
+type fakeEC2 struct {
+	instances []Instance
+	tags      map[string]string
+	stopped   [][]string
+	stopErr   error
+}
+
+func (f *fakeEC2) FindInstances(_ context.Context, tags map[string]string) ([]Instance, error) {
+	f.tags = tags
+	return f.instances, nil
+}
+
+func (f *fakeEC2) StopInstances(_ context.Context, ids []string) error {
+	f.stopped = append(f.stopped, ids)
+	return f.stopErr
+}
+
+const alert = `AWS Budget Notification October 16, 2026
+AWS Account 123456789012
+
+Dear AWS Customer,
+
+You requested that we alert you when the ACTUAL Cost associated with your budget-75-a1b2c3 budget is greater than $75.00 for the current month.
+
+Budget Name: budget-75-a1b2c3
+Budget Type: Cost
+Budgeted Amount: $75.00
+`
+
+func snsEvent(messages ...string) events.SNSEvent {
+	var event events.SNSEvent
+	for _, m := range messages {
+		event.Records = append(event.Records, events.SNSEventRecord{SNS: events.SNSEntity{Message: m}})
+	}
+	return event
+}
+
+func newTestHandler(client EC2, config Config) (*Handler, *bytes.Buffer) {
+	var logs bytes.Buffer
+	return &Handler{EC2: client, Config: config, Logger: log.New(&logs, "", 0)}, &logs
+}
+
+func TestHandleStopsAllowedInstances(t *testing.T) {
+	client := &fakeEC2{instances: []Instance{
+		{ID: "i-server", Name: "k3s-server-v6", State: "running"},
+		{ID: "i-other", Name: "bastion", State: "running"},
+		{ID: "i-agent", Name: "k3s-agent", State: "stopped"},
+		{ID: "i-by-id", State: "pending"},
+	}}
+	h, _ := newTestHandler(client, Config{
+		Tags:   map[string]string{"Project": "teamchikynbitts"},
+		Allow:  []string{"k3s-server-v6", "k3s-agent", "i-by-id"},
+		Budget: "budget-75-a1b2c3",
+	})
+
+	result, err := h.Handle(context.Background(), snsEvent(alert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"i-by-id", "i-server"}; !reflect.DeepEqual(result.Stopped, want) {
+		t.Errorf("stopped %v, want %v", result.Stopped, want)
+	}
+	if want := []string{"i-other"}; !reflect.DeepEqual(result.Skipped, want) {
+		t.Errorf("skipped %v, want %v", result.Skipped, want)
+	}
+	if len(client.stopped) != 1 {
+		t.Errorf("expected one StopInstances call, got %v", client.stopped)
+	}
+	if client.tags["Project"] != "teamchikynbitts" {
+		t.Errorf("instances looked up by %v", client.tags)
+	}
+}
+
+func TestHandleDryRun(t *testing.T) {
+	client := &fakeEC2{instances: []Instance{{ID: "i-server", Name: "k3s-server-v6", State: "running"}}}
+	h, logs := newTestHandler(client, Config{
+		Tags:   map[string]string{"Name": "k3s-server-v6"},
+		Allow:  []string{"k3s-server-v6"},
+		DryRun: true,
+	})
+
+	result, err := h.Handle(context.Background(), snsEvent(alert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.stopped) != 0 || len(result.Stopped) != 0 {
+		t.Errorf("dry run stopped instances: %v", client.stopped)
+	}
+	if !result.DryRun || !strings.Contains(logs.String(), "would stop i-server") {
+		t.Errorf("dry run not reported: %+v\n%s", result, logs.String())
+	}
+}
+
+func TestHandleIgnoresOtherBudgets(t *testing.T) {
+	client := &fakeEC2{instances: []Instance{{ID: "i-server", Name: "k3s-server-v6", State: "running"}}}
+	h, _ := newTestHandler(client, Config{
+		Tags:   map[string]string{"Name": "k3s-server-v6"},
+		Allow:  []string{"k3s-server-v6"},
+		Budget: "budget-50-zzz",
+	})
+
+	if _, err := h.Handle(context.Background(), snsEvent(alert, "hello")); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.stopped) != 0 {
+		t.Errorf("stopped instances for another budget's alert: %v", client.stopped)
+	}
+}
+
+func TestHandleEmptyAllowListStopsNothing(t *testing.T) {
+	client := &fakeEC2{instances: []Instance{{ID: "i-server", Name: "k3s-server-v6", State: "running"}}}
+	h, _ := newTestHandler(client, Config{Tags: map[string]string{"Name": "k3s-server-v6"}})
+
+	result, err := h.Handle(context.Background(), snsEvent(alert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.stopped) != 0 || len(result.Skipped) != 1 {
+		t.Errorf("expected i-server to be skipped, got %+v", result)
+	}
+}
+
+func TestHandleStopError(t *testing.T) {
+	client := &fakeEC2{
+		instances: []Instance{{ID: "i-server", Name: "k3s-server-v6", State: "running"}},
+		stopErr:   errors.New("UnauthorizedOperation"),
+	}
+	h, _ := newTestHandler(client, Config{Tags: map[string]string{"Name": "k3s-server-v6"}, Allow: []string{"i-server"}})
+
+	if _, err := h.Handle(context.Background(), snsEvent(alert)); err == nil || !strings.Contains(err.Error(), "UnauthorizedOperation") {
+		t.Errorf("expected the stop error, got %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	env := map[string]string{
+		"INSTANCE_TAGS": "Name=k3s-server-v6, Stack=platform",
+		"ALLOW_LIST":    "k3s-server-v6,i-0123",
+		"BUDGET_NAME":   "budget-75-a1b2c3",
+		"DRY_RUN":       "TRUE",
+	}
+	config, err := LoadConfig(func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{
+		Tags:   map[string]string{"Name": "k3s-server-v6", "Stack": "platform"},
+		Allow:  []string{"k3s-server-v6", "i-0123"},
+		Budget: "budget-75-a1b2c3",
+		DryRun: true,
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("got %+v, want %+v", config, want)
+	}
+
+	for name, bad := range map[string]map[string]string{
+		"no tags":     {},
+		"bad tag":     {"INSTANCE_TAGS": "Name"},
+		"bad dry run": {"INSTANCE_TAGS": "Name=x", "DRY_RUN": "maybe"},
+	} {
+		if _, err := LoadConfig(func(k string) string { return bad[k] }); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestBudgetName(t *testing.T) {
+	if got := budgetName(alert); got != "budget-75-a1b2c3" {
+		t.Errorf("got %q", got)
+	}
+	if got := budgetName("not a budget alert"); got != "" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -2,381 +2,197 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
-	"strings"
 
-	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/budgets"
-	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ecr"
-	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-)
-
-func main() {
-	pulumi.Run(func(ctx *pulumi.Context) error {
-
-		// Read users from file
-		// Note: users.json should be in the same directory as the Pulumi program
-		fileContent, err := os.ReadFile("users.json")
-		if err != nil {
-			return err
-		}
-
-		type UserConfig struct {
-			Name   string   `json:"name"`
-			Groups []string `json:"groups"`
-		}
-
-		var users []UserConfig
-		if err := json.Unmarshal(fileContent, &users); err != nil {
-			return err
-		}
-
-		// Read bots from file
-		botsFileContent, err := os.ReadFile("bots.json")
-		if err != nil {
-			return err
-		}
-		var bots []string
-		if err := json.Unmarshal(botsFileContent, &bots); err != nil {
-			return err
-		}
-
-		// Read config from file
-		type Config struct {
-			BudgetNotificationEmail string `json:"budget_notification_email"`
-		}
-		configContent, err := os.ReadFile("config.json")
-		if err != nil {
-			return err
-		}
-		var config Config
-		if err := json.Unmarshal(configContent, &config); err != nil {
-			return err
-		}
-
-		// Define Group Policies (Name -> Policy ARN)
-		groupPolicies := map[string]string{
-			"technical": "arn:aws:iam::aws:policy/AdministratorAccess", // Replaces old "sysadmins"
-			"billing":   "arn:aws:iam::aws:policy/job-function/Billing",
-		}
-
-		iamGroups := make(map[string]*iam.Group)
-
-		// Create Functional Groups
-		for groupName, policyArn := range groupPolicies {
-			g, err := iam.NewGroup(ctx, groupName, &iam.GroupArgs{
-				Name: pulumi.String(groupName),
-			})
-			if err != nil {
-				return err
-			}
-			iamGroups[groupName] = g
-
-			// Attach Policy
-			_, err = iam.NewGroupPolicyAttachment(ctx, groupName+"-policy", &iam.GroupPolicyAttachmentArgs{
-				Group:     g.Name,
-				PolicyArn: pulumi.String(policyArn),
-			})
-			if err != nil {
-				return err
-			}
-		}
-
-		for _, userCfg := range users {
-			// Sanitize name for resource name (spaces to dashes, lowercase)
-			resourceName := strings.ReplaceAll(strings.ToLower(userCfg.Name), " ", "-")
-
-			user, err := iam.NewUser(ctx, "user-"+resourceName, &iam.UserArgs{
-				Name: pulumi.String(resourceName), // AWS does NOT allow spaces
-				Tags: pulumi.StringMap{
-					"ManagedBy": pulumi.String("Pulumi"),
-					"Team":      pulumi.String("TeamChikynbitts"),
-				},
-			})
-			if err != nil {
-				return err
-			}
-			ctx.Export("UserARN-"+resourceName, user.Arn)
-
-			// Create Access Keys
-			key, err := iam.NewAccessKey(ctx, "key-"+resourceName, &iam.AccessKeyArgs{
-				User: user.Name,
-			})
-			if err != nil {
-				return err
-			}
-			ctx.Export("AccessKeyId-"+resourceName, key.ID())
-			ctx.Export("SecretAccessKey-"+resourceName, key.Secret)
-
-			// Create User Login Profile (Enables Console Access)
-			profile, err := iam.NewUserLoginProfile(ctx, "profile-"+resourceName, &iam.UserLoginProfileArgs{
-				User:                  user.Name,
-				PasswordResetRequired: pulumi.Bool(true),
-			})
-			if err != nil {
-				return err
-			}
-			ctx.Export("ConsolePassword-"+resourceName, profile.Password)
-
-			// Collect user names for group membership (for MFA enforcement mainly)
-
-			// Add to Groups defined in JSON
-			for _, gName := range userCfg.Groups {
-				if group, ok := iamGroups[gName]; ok {
-					_, err := iam.NewUserGroupMembership(ctx, "membership-"+resourceName+"-"+gName, &iam.UserGroupMembershipArgs{
-						User: user.Name,
-						Groups: pulumi.StringArray{
-							group.Name,
-						},
-					})
-					if err != nil {
-						return err
-					}
-				}
-			}
-		}
-
-		var botNames []string
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 
-		for _, name := range bots {
-			// Sanitize name for resource name (spaces to dashes, lowercase)
-			resourceName := strings.ReplaceAll(strings.ToLower(name), " ", "-")
-			resourceName = "bot-" + resourceName
-
-			user, err := iam.NewUser(ctx, resourceName, &iam.UserArgs{
-				Name: pulumi.String(resourceName),
-				Tags: pulumi.StringMap{
-					"ManagedBy": pulumi.String("Pulumi"),
-					"Team":      pulumi.String("TeamChikynbitts"),
-					"Type":      pulumi.String("Bot"),
-				},
-			})
-			if err != nil {
-				return err
-			}
-			ctx.Export("UserARN-"+resourceName, user.Arn)
-
-			// Create Access Keys
-			key, err := iam.NewAccessKey(ctx, "key-"+resourceName, &iam.AccessKeyArgs{
-				User: user.Name,
-			})
-			if err != nil {
-				return err
-			}
-			ctx.Export("AccessKeyId-"+resourceName, key.ID())
-			ctx.Export("SecretAccessKey-"+resourceName, key.Secret)
-
-			// Collect bot names for group membership
-			botNames = append(botNames, resourceName)
-		}
-
-		// Create Bots Group
-		botsGroup, err := iam.NewGroup(ctx, "bots", &iam.GroupArgs{
-			Name: pulumi.String("bots"),
-		})
-		if err != nil {
-			return err
-		}
-
-		// Add Bots to Group
-		for _, bName := range botNames {
-			_, err := iam.NewUserGroupMembership(ctx, "membership-"+bName, &iam.UserGroupMembershipArgs{
-				User: pulumi.String(bName),
-				Groups: pulumi.StringArray{
-					botsGroup.Name,
-				},
-			})
-			if err != nil {
-				return err
-			}
-		}
-
-		// Attach Administrator Access to Bots Group
-		_, err = iam.NewGroupPolicyAttachment(ctx, "bot-admin-access", &iam.GroupPolicyAttachmentArgs{
-			Group:     botsGroup.Name,
-			PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AdministratorAccess"),
-		})
-		if err != nil {
-			return err
-		}
+	"teamchikynbitts-foundation/budgets"
+	"teamchikynbitts-foundation/identity"
+	"teamchikynbitts-foundation/internal/jsonfile"
+	"teamchikynbitts-foundation/registry"
+	"teamchikynbitts-tagging"
+)
 
-		// Note: "sysadmins" group is replaced by "technical" group logic above.
-		// However, we still need to attach MFA enforcement to these users.
-		// We can attach it to the "technical" group or iterate users.
-		// The original code created a "sysadmins" group and put everyone in it.
-		// Since we now have split groups, we should probably ensure MFA is enforced on 'technical' users at least.
-		// Better yet, let's create a "common-users" group or just attach MFA to "technical".
-		// For now, let's attach MFA enforcement to the "technical" group as that covers all users currently.
+// inputs holds everything the foundation program is driven by.
+type inputs struct {
+	Groups   identity.Catalogue
+	Users    []identity.User
+	Bots     []identity.Bot
+	GitHub   identity.GitHub
+	Config   budgets.Config
+	Registry registry.Config
+	Apps     []registry.App
+}
 
-		// Set Account Password Policy
-		_, err = iam.NewAccountPasswordPolicy(ctx, "password-policy", &iam.AccountPasswordPolicyArgs{
-			MinimumPasswordLength:      pulumi.Int(12),
-			RequireNumbers:             pulumi.Bool(true),
-			RequireSymbols:             pulumi.Bool(true),
-			RequireLowercaseCharacters: pulumi.Bool(true),
-			RequireUppercaseCharacters: pulumi.Bool(true),
-			AllowUsersToChangePassword: pulumi.Bool(true),
-			HardExpiry:                 pulumi.Bool(false),
-			MaxPasswordAge:             pulumi.Int(90),
-			PasswordReusePrevention:    pulumi.Int(3),
-		})
-		if err != nil {
-			return err
-		}
+// appsManifest is the shared app registry at the repository root.
+const appsManifest = "../apps.json"
+
+// loadInputs reads the program inputs. Groups, users, bots, GitHub OIDC roles
+// budget and registry settings come from stack config (keys groups, users,
+// bots, github, budgets and registry, plain or secret); if a key is not set,
+// the matching JSON file next to the Pulumi program is used instead.
+// groups.json, github.json and registry.json are committed, the others are
+// gitignored.
+func loadInputs(ctx *pulumi.Context) (inputs, error) {
+	cfg := config.New(ctx, "")
+	var in inputs
+	if err := readInput(cfg, "groups", "groups.json", &in.Groups); err != nil {
+		return in, err
+	}
+	if err := readInput(cfg, "users", "users.json", &in.Users); err != nil {
+		return in, err
+	}
+	if err := readInput(cfg, "bots", "bots.json", &in.Bots); err != nil {
+		return in, err
+	}
+	if err := readInput(cfg, "github", "github.json", &in.GitHub); err != nil {
+		return in, err
+	}
+	if err := readInput(cfg, "budgets", "config.json", &in.Config); err != nil {
+		return in, err
+	}
+	if err := readInput(cfg, "registry", "registry.json", &in.Registry); err != nil {
+		return in, err
+	}
+	var err error
+	if in.Apps, err = registry.LoadApps(appsManifest); err != nil {
+		return in, err
+	}
+	return in, nil
+}
 
-		// Define MFA Enforcement Policy
-		mfaPolicyJSON := map[string]interface{}{
-			"Version": "2012-10-17",
-			"Statement": []map[string]interface{}{
-				{
-					"Sid":    "AllowViewAccountInfo",
-					"Effect": "Allow",
-					"Action": []string{
-						"iam:GetAccountPasswordPolicy",
-						"iam:GetAccountSummary",
-						"iam:ListVirtualMFADevices",
-						"iam:ListUsers",
-					},
-					"Resource": "*",
-				},
-				{
-					"Sid":    "AllowManageOwnPasswordsAndMFA",
-					"Effect": "Allow",
-					"Action": []string{
-						"iam:ChangePassword",
-						"iam:GetUser",
-						"iam:CreateVirtualMFADevice",
-						"iam:EnableMFADevice",
-						"iam:ResyncMFADevice",
-						"iam:DeleteVirtualMFADevice",
-						"iam:ListMFADevices",
-					},
-					"Resource": []string{
-						"arn:aws:iam::*:user/${aws:username}",
-						"arn:aws:iam::*:mfa/${aws:username}",
-					},
-				},
-				{
-					"Sid":    "DenyAllExceptListedIfNoMFA",
-					"Effect": "Deny",
-					"NotAction": []string{
-						"iam:GetAccountPasswordPolicy",
-						"iam:GetAccountSummary",
-						"iam:ListVirtualMFADevices",
-						"iam:ChangePassword",
-						"iam:GetUser",
-						"iam:CreateVirtualMFADevice",
-						"iam:EnableMFADevice",
-						"iam:ResyncMFADevice",
-						"iam:DeleteVirtualMFADevice",
-						"iam:ListMFADevices",
-						"iam:ListUsers",
-					},
-					"Resource": "*",
-					"Condition": map[string]interface{}{
-						"BoolIfExists": map[string]interface{}{
-							"aws:MultiFactorAuthPresent": "false",
-						},
-					},
-				},
-			},
-		}
+// readInput decodes the stack config value key into v, falling back to file
+// when the key is not set.
+func readInput(cfg *config.Config, key, file string, v any) error {
+	var raw json.RawMessage
+	_, err := cfg.TrySecretObject(key, &raw)
+	if err == nil {
+		return jsonfile.Decode("config "+key, raw, v)
+	}
+	if !errors.Is(err, config.ErrMissingVar) {
+		return fmt.Errorf("reading config %s: %w", key, err)
+	}
+	if _, statErr := os.Stat(file); statErr == nil {
+		return jsonfile.Load(file, v)
+	}
+	return fmt.Errorf("%s is not configured: run `pulumi config set --secret %s '<json>'` or create %s", key, key, file)
+}
 
-		mfaPolicy, err := iam.NewPolicy(ctx, "mfa-enforcement", &iam.PolicyArgs{
-			Name:        pulumi.String("EnforceMFA"),
-			Description: pulumi.String("Requires MFA for all actions except MFA setup."),
-			Policy:      pulumi.Any(mfaPolicyJSON),
-		})
-		if err != nil {
-			return err
-		}
+// validate checks all inputs up front and reports every problem at once.
+func (in inputs) validate() error {
+	var errs []error
+	errs = append(errs, identity.Validate(in.Users, in.Bots, in.Groups)...)
+	errs = append(errs, in.GitHub.Validate()...)
+	errs = append(errs, budgets.Validate(in.Config)...)
+	errs = append(errs, in.Registry.Validate()...)
+	errs = append(errs, in.validateRepositories()...)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid foundation inputs:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
 
-		// Attach MFA Policy to ALL functional Groups
-		for name, g := range iamGroups {
-			_, err = iam.NewGroupPolicyAttachment(ctx, "mfa-enforcement-attach-"+name, &iam.GroupPolicyAttachmentArgs{
-				Group:     g.Name,
-				PolicyArn: mfaPolicy.Arn,
-			})
-			if err != nil {
-				return err
+// validateRepositories checks every ecr-push scope, of bots and GitHub
+// workflows, names a registered app.
+func (in inputs) validateRepositories() []error {
+	known := map[string]bool{}
+	for _, app := range in.Apps {
+		known[app.Name] = true
+	}
+	var errs []error
+	check := func(kind, name string, repos []string) {
+		for _, repo := range repos {
+			if !known[repo] {
+				errs = append(errs, fmt.Errorf("%s %q: ecr-push repository %q is not an app in %s", kind, name, repo, appsManifest))
 			}
 		}
+	}
+	for _, bot := range in.Bots {
+		check("bot", bot.Name, bot.Repositories())
+	}
+	for _, workflow := range in.GitHub.Workflows {
+		check("github workflow", workflow.Name, workflow.Repositories())
+	}
+	return errs
+}
 
-		// Create Budget Alert: $50
-		_, err = budgets.NewBudget(ctx, "budget-50", &budgets.BudgetArgs{
-			BudgetType:      pulumi.String("COST"),
-			LimitAmount:     pulumi.String("50.0"),
-			LimitUnit:       pulumi.String("USD"),
-			TimePeriodStart: pulumi.String("2024-01-01_00:00"),
-			TimeUnit:        pulumi.String("MONTHLY"),
-			Notifications: budgets.BudgetNotificationArray{
-				&budgets.BudgetNotificationArgs{
-					ComparisonOperator:       pulumi.String("GREATER_THAN"),
-					Threshold:                pulumi.Float64(80), // Alert at 80% ($40)
-					ThresholdType:            pulumi.String("PERCENTAGE"),
-					NotificationType:         pulumi.String("ACTUAL"),
-					SubscriberEmailAddresses: pulumi.StringArray{pulumi.String(config.BudgetNotificationEmail)},
-				},
-				&budgets.BudgetNotificationArgs{
-					ComparisonOperator:       pulumi.String("GREATER_THAN"),
-					Threshold:                pulumi.Float64(100), // Alert at 100% ($50)
-					ThresholdType:            pulumi.String("PERCENTAGE"),
-					NotificationType:         pulumi.String("FORECASTED"), // Forecast to exceed
-					SubscriberEmailAddresses: pulumi.StringArray{pulumi.String(config.BudgetNotificationEmail)},
-				},
-			},
-		})
-		if err != nil {
-			return err
-		}
-
-		// Create Budget Alert: $75 (Critical)
-		_, err = budgets.NewBudget(ctx, "budget-75", &budgets.BudgetArgs{
-			BudgetType:      pulumi.String("COST"),
-			LimitAmount:     pulumi.String("75.0"),
-			LimitUnit:       pulumi.String("USD"),
-			TimePeriodStart: pulumi.String("2024-01-01_00:00"),
-			TimeUnit:        pulumi.String("MONTHLY"),
-			Notifications: budgets.BudgetNotificationArray{
-				&budgets.BudgetNotificationArgs{
-					ComparisonOperator:       pulumi.String("GREATER_THAN"),
-					Threshold:                pulumi.Float64(100), // Alert at 100% ($75)
-					ThresholdType:            pulumi.String("PERCENTAGE"),
-					NotificationType:         pulumi.String("ACTUAL"),
-					SubscriberEmailAddresses: pulumi.StringArray{pulumi.String(config.BudgetNotificationEmail)},
-				},
-			},
-		})
-		if err != nil {
-			return err
-		}
-
-		// Create ECR Repository for Team Chikynbitts App
-		repo, err := ecr.NewRepository(ctx, "teamchikynbitts-app-repo", &ecr.RepositoryArgs{
-			Name:               pulumi.String("teamchikynbitts-app"),
-			ImageTagMutability: pulumi.String("MUTABLE"),
-			ImageScanningConfiguration: &ecr.RepositoryImageScanningConfigurationArgs{
-				ScanOnPush: pulumi.Bool(true),
-			},
-		})
-		if err != nil {
-			return err
-		}
-		ctx.Export("RepositoryURL-teamchikynbitts-app", repo.RepositoryUrl)
+// program registers the whole foundation stack. Inputs are validated before
+// any resource is registered.
+func program(ctx *pulumi.Context, in inputs) error {
+	if err := in.validate(); err != nil {
+		return err
+	}
+
+	// Tag everything for cost allocation
+	if err := tagging.Register(ctx, tagging.Tags(ctx, "cloud-administrators")); err != nil {
+		return err
+	}
+
+	// Every user and role of both stacks carries the permissions boundary
+	boundary, err := identity.CreateBoundary(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Export("PermissionsBoundaryARN", boundary.Arn)
+
+	groups, err := identity.CreateGroups(ctx, in.Groups)
+	if err != nil {
+		return err
+	}
+
+	userExports, err := identity.CreateUsers(ctx, in.Users, groups)
+	if err != nil {
+		return err
+	}
+
+	botExports, err := identity.CreateBots(ctx, in.Bots)
+	if err != nil {
+		return err
+	}
+
+	// Short-lived credentials for GitHub Actions
+	githubExports, err := identity.CreateGitHubRoles(ctx, in.GitHub)
+	if err != nil {
+		return err
+	}
+
+	if err := identity.CreatePasswordPolicy(ctx); err != nil {
+		return err
+	}
+
+	// Attach MFA Policy to ALL functional Groups
+	if err := identity.EnforceMFA(ctx, groups); err != nil {
+		return err
+	}
+
+	budgetExports, err := budgets.Create(ctx, in.Config)
+	if err != nil {
+		return err
+	}
+
+	// Create one ECR Repository per app in the shared registry (apps.json)
+	repoExports, err := registry.CreateRepositories(ctx, in.Apps, in.Registry)
+	if err != nil {
+		return err
+	}
+
+	for _, exports := range []pulumi.Map{userExports, botExports, githubExports, budgetExports, repoExports} {
+		for name, value := range exports {
+			ctx.Export(name, value)
+		}
+	}
+	return nil
+}
 
-		// Create ECR Repository for Josh App
-		joshRepo, err := ecr.NewRepository(ctx, "josh-app-repo", &ecr.RepositoryArgs{
-			Name:               pulumi.String("josh-app"),
-			ImageTagMutability: pulumi.String("MUTABLE"),
-			ImageScanningConfiguration: &ecr.RepositoryImageScanningConfigurationArgs{
-				ScanOnPush: pulumi.Bool(true),
-			},
-		})
+func main() {
+	pulumi.Run(func(ctx *pulumi.Context) error {
+		in, err := loadInputs(ctx)
 		if err != nil {
 			return err
 		}
-		ctx.Export("RepositoryURL-josh-app", joshRepo.RepositoryUrl)
-
-		return nil
+		return program(ctx, in)
 	})
 }
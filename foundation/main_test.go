@@ -0,0 +1,223 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-foundation/budgets"
+	"teamchikynbitts-foundation/identity"
+	"teamchikynbitts-foundation/internal/jsonfile"
+	"teamchikynbitts-foundation/internal/mocks"
+	"teamchikynbitts-foundation/registry"
+	"teamchikynbitts-tagging"
+)
+
+// testInputs loads the committed and test inputs for a full program run.
+func testInputs(t *testing.T) inputs {
+	t.Helper()
+	in := inputs{
+		Config: budgets.Config{BudgetNotificationEmail: "alerts@example.com"},
+		Apps:   []registry.App{{Name: "teamchikynbitts-app"}, {Name: "josh-app"}},
+	}
+	if err := jsonfile.Load("groups.json", &in.Groups); err != nil {
+		t.Fatal(err)
+	}
+	if err := jsonfile.Load("identity/testdata/users.json", &in.Users); err != nil {
+		t.Fatal(err)
+	}
+	if err := jsonfile.Load("identity/testdata/bots.json", &in.Bots); err != nil {
+		t.Fatal(err)
+	}
+	if err := jsonfile.Load("github.json", &in.GitHub); err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func TestProgram(t *testing.T) {
+	in := testInputs(t)
+	m := &mocks.Mocks{}
+	err := m.Run(func(ctx *pulumi.Context) error {
+		return program(ctx, in)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Permissions boundary, 4 groups with 3 managed and 2 inline policies,
+	// 3 users with profiles, 1 access key and 4 memberships, 1 bot with scoped
+	// policy/group/membership, OIDC provider + 3 roles with policies, password
+	// policy, MFA policy + 4 attachments, 2 budgets, 4 cost-allocation tags,
+	// 2 repositories with lifecycle policies.
+	if got, want := m.Count(), 1+9+11+4+7+6+2+4+4; got != want {
+		t.Errorf("expected %d resources, got %d", want, got)
+	}
+}
+
+func TestProgramTagsEverything(t *testing.T) {
+	in := testInputs(t)
+	m := &mocks.Mocks{}
+	var mu sync.Mutex
+	taggable := map[string]bool{}
+	err := m.Run(func(ctx *pulumi.Context) error {
+		err := ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+			mu.Lock()
+			defer mu.Unlock()
+			taggable[args.Type+"::"+args.Name] = tagging.Taggable(args.Props)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return program(ctx, in)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every taggable resource carries the shared tags with the stack's
+	// values, whatever it sets itself.
+	want := map[string]string{
+		tagging.KeyProject:   "teamchikynbitts",
+		tagging.KeyStack:     "foundation",
+		tagging.KeyOwner:     "cloud-administrators",
+		tagging.KeyManagedBy: "pulumi",
+	}
+	tagged := 0
+	for _, r := range m.Resources() {
+		if !taggable[r.TypeToken+"::"+r.Name] {
+			continue
+		}
+		tagged++
+		tags := r.Inputs["tags"]
+		if !tags.IsObject() {
+			t.Errorf("%s %s is not tagged", r.TypeToken, r.Name)
+			continue
+		}
+		for key, want := range want {
+			got := tags.ObjectValue()[resource.PropertyKey(key)]
+			if !got.IsString() || got.StringValue() != want {
+				t.Errorf("%s %s: %s tag %v, want %q", r.TypeToken, r.Name, key, got, want)
+			}
+		}
+	}
+	if tagged == 0 {
+		t.Error("no taggable resources were registered")
+	}
+}
+
+func TestProgramRejectsInvalidInputs(t *testing.T) {
+	in := inputs{
+		Users: []identity.User{
+			{Name: "Joshua Hayes", Groups: []string{"technical", "admins"}},
+			{Name: "joshua hayes", Groups: []string{"billing"}},
+		},
+		Bots: []identity.Bot{{Name: "CI/CD", Scopes: []string{"ecr-push:missing-app"}}},
+		GitHub: identity.GitHub{
+			Repository: "teamchikynbitts",
+			Workflows:  []identity.Workflow{{Name: "deploy", Scopes: []string{"ecr-push:other-app"}}},
+		},
+		Config: budgets.Config{BudgetNotificationEmail: "not-an-email"},
+	}
+
+	m := &mocks.Mocks{}
+	err := m.Run(func(ctx *pulumi.Context) error {
+		return program(ctx, in)
+	})
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, want := range []string{
+		`unknown group "admins"`,
+		`"joshua-hayes" is already used`,
+		`bot "CI/CD"`,
+		`ecr-push repository "missing-app" is not an app`,
+		`repository "teamchikynbitts" must be of the form <owner>/<name>`,
+		`github workflow "deploy": set at least one of branches`,
+		`github workflow "deploy": ecr-push repository "other-app" is not an app`,
+		`"not-an-email" is not a valid email`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+	if got := m.Count(); got != 0 {
+		t.Errorf("expected no resources before validation passes, got %d", got)
+	}
+}
+
+func TestLoadInputsPrefersStackConfig(t *testing.T) {
+	dir := t.TempDir()
+	stackDir := filepath.Join(dir, "foundation")
+	writeFile(t, filepath.Join(dir, "apps.json"), `[{"name": "josh-app"}]`)
+	writeFile(t, filepath.Join(stackDir, "users.json"), `[{"name": "From File", "groups": []}]`)
+	writeFile(t, filepath.Join(stackDir, "bots.json"), `[{"name": "File Bot", "scopes": []}]`)
+	writeFile(t, filepath.Join(stackDir, "groups.json"), `{"technical": {"managed_policies": ["arn:aws:iam::aws:policy/AdministratorAccess"]}}`)
+	writeFile(t, filepath.Join(stackDir, "github.json"), `{"repository": "joshuamdhayes/teamchikynbitts", "workflows": []}`)
+	writeFile(t, filepath.Join(stackDir, "registry.json"), `{}`)
+	t.Chdir(stackDir)
+
+	t.Setenv("PULUMI_CONFIG", `{
+		"teamchikynbitts-foundation:users": "[{\"name\": \"Joshua Hayes\", \"groups\": [\"technical\"]}]",
+		"teamchikynbitts-foundation:budgets": "{\"budget_notification_email\": \"alerts@example.com\"}",
+		"teamchikynbitts-foundation:registry": "{\"replication\": [{\"region\": \"eu-west-2\"}]}"
+	}`)
+	t.Setenv("PULUMI_CONFIG_SECRET_KEYS", `["teamchikynbitts-foundation:users"]`)
+
+	var in inputs
+	err := (&mocks.Mocks{}).Run(func(ctx *pulumi.Context) error {
+		var err error
+		in, err = loadInputs(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(in.Users) != 1 || in.Users[0].Name != "Joshua Hayes" {
+		t.Errorf("users not read from config: %+v", in.Users)
+	}
+	if len(in.Bots) != 1 || in.Bots[0].Name != "File Bot" {
+		t.Errorf("bots not read from bots.json fallback: %+v", in.Bots)
+	}
+	if in.Config.BudgetNotificationEmail != "alerts@example.com" {
+		t.Errorf("budgets not read from config: %+v", in.Config)
+	}
+	if len(in.Registry.Replication) != 1 || in.Registry.Replication[0].Region != "eu-west-2" {
+		t.Errorf("registry not read from config: %+v", in.Registry)
+	}
+	if len(in.Apps) != 1 {
+		t.Errorf("apps not read from apps.json: %+v", in.Apps)
+	}
+}
+
+func TestLoadInputsMissing(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "groups.json"), `{}`)
+	t.Chdir(dir)
+	t.Setenv("PULUMI_CONFIG", "{}")
+
+	err := (&mocks.Mocks{}).Run(func(ctx *pulumi.Context) error {
+		_, err := loadInputs(ctx)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "users is not configured") {
+		t.Errorf("expected a missing users error, got %v", err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
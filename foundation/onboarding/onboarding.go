@@ -0,0 +1,126 @@
+// Package onboarding turns the foundation stack outputs into per-user
+// onboarding bundles: what a new team member needs for their first console
+// login and, if they opted in, their access key.
+package onboarding
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"teamchikynbitts-foundation/identity"
+)
+
+// Bundle is one user's onboarding details.
+type Bundle struct {
+	Name            string
+	Username        string
+	LoginURL        string
+	Password        string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// AccountID extracts the AWS account ID from the UserARN-* stack outputs.
+func AccountID(outputs map[string]string) (string, error) {
+	for key, arn := range outputs {
+		if !strings.HasPrefix(key, "UserARN-") {
+			continue
+		}
+		// arn:aws:iam::<account>:user/<name>
+		parts := strings.Split(arn, ":")
+		if len(parts) >= 5 && parts[4] != "" {
+			return parts[4], nil
+		}
+	}
+	return "", fmt.Errorf("no UserARN-* stack output to read the account ID from: has the foundation stack been deployed?")
+}
+
+// LoginURL is the console sign-in URL for the account.
+func LoginURL(accountID string) string {
+	return fmt.Sprintf("https://%s.signin.aws.amazon.com/console", accountID)
+}
+
+// Bundles builds a bundle for every user from the stack outputs. Users
+// without a ConsolePassword output (not deployed yet) are reported in the
+// error, alongside the bundles that could be built.
+func Bundles(users []identity.User, outputs map[string]string) ([]Bundle, error) {
+	accountID, err := AccountID(outputs)
+	if err != nil {
+		return nil, err
+	}
+	var bundles []Bundle
+	var missing []string
+	for _, user := range users {
+		username := identity.ResourceName(user.Name)
+		password, ok := outputs["ConsolePassword-"+username]
+		if !ok {
+			missing = append(missing, user.Name)
+			continue
+		}
+		bundles = append(bundles, Bundle{
+			Name:            user.Name,
+			Username:        username,
+			LoginURL:        LoginURL(accountID),
+			Password:        password,
+			AccessKeyID:     outputs["AccessKeyId-"+username],
+			SecretAccessKey: outputs["SecretAccessKey-"+username],
+		})
+	}
+	if len(missing) > 0 {
+		return bundles, fmt.Errorf("no console password output for %s: run `pulumi up` first", strings.Join(missing, ", "))
+	}
+	return bundles, nil
+}
+
+// WriteTo prints the bundle as the instructions to send to the user.
+func (b Bundle) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "User:               %s\n", b.Name)
+	fmt.Fprintf(&sb, "Login URL:          %s\n", b.LoginURL)
+	fmt.Fprintf(&sb, "Username:           %s\n", b.Username)
+	fmt.Fprintf(&sb, "Temporary password: %s\n", b.Password)
+	if b.AccessKeyID != "" {
+		fmt.Fprintf(&sb, "Access key ID:      %s\n", b.AccessKeyID)
+		fmt.Fprintf(&sb, "Secret access key:  %s\n", b.SecretAccessKey)
+	}
+	sb.WriteString("\nInstructions:\n")
+	fmt.Fprintf(&sb, "1. Go to %s\n", b.LoginURL)
+	sb.WriteString("2. Log in with the username and password above.\n")
+	sb.WriteString("3. You will be prompted to change your password immediately.\n")
+	sb.WriteString("4. Set up MFA under \"Security credentials\" before doing anything else.\n")
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// Write writes every bundle to <dir>/<username>.txt, readable only by the
+// current user. It returns the paths written.
+func Write(dir string, bundles []Bundle) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, b := range bundles {
+		path := filepath.Join(dir, b.Username+".txt")
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return paths, err
+		}
+		// OpenFile keeps the mode of an existing file.
+		if err := f.Chmod(0o600); err != nil {
+			f.Close()
+			return paths, err
+		}
+		if _, err := b.WriteTo(f); err != nil {
+			f.Close()
+			return paths, err
+		}
+		if err := f.Close(); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
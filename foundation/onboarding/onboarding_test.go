@@ -0,0 +1,102 @@
+package onboarding
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"teamchikynbitts-foundation/identity"
+)
+
+var outputs = map[string]string{
+	"UserARN-joshua-hayes":         "arn:aws:iam::123456789012:user/joshua-hayes",
+	"ConsolePassword-joshua-hayes": "s3cret!",
+	"AccessKeyId-joshua-hayes":     "AKIATEST",
+	"SecretAccessKey-joshua-hayes": "key-secret",
+	"UserARN-abby-adkins":          "arn:aws:iam::123456789012:user/abby-adkins",
+	"ConsolePassword-abby-adkins":  "hunter2",
+}
+
+func TestBundles(t *testing.T) {
+	users := []identity.User{{Name: "Joshua Hayes"}, {Name: "Abby Adkins"}}
+	bundles, err := Bundles(users, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundles) != 2 {
+		t.Fatalf("expected 2 bundles, got %d", len(bundles))
+	}
+	josh := bundles[0]
+	if josh.Username != "joshua-hayes" || josh.Password != "s3cret!" || josh.AccessKeyID != "AKIATEST" {
+		t.Errorf("unexpected bundle %+v", josh)
+	}
+	if josh.LoginURL != "https://123456789012.signin.aws.amazon.com/console" {
+		t.Errorf("unexpected login URL %q", josh.LoginURL)
+	}
+
+	var buf bytes.Buffer
+	if _, err := bundles[1].WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "Access key ID") {
+		t.Errorf("bundle of a user without a key mentions one:\n%s", buf.String())
+	}
+}
+
+func TestBundlesReportsUndeployedUsers(t *testing.T) {
+	users := []identity.User{{Name: "Joshua Hayes"}, {Name: "Justin Rouse"}}
+	bundles, err := Bundles(users, outputs)
+	if err == nil || !strings.Contains(err.Error(), "Justin Rouse") {
+		t.Errorf("expected an error naming Justin Rouse, got %v", err)
+	}
+	if len(bundles) != 1 {
+		t.Errorf("expected the deployed user's bundle, got %d", len(bundles))
+	}
+}
+
+func TestAccountIDWithoutUsers(t *testing.T) {
+	if _, err := AccountID(map[string]string{}); err == nil {
+		t.Error("expected an error without UserARN outputs")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bundles")
+	existing := filepath.Join(dir, "joshua-hayes.txt")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(existing, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bundles, err := Bundles([]identity.User{{Name: "Joshua Hayes"}, {Name: "Abby Adkins"}}, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := Write(dir, bundles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 files, got %v", paths)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Errorf("%s has mode %o, want 600", path, perm)
+		}
+	}
+	content, err := os.ReadFile(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "Temporary password: s3cret!") {
+		t.Errorf("unexpected bundle:\n%s", content)
+	}
+}
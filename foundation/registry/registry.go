@@ -0,0 +1,305 @@
+// Package registry manages the ECR repositories backing the apps listed in
+// the shared app registry (apps.json).
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ecr"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// App is a single entry in apps.json. Only Name and Repository are used by
+// the foundation stack; the platform stack reads the rest.
+type App struct {
+	Name       string      `json:"name"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Path       string      `json:"path,omitempty"`
+	Repository *Repository `json:"repository,omitempty"`
+}
+
+// Repository configures an app's ECR repository. The lifecycle policy keeps
+// the newest KeepImages commit-SHA tagged images (default 10) and expires
+// untagged images after UntaggedDays (default 7). Release tags ("v1",
+// "v1.2.3", "1.2.3") are never expired. Immutable makes every tag
+// write-once, so build-apps stops pushing :latest to the repository.
+type Repository struct {
+	KeepImages   int  `json:"keep_images,omitempty"`
+	UntaggedDays int  `json:"untagged_days,omitempty"`
+	Immutable    bool `json:"immutable,omitempty"`
+}
+
+// Lifecycle defaults.
+const (
+	defaultKeepImages   = 10
+	defaultUntaggedDays = 7
+)
+
+// releasePatterns match the release tags the lifecycle policy never expires.
+// Commit SHAs are hex, so they match neither.
+var releasePatterns = []string{"v*", "*.*.*"}
+
+// releaseCount is the count given to the release rules. It is never reached;
+// the rules only exist because an image matched by a rule can't be expired
+// by a lower-priority one.
+const releaseCount = 10000
+
+// repository returns the app's repository settings with defaults filled in.
+func (a App) repository() Repository {
+	var r Repository
+	if a.Repository != nil {
+		r = *a.Repository
+	}
+	if r.KeepImages == 0 {
+		r.KeepImages = defaultKeepImages
+	}
+	if r.UntaggedDays == 0 {
+		r.UntaggedDays = defaultUntaggedDays
+	}
+	return r
+}
+
+// mutability returns the repository's ImageTagMutability.
+func (r Repository) mutability() string {
+	if r.Immutable {
+		return "IMMUTABLE"
+	}
+	return "MUTABLE"
+}
+
+// lifecyclePolicy renders the repository's ECR lifecycle policy.
+func (r Repository) lifecyclePolicy() (string, error) {
+	var rules []map[string]interface{}
+	rule := func(description string, selection map[string]interface{}) {
+		rules = append(rules, map[string]interface{}{
+			"rulePriority": len(rules) + 1,
+			"description":  description,
+			"selection":    selection,
+			"action":       map[string]string{"type": "expire"},
+		})
+	}
+	for _, pattern := range releasePatterns {
+		rule("Never expire release tags matching "+pattern, map[string]interface{}{
+			"tagStatus":      "tagged",
+			"tagPatternList": []string{pattern},
+			"countType":      "imageCountMoreThan",
+			"countNumber":    releaseCount,
+		})
+	}
+	rule(fmt.Sprintf("Expire untagged images after %d days", r.UntaggedDays), map[string]interface{}{
+		"tagStatus":   "untagged",
+		"countType":   "sinceImagePushed",
+		"countUnit":   "days",
+		"countNumber": r.UntaggedDays,
+	})
+	rule(fmt.Sprintf("Keep the newest %d commit images", r.KeepImages), map[string]interface{}{
+		"tagStatus":      "tagged",
+		"tagPatternList": []string{"*"},
+		"countType":      "imageCountMoreThan",
+		"countNumber":    r.KeepImages,
+	})
+	doc, err := json.Marshal(map[string]interface{}{"rules": rules})
+	return string(doc), err
+}
+
+// Config holds the registry settings (the "registry" stack config key, or
+// registry.json). Every image pushed to an app repository is copied to each
+// Replication destination; ECR creates the replica repositories itself.
+// ECR only filters replicated repositories by name prefix, so no app name
+// may be a prefix of another app's name (LoadApps rejects it), and
+// CreateRepositories refuses to replicate while another repository in the
+// registry starts with an app's name.
+type Config struct {
+	Replication []Destination `json:"replication,omitempty"`
+}
+
+// Destination is a replica registry. Account defaults to this account; a
+// different account's registry policy must allow replication from this one.
+type Destination struct {
+	Region  string `json:"region"`
+	Account string `json:"account,omitempty"`
+}
+
+// maxDestinations is the ECR limit on replication destinations.
+const maxDestinations = 25
+
+var (
+	regionPattern  = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+	accountPattern = regexp.MustCompile(`^[0-9]{12}$`)
+)
+
+// Validate checks the replication destinations.
+func (c Config) Validate() []error {
+	var errs []error
+	if len(c.Replication) > maxDestinations {
+		errs = append(errs, fmt.Errorf("registry: at most %d replication destinations are allowed, got %d", maxDestinations, len(c.Replication)))
+	}
+	seen := map[string]bool{}
+	for i, d := range c.Replication {
+		if !regionPattern.MatchString(d.Region) {
+			errs = append(errs, fmt.Errorf("registry: replication destination %d: invalid region %q", i, d.Region))
+		}
+		if d.Account != "" && !accountPattern.MatchString(d.Account) {
+			errs = append(errs, fmt.Errorf("registry: replication destination %d: account %q must be 12 digits", i, d.Account))
+		}
+		// Replica hosts are exported per region, so a region can only be
+		// replicated to once.
+		if seen[d.Region] {
+			errs = append(errs, fmt.Errorf("registry: region %s is replicated to more than once", d.Region))
+		}
+		seen[d.Region] = true
+	}
+	return errs
+}
+
+// Host returns the ECR registry host of account in region.
+func Host(account, region string) string {
+	return fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", account, region)
+}
+
+// LoadApps reads the app registry from disk.
+func LoadApps(path string) ([]App, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var apps []App
+	if err := json.Unmarshal(content, &apps); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, app := range apps {
+		if app.Name == "" {
+			return nil, fmt.Errorf("%s: entry %d has no name", path, i)
+		}
+		if r := app.Repository; r != nil && (r.KeepImages < 0 || r.UntaggedDays < 0) {
+			return nil, fmt.Errorf("%s: app %s: keep_images and untagged_days must be positive", path, app.Name)
+		}
+	}
+	for _, app := range apps {
+		for _, other := range apps {
+			if other.Name != app.Name && strings.HasPrefix(other.Name, app.Name) {
+				return nil, fmt.Errorf("%s: app name %s is a prefix of app %s; replication matches repositories by prefix", path, app.Name, other.Name)
+			}
+		}
+	}
+	return apps, nil
+}
+
+// CreateRepositories creates one ECR repository per app, with its lifecycle
+// policy, and replicates them as configured. The returned map holds the
+// RepositoryURL-<name>, RegistryHost and ReplicaRegistryHost-<region> stack
+// outputs, which the platform stack and build-apps read instead of
+// hard-coding the registry.
+func CreateRepositories(ctx *pulumi.Context, apps []App, cfg Config) (pulumi.Map, error) {
+	caller, err := aws.GetCallerIdentity(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	region, err := aws.GetRegion(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	exports := pulumi.Map{
+		"RegistryHost": pulumi.String(Host(caller.AccountId, region.Name)),
+	}
+	for _, app := range apps {
+		settings := app.repository()
+		repo, err := ecr.NewRepository(ctx, app.Name+"-repo", &ecr.RepositoryArgs{
+			Name:               pulumi.String(app.Name),
+			ImageTagMutability: pulumi.String(settings.mutability()),
+			ImageScanningConfiguration: &ecr.RepositoryImageScanningConfigurationArgs{
+				ScanOnPush: pulumi.Bool(true),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		policy, err := settings.lifecyclePolicy()
+		if err != nil {
+			return nil, err
+		}
+		_, err = ecr.NewLifecyclePolicy(ctx, app.Name+"-lifecycle", &ecr.LifecyclePolicyArgs{
+			Repository: repo.Name,
+			Policy:     pulumi.String(policy),
+		})
+		if err != nil {
+			return nil, err
+		}
+		exports["RepositoryURL-"+app.Name] = repo.RepositoryUrl
+	}
+
+	if len(cfg.Replication) == 0 || len(apps) == 0 {
+		return exports, nil
+	}
+	var destinations ecr.ReplicationConfigurationReplicationConfigurationRuleDestinationArray
+	for _, d := range cfg.Replication {
+		account := d.Account
+		if account == "" {
+			account = caller.AccountId
+		}
+		if account == caller.AccountId && d.Region == region.Name {
+			return nil, fmt.Errorf("registry: can't replicate to the registry's own region %s", d.Region)
+		}
+		destinations = append(destinations, ecr.ReplicationConfigurationReplicationConfigurationRuleDestinationArgs{
+			Region:     pulumi.String(d.Region),
+			RegistryId: pulumi.String(account),
+		})
+		exports["ReplicaRegistryHost-"+d.Region] = pulumi.String(Host(account, d.Region))
+	}
+	// Only the app repositories are replicated, not everything in the
+	// registry. Filters match by prefix, so another repository starting with
+	// an app's name would be replicated too.
+	if err := checkPrefixes(ctx, apps); err != nil {
+		return nil, err
+	}
+	var filters ecr.ReplicationConfigurationReplicationConfigurationRuleRepositoryFilterArray
+	for _, app := range apps {
+		filters = append(filters, ecr.ReplicationConfigurationReplicationConfigurationRuleRepositoryFilterArgs{
+			Filter:     pulumi.String(app.Name),
+			FilterType: pulumi.String("PREFIX_MATCH"),
+		})
+	}
+	_, err = ecr.NewReplicationConfiguration(ctx, "registry-replication", &ecr.ReplicationConfigurationArgs{
+		ReplicationConfiguration: &ecr.ReplicationConfigurationReplicationConfigurationArgs{
+			Rules: ecr.ReplicationConfigurationReplicationConfigurationRuleArray{
+				ecr.ReplicationConfigurationReplicationConfigurationRuleArgs{
+					Destinations:      destinations,
+					RepositoryFilters: filters,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// checkPrefixes returns an error if a repository in the registry that is not
+// an app starts with an app's name.
+func checkPrefixes(ctx *pulumi.Context, apps []App) error {
+	existing, err := ecr.GetRepositories(ctx)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, app := range apps {
+		known[app.Name] = true
+	}
+	for _, name := range existing.Names {
+		if known[name] {
+			continue
+		}
+		for _, app := range apps {
+			if strings.HasPrefix(name, app.Name) {
+				return fmt.Errorf("registry: repository %s would be replicated with app %s, whose name is its prefix; rename one of them", name, app.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package registry
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-foundation/internal/mocks"
+)
+
+func TestLoadAppsManifest(t *testing.T) {
+	apps, err := LoadApps("../../apps.json")
+	if err != nil {
+		t.Fatalf("loading apps.json: %v", err)
+	}
+	if len(apps) == 0 {
+		t.Fatal("expected at least one app in apps.json")
+	}
+}
+
+func TestCreateRepositories(t *testing.T) {
+	apps := []App{
+		{Name: "teamchikynbitts-app"},
+		{Name: "josh-app", Repository: &Repository{Immutable: true}},
+		{Name: "new-app"},
+	}
+
+	m := &mocks.Mocks{}
+	var exports pulumi.Map
+	err := m.Run(func(ctx *pulumi.Context) error {
+		var err error
+		exports, err = CreateRepositories(ctx, apps, Config{})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := m.ByType("aws:ecr/repository:Repository")
+	if len(repos) != len(apps) {
+		t.Fatalf("expected %d repositories, got %d", len(apps), len(repos))
+	}
+	for _, app := range apps {
+		repo, ok := repos[app.Name+"-repo"]
+		if !ok {
+			t.Errorf("no repository registered for %s", app.Name)
+			continue
+		}
+		if got := repo.Inputs["name"].StringValue(); got != app.Name {
+			t.Errorf("repository %s-repo has name %q", app.Name, got)
+		}
+		if !repo.Inputs["imageScanningConfiguration"].ObjectValue()["scanOnPush"].BoolValue() {
+			t.Errorf("repository %s does not scan on push", app.Name)
+		}
+		want := "MUTABLE"
+		if app.Repository != nil && app.Repository.Immutable {
+			want = "IMMUTABLE"
+		}
+		if got := repo.Inputs["imageTagMutability"].StringValue(); got != want {
+			t.Errorf("repository %s is %s, want %s", app.Name, got, want)
+		}
+		if _, ok := m.ByType("aws:ecr/lifecyclePolicy:LifecyclePolicy")[app.Name+"-lifecycle"]; !ok {
+			t.Errorf("no lifecycle policy for %s", app.Name)
+		}
+		if _, ok := exports["RepositoryURL-"+app.Name]; !ok {
+			t.Errorf("no RepositoryURL export for %s", app.Name)
+		}
+	}
+	if host, ok := exports["RegistryHost"].(pulumi.String); !ok || string(host) != "123456789012.dkr.ecr.us-east-1.amazonaws.com" {
+		t.Errorf("RegistryHost export %v", exports["RegistryHost"])
+	}
+	if got := len(m.ByType("aws:ecr/replicationConfiguration:ReplicationConfiguration")); got != 0 {
+		t.Errorf("expected no replication without destinations, got %d", got)
+	}
+}
+
+func TestCreateRepositoriesReplicates(t *testing.T) {
+	apps := []App{{Name: "teamchikynbitts-app"}, {Name: "josh-app"}}
+	cfg := Config{Replication: []Destination{{Region: "eu-west-2"}, {Region: "us-west-2", Account: "210987654321"}}}
+
+	m := &mocks.Mocks{}
+	var exports pulumi.Map
+	err := m.Run(func(ctx *pulumi.Context) error {
+		var err error
+		exports, err = CreateRepositories(ctx, apps, cfg)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replication, ok := m.ByType("aws:ecr/replicationConfiguration:ReplicationConfiguration")["registry-replication"]
+	if !ok {
+		t.Fatal("no replication configuration registered")
+	}
+	rules := replication.Inputs["replicationConfiguration"].ObjectValue()["rules"].ArrayValue()
+	if len(rules) != 1 {
+		t.Fatalf("expected one replication rule, got %d", len(rules))
+	}
+	var destinations []string
+	for _, d := range rules[0].ObjectValue()["destinations"].ArrayValue() {
+		destinations = append(destinations, d.ObjectValue()["registryId"].StringValue()+"/"+d.ObjectValue()["region"].StringValue())
+	}
+	if want := "123456789012/eu-west-2 210987654321/us-west-2"; strings.Join(destinations, " ") != want {
+		t.Errorf("destinations %v, want %s", destinations, want)
+	}
+	filters := rules[0].ObjectValue()["repositoryFilters"].ArrayValue()
+	if len(filters) != len(apps) || filters[1].ObjectValue()["filter"].StringValue() != "josh-app" {
+		t.Errorf("repository filters %v", filters)
+	}
+
+	for key, want := range map[string]string{
+		"ReplicaRegistryHost-eu-west-2": "123456789012.dkr.ecr.eu-west-2.amazonaws.com",
+		"ReplicaRegistryHost-us-west-2": "210987654321.dkr.ecr.us-west-2.amazonaws.com",
+	} {
+		if host, ok := exports[key].(pulumi.String); !ok || string(host) != want {
+			t.Errorf("%s export %v, want %s", key, exports[key], want)
+		}
+	}
+
+	// Another repository the filters would match is rejected.
+	err = (&mocks.Mocks{Repositories: []string{"josh-app", "josh-app-legacy"}}).Run(func(ctx *pulumi.Context) error {
+		_, err := CreateRepositories(ctx, apps, cfg)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "repository josh-app-legacy would be replicated with app josh-app") {
+		t.Errorf("expected a prefix error, got %v", err)
+	}
+
+	// Replicating into the registry's own region is rejected.
+	err = (&mocks.Mocks{}).Run(func(ctx *pulumi.Context) error {
+		_, err := CreateRepositories(ctx, apps, Config{Replication: []Destination{{Region: mocks.Region}}})
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "own region") {
+		t.Errorf("expected an own-region error, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if errs := (Config{Replication: []Destination{{Region: "eu-west-2"}, {Region: "ap-southeast-2", Account: "210987654321"}}}).Validate(); len(errs) != 0 {
+		t.Errorf("expected a valid config, got %v", errs)
+	}
+	errs := Config{Replication: []Destination{
+		{Region: "Europe"},
+		{Region: "eu-west-2", Account: "1234"},
+		{Region: "eu-west-2"},
+	}}.Validate()
+	for _, want := range []string{`invalid region "Europe"`, `account "1234"`, "eu-west-2 is replicated to more than once"} {
+		found := false
+		for _, err := range errs {
+			found = found || strings.Contains(err.Error(), want)
+		}
+		if !found {
+			t.Errorf("no error mentions %s: %v", want, errs)
+		}
+	}
+}
+
+func TestLifecyclePolicy(t *testing.T) {
+	policy, err := App{Name: "josh-app", Repository: &Repository{KeepImages: 3}}.repository().lifecyclePolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Rules []struct {
+			RulePriority int `json:"rulePriority"`
+			Selection    struct {
+				TagStatus      string   `json:"tagStatus"`
+				TagPatternList []string `json:"tagPatternList"`
+				CountType      string   `json:"countType"`
+				CountNumber    int      `json:"countNumber"`
+			} `json:"selection"`
+		} `json:"rules"`
+	}
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Rules) != 4 {
+		t.Fatalf("expected 4 rules, got %s", policy)
+	}
+	for i, r := range doc.Rules {
+		if r.RulePriority != i+1 {
+			t.Errorf("rule %d has priority %d", i, r.RulePriority)
+		}
+	}
+	// Release tags are matched first, so the keep-N rule can't expire them.
+	for i, pattern := range []string{"v*", "*.*.*"} {
+		if s := doc.Rules[i].Selection; len(s.TagPatternList) != 1 || s.TagPatternList[0] != pattern || s.CountNumber != releaseCount {
+			t.Errorf("rule %d does not protect %s: %+v", i+1, pattern, s)
+		}
+	}
+	if s := doc.Rules[2].Selection; s.TagStatus != "untagged" || s.CountType != "sinceImagePushed" || s.CountNumber != defaultUntaggedDays {
+		t.Errorf("untagged rule: %+v", s)
+	}
+	if s := doc.Rules[3].Selection; s.TagStatus != "tagged" || s.CountType != "imageCountMoreThan" || s.CountNumber != 3 {
+		t.Errorf("keep rule: %+v", s)
+	}
+}
+
+func TestLoadAppsRejectsNegativeLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apps.json")
+	if err := os.WriteFile(path, []byte(`[{"name": "josh-app", "repository": {"untagged_days": -1}}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadApps(path); err == nil || !strings.Contains(err.Error(), "must be positive") {
+		t.Errorf("expected a lifecycle error, got %v", err)
+	}
+}
+
+func TestLoadAppsRejectsPrefixNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apps.json")
+	if err := os.WriteFile(path, []byte(`[{"name": "josh-app-v2"}, {"name": "josh-app"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadApps(path); err == nil || !strings.Contains(err.Error(), "app name josh-app is a prefix of app josh-app-v2") {
+		t.Errorf("expected a prefix error, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// Admin ports: SSH and the Kubernetes API.
+const (
+	sshPort = 22
+	apiPort = 6443
+)
+
+// Access limits who can reach the server's admin ports (the "access" stack
+// config). AdminCIDRs may reach the Kubernetes API and, unless SSH is false,
+// SSH. SSH false is SSM-only mode: no key pair is created, port 22 is closed
+// and the kubeconfig is fetched through SSM, so there is no private key in
+// state. Tunnel is for deployers outside the admin CIDRs, such as CI runners:
+// the kubeconfig is fetched through SSM and Pulumi reaches the API through an
+// SSM port-forward to localhost (scripts/api-tunnel.sh), so AdminCIDRs may be
+// empty. A CIDR open to the whole internet is refused unless AllowPublic is
+// set.
+type Access struct {
+	AdminCIDRs  []string `json:"adminCidrs"`
+	SSH         *bool    `json:"ssh,omitempty"`
+	Tunnel      bool     `json:"tunnel,omitempty"`
+	AllowPublic bool     `json:"allowPublic,omitempty"`
+}
+
+// loadAccess reads the "access" config key. It is required, and an error if
+// invalid, so an open admin port fails the preview rather than the deploy.
+func loadAccess(cfg *config.Config) (Access, error) {
+	var a Access
+	if err := cfg.TryObject("access", &a); err != nil {
+		if errors.Is(err, config.ErrMissingVar) {
+			return a, errors.New("access is not configured: run `pulumi config set --path 'access.adminCidrs[0]' <your-ip>/32`")
+		}
+		return a, fmt.Errorf("access: %w", err)
+	}
+	if errs := a.Validate(); len(errs) > 0 {
+		return a, fmt.Errorf("invalid access:\n%w", errors.Join(errs...))
+	}
+	return a, nil
+}
+
+// sshOpen reports whether port 22 is open to the admin CIDRs (default true).
+func (a Access) sshOpen() bool {
+	return a.SSH == nil || *a.SSH
+}
+
+// kubeconfigOverSSH reports whether Pulumi fetches the kubeconfig over SSH
+// rather than SSM.
+func (a Access) kubeconfigOverSSH() bool {
+	return a.sshOpen() && !a.Tunnel
+}
+
+// Validate checks every admin CIDR and refuses public ones unless allowed.
+func (a Access) Validate() []error {
+	var errs []error
+	if len(a.AdminCIDRs) == 0 && !a.Tunnel {
+		errs = append(errs, errors.New("access adminCidrs: at least one CIDR is needed to reach the Kubernetes API (or set access.tunnel to reach it through SSM)"))
+	}
+	for _, cidr := range a.AdminCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("access adminCidrs: %q is not a CIDR", cidr))
+			continue
+		}
+		if prefix != prefix.Masked() {
+			errs = append(errs, fmt.Errorf("access adminCidrs: %q has host bits set (did you mean %s?)", cidr, prefix.Masked()))
+		}
+		if prefix.Bits() == 0 && !a.AllowPublic {
+			errs = append(errs, fmt.Errorf("access adminCidrs: %s opens ports %s to the internet; set access.allowPublic to allow it", cidr, a.ports()))
+		}
+	}
+	return errs
+}
+
+// ports describes the admin ports that are open.
+func (a Access) ports() string {
+	if a.sshOpen() {
+		return fmt.Sprintf("%d and %d", sshPort, apiPort)
+	}
+	return fmt.Sprint(apiPort)
+}
+
+// ingress returns the security group rules: the admin ports for the admin
+// CIDRs, if any, HTTP/HTTPS for everyone, anything between the cluster's
+// nodes, and any extra rules.
+func (a Access) ingress(extra ...*ec2.SecurityGroupIngressArgs) ec2.SecurityGroupIngressArray {
+	var v4, v6 pulumi.StringArray
+	for _, cidr := range a.AdminCIDRs {
+		if netip.MustParsePrefix(cidr).Addr().Is4() {
+			v4 = append(v4, pulumi.String(cidr))
+		} else {
+			v6 = append(v6, pulumi.String(cidr))
+		}
+	}
+	admin := func(port int, description string) *ec2.SecurityGroupIngressArgs {
+		return &ec2.SecurityGroupIngressArgs{
+			Protocol:       pulumi.String("tcp"),
+			FromPort:       pulumi.Int(port),
+			ToPort:         pulumi.Int(port),
+			CidrBlocks:     v4,
+			Ipv6CidrBlocks: v6,
+			Description:    pulumi.String(description),
+		}
+	}
+
+	var rules ec2.SecurityGroupIngressArray
+	if len(a.AdminCIDRs) > 0 {
+		if a.sshOpen() {
+			rules = append(rules, admin(sshPort, "SSH"))
+		}
+		rules = append(rules, admin(apiPort, "Kubernetes API"))
+	}
+	rules = append(rules,
+		// Allow HTTP/HTTPS for Apps (via K3s Traefik/ServiceLB)
+		&ec2.SecurityGroupIngressArgs{
+			Protocol:    pulumi.String("tcp"),
+			FromPort:    pulumi.Int(80),
+			ToPort:      pulumi.Int(80),
+			CidrBlocks:  pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			Description: pulumi.String("HTTP"),
+		},
+		&ec2.SecurityGroupIngressArgs{
+			Protocol:    pulumi.String("tcp"),
+			FromPort:    pulumi.Int(443),
+			ToPort:      pulumi.Int(443),
+			CidrBlocks:  pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			Description: pulumi.String("HTTPS"),
+		},
+		// Nodes reach each other on any port (API, kubelet, flannel VXLAN)
+		&ec2.SecurityGroupIngressArgs{
+			Protocol:    pulumi.String("-1"),
+			FromPort:    pulumi.Int(0),
+			ToPort:      pulumi.Int(0),
+			Self:        pulumi.Bool(true),
+			Description: pulumi.String("Cluster nodes"),
+		},
+	)
+	for _, rule := range extra {
+		rules = append(rules, rule)
+	}
+	return rules
+}
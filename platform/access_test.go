@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"gopkg.in/yaml.v3"
+)
+
+func TestAccessValidate(t *testing.T) {
+	valid := []Access{
+		{AdminCIDRs: []string{"203.0.113.4/32"}},
+		{AdminCIDRs: []string{"198.51.100.0/24", "2001:db8::/48"}},
+		{AdminCIDRs: []string{"0.0.0.0/0"}, AllowPublic: true},
+		{Tunnel: true},
+	}
+	for _, a := range valid {
+		if errs := a.Validate(); len(errs) > 0 {
+			t.Errorf("%+v: unexpected errors %v", a, errs)
+		}
+	}
+
+	closed := false
+	invalid := []struct {
+		access Access
+		want   string
+	}{
+		{Access{}, "at least one CIDR"},
+		{Access{AdminCIDRs: []string{"203.0.113.4"}}, "not a CIDR"},
+		{Access{AdminCIDRs: []string{"203.0.113.4/24"}}, "did you mean 203.0.113.0/24"},
+		{Access{AdminCIDRs: []string{"0.0.0.0/0"}}, "opens ports 22 and 6443 to the internet"},
+		{Access{AdminCIDRs: []string{"::/0"}, SSH: &closed}, "opens ports 6443 to the internet"},
+	}
+	for _, c := range invalid {
+		errs := c.access.Validate()
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), c.want) {
+			t.Errorf("%+v: expected an error mentioning %q, got %v", c.access, c.want, errs)
+		}
+	}
+}
+
+// ingressRule is a security group ingress rule as registered.
+type ingressRule struct {
+	cidrs, ipv6 []string
+}
+
+// runProgram runs the whole platform program with the given access config
+// and returns the k3s-sg ingress rules by port.
+func runProgram(t *testing.T, access string) (*mocks, map[float64]ingressRule, error) {
+	t.Helper()
+	setConfig(t, map[string]string{"access": access})
+	m := &mocks{}
+	err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	rules := map[float64]ingressRule{}
+	if sg, ok := m.byType("aws:ec2/securityGroup:SecurityGroup")["k3s-sg"]; ok {
+		for _, v := range sg.Inputs["ingress"].ArrayValue() {
+			rule := v.ObjectValue()
+			rules[rule["fromPort"].NumberValue()] = ingressRule{
+				cidrs: stringList(rule["cidrBlocks"]),
+				ipv6:  stringList(rule["ipv6CidrBlocks"]),
+			}
+		}
+	}
+	return m, rules, err
+}
+
+func stringList(v resource.PropertyValue) []string {
+	if !v.IsArray() {
+		return nil
+	}
+	var out []string
+	for _, item := range v.ArrayValue() {
+		out = append(out, item.StringValue())
+	}
+	return out
+}
+
+func TestProgramRestrictsAdminIngress(t *testing.T) {
+	m, rules, err := runProgram(t, `{"adminCidrs": ["203.0.113.4/32", "2001:db8::/48"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, port := range []float64{22, 6443} {
+		rule, ok := rules[port]
+		if !ok {
+			t.Errorf("port %v is closed", port)
+			continue
+		}
+		if strings.Join(rule.cidrs, ",") != "203.0.113.4/32" || strings.Join(rule.ipv6, ",") != "2001:db8::/48" {
+			t.Errorf("port %v is open to %v %v", port, rule.cidrs, rule.ipv6)
+		}
+	}
+	for _, port := range []float64{80, 443} {
+		if rule := rules[port]; strings.Join(rule.cidrs, ",") != "0.0.0.0/0" {
+			t.Errorf("port %v is not public: %v", port, rule.cidrs)
+		}
+	}
+	if _, ok := m.byType("command:remote:Command")["get-kubeconfig-v2"]; !ok {
+		t.Error("kubeconfig is not fetched over SSH")
+	}
+	if _, ok := m.byType("aws:ec2/keyPair:KeyPair")["k3s-keypair"]; !ok {
+		t.Error("no key pair for SSH")
+	}
+}
+
+func TestProgramSSMOnly(t *testing.T) {
+	m, rules, err := runProgram(t, `{"adminCidrs": ["203.0.113.4/32"], "ssh": false}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rules[22]; ok {
+		t.Error("port 22 is open")
+	}
+	if _, ok := rules[6443]; !ok {
+		t.Error("port 6443 is closed")
+	}
+	if len(m.byType("command:remote:Command")) != 0 {
+		t.Error("kubeconfig is still fetched over SSH")
+	}
+	if len(m.byType("tls:index/privateKey:PrivateKey")) != 0 || len(m.byType("aws:ec2/keyPair:KeyPair")) != 0 {
+		t.Error("an SSH key is still generated")
+	}
+	if instance := m.byType("aws:ec2/instance:Instance")["k3s-server-v6"]; instance.Inputs.HasValue("keyName") {
+		t.Errorf("instance still has key %v", instance.Inputs["keyName"])
+	}
+	cmd, ok := m.byType("command:local:Command")["get-kubeconfig-ssm"]
+	if !ok {
+		t.Fatal("kubeconfig is not fetched over SSM")
+	}
+	if env := cmd.Inputs["environment"].ObjectValue(); env["INSTANCE_ID"].StringValue() != "k3s-server-v6_id" || env["AWS_REGION"].StringValue() != mockRegion {
+		t.Errorf("SSM command environment %v", env)
+	}
+}
+
+func TestProgramRefusesPublicAdminPorts(t *testing.T) {
+	m, _, err := runProgram(t, `{"adminCidrs": ["0.0.0.0/0"]}`)
+	if err == nil || !strings.Contains(err.Error(), "access.allowPublic") {
+		t.Errorf("expected a public CIDR error, got %v", err)
+	}
+	if got := len(m.all()); got != 0 {
+		t.Errorf("expected no resources before access is valid, got %d", got)
+	}
+
+	_, rules, err := runProgram(t, `{"adminCidrs": ["0.0.0.0/0"], "allowPublic": true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule := rules[22]; strings.Join(rule.cidrs, ",") != "0.0.0.0/0" {
+		t.Errorf("override did not open port 22: %v", rule.cidrs)
+	}
+}
+
+func TestProgramRequiresAccess(t *testing.T) {
+	t.Setenv("PULUMI_CONFIG", "{}")
+	err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", &mocks{}))
+	if err == nil || !strings.Contains(err.Error(), "access is not configured") {
+		t.Errorf("expected a missing access error, got %v", err)
+	}
+}
+
+func TestProgramTunnel(t *testing.T) {
+	m, rules, err := runProgram(t, `{"tunnel": true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, port := range []float64{22, 6443} {
+		if rule, ok := rules[port]; ok {
+			t.Errorf("port %v is open to %v %v", port, rule.cidrs, rule.ipv6)
+		}
+	}
+	if len(m.byType("command:remote:Command")) != 0 {
+		t.Error("kubeconfig is fetched over SSH")
+	}
+	if _, ok := m.byType("command:local:Command")["get-kubeconfig-ssm"]; !ok {
+		t.Error("kubeconfig is not fetched over SSM")
+	}
+	provider := m.byType("pulumi:providers:kubernetes")["k3s-provider-v2"]
+	if kubeconfig := secretString(provider.Inputs["kubeconfig"]); !strings.Contains(kubeconfig, "https://127.0.0.1:6443") {
+		t.Errorf("provider does not go through the tunnel:\n%s", kubeconfig)
+	}
+}
+
+// TestDevStackAccess runs the program with the dev stack's committed access
+// config, which CI previews and deploys with.
+func TestDevStackAccess(t *testing.T) {
+	raw, err := os.ReadFile("Pulumi.dev.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stack struct {
+		Config map[string]interface{} `yaml:"config"`
+	}
+	if err := yaml.Unmarshal(raw, &stack); err != nil {
+		t.Fatal(err)
+	}
+	access, err := json.Marshal(stack.Config["teamchikynbitts-platform:access"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := runProgram(t, string(access)); err != nil {
+		t.Fatal(err)
+	}
+}
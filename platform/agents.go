@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
+	"github.com/pulumi/pulumi-random/sdk/v4/go/random"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// Agent pool defaults and limits.
+const (
+	defaultAgentInstanceType = "t3.small"
+	maxAgents                = 10
+)
+
+var instanceTypePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*\.[a-z0-9]+$`)
+
+// Agents is the k3s agent pool (the optional "agents" stack config). Count
+// agents of InstanceType join the server, spread across both AZs. Spot agents
+// run in an Auto Scaling group that may also launch any of SpotTypes, falls
+// back to on-demand when there is no Spot capacity, and drains an agent
+// before it is interrupted (see createSpotAgents).
+type Agents struct {
+	Count        int      `json:"count"`
+	InstanceType string   `json:"instanceType,omitempty"`
+	Spot         bool     `json:"spot,omitempty"`
+	SpotTypes    []string `json:"spotTypes,omitempty"`
+}
+
+// loadAgents reads the "agents" config key. It returns an empty pool if the
+// key is unset and an error if the pool is invalid, so a bad pool fails the
+// preview rather than the deploy.
+func loadAgents(cfg *config.Config) (Agents, error) {
+	var a Agents
+	if err := cfg.TryObject("agents", &a); err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return a, fmt.Errorf("agents: %w", err)
+	}
+	a = a.withDefaults()
+	if errs := a.Validate(); len(errs) > 0 {
+		return a, fmt.Errorf("invalid agents:\n%w", errors.Join(errs...))
+	}
+	return a, nil
+}
+
+// withDefaults fills in the default instance type.
+func (a Agents) withDefaults() Agents {
+	if a.InstanceType == "" {
+		a.InstanceType = defaultAgentInstanceType
+	}
+	return a
+}
+
+// Validate checks the agent count and instance types.
+func (a Agents) Validate() []error {
+	var errs []error
+	if a.Count < 0 || a.Count > maxAgents {
+		errs = append(errs, fmt.Errorf("agents count %d is outside 0-%d", a.Count, maxAgents))
+	}
+	if !instanceTypePattern.MatchString(a.InstanceType) {
+		errs = append(errs, fmt.Errorf("agents instanceType %q is not an EC2 instance type", a.InstanceType))
+	}
+	if len(a.SpotTypes) > 0 && !a.Spot {
+		errs = append(errs, errors.New("agents spotTypes is set but spot is not"))
+	}
+	for _, t := range a.SpotTypes {
+		if !instanceTypePattern.MatchString(t) {
+			errs = append(errs, fmt.Errorf("agents spotTypes %q is not an EC2 instance type", t))
+		}
+	}
+	return errs
+}
+
+// nodeToken generates the shared secret agents join the server with. It is
+// a Pulumi secret, as is any user data it is written into.
+func nodeToken(ctx *pulumi.Context) (pulumi.StringOutput, error) {
+	token, err := random.NewRandomPassword(ctx, "k3s-node-token", &random.RandomPasswordArgs{
+		Length:  pulumi.Int(48),
+		Special: pulumi.Bool(false),
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	return pulumi.ToSecret(token.Result).(pulumi.StringOutput), nil
+}
+
+// nodeTokenTag marks the servers bootstrapped with the generated node token.
+// Servers from before it have no replacement trigger recorded, so they are
+// replaced once to pick the token up.
+const nodeTokenTag = "NodeToken"
+
+// tokenDigest returns a digest of token for replacement triggers: it changes
+// with the token but, unlike it, needn't be secret.
+func tokenDigest(token pulumi.StringOutput) pulumi.StringOutput {
+	return pulumi.Unsecret(token.ApplyT(func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:8])
+	})).(pulumi.StringOutput)
+}
+
+// serversWithout returns the IDs of the existing k3s-server-v6 instances
+// whose tag key isn't value.
+func serversWithout(ctx *pulumi.Context, key, value string) ([]string, error) {
+	servers := func(filters ...ec2.GetInstancesFilter) ([]string, error) {
+		found, err := ec2.GetInstances(ctx, &ec2.GetInstancesArgs{
+			InstanceStateNames: []string{"pending", "running", "stopping", "stopped"},
+			Filters: append([]ec2.GetInstancesFilter{
+				{Name: "tag:Name", Values: []string{"k3s-server-v6"}},
+			}, filters...),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return found.Ids, nil
+	}
+	all, err := servers()
+	if err != nil {
+		return nil, err
+	}
+	tagged, err := servers(ec2.GetInstancesFilter{Name: "tag:" + key, Values: []string{value}})
+	if err != nil {
+		return nil, err
+	}
+	var without []string
+	for _, id := range all {
+		if !slices.Contains(tagged, id) {
+			without = append(without, id)
+		}
+	}
+	return without, nil
+}
+
+// joinTrigger returns the replacement trigger of a node that joins server:
+// it changes with the token and whenever server is replaced.
+func joinTrigger(server *ec2.Instance, token pulumi.StringOutput) pulumi.StringOutput {
+	return pulumi.Sprintf("token=%s server=%s", tokenDigest(token), server.ID())
+}
+
+// agentUserData installs a k3s agent that joins the server at its private IP,
+// with any extra agent flags.
+func agentUserData(server *ec2.Instance, token pulumi.StringOutput, flags string) pulumi.StringOutput {
+	return pulumi.Sprintf(`#!/bin/bash
+%s
+curl -sfL https://get.k3s.io | K3S_URL=https://%s:%d K3S_TOKEN=%s sh -s - agent%s
+`, credentialProviderScript(), server.PrivateIp, apiPort, token, flags)
+}
+
+// createAgents launches an on-demand agent pool from base, the arguments
+// shared with the server, alternating between subnets so the agents land in
+// both AZs. Agents are replaced when the token or the server changes, since
+// user data only joins them on first boot.
+func createAgents(ctx *pulumi.Context, a Agents, base ec2.InstanceArgs, subnets pulumi.StringArrayOutput, server *ec2.Instance, token pulumi.StringOutput) ([]*ec2.Instance, error) {
+	userData := agentUserData(server, token, "")
+
+	var agents []*ec2.Instance
+	for i := 0; i < a.Count; i++ {
+		name := fmt.Sprintf("k3s-agent-%d", i)
+		args := base
+		args.InstanceType = pulumi.String(a.InstanceType)
+		args.SubnetId = subnets.Index(pulumi.Int(i % 2))
+		args.UserData = userData
+		args.Tags = pulumi.StringMap{
+			"Name": pulumi.String(name),
+		}
+		agent, err := ec2.NewInstance(ctx, name, &args, pulumi.DependsOn([]pulumi.Resource{server}),
+			pulumi.ReplacementTrigger(joinTrigger(server, token)))
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+	return agents, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestAgentsValidate(t *testing.T) {
+	valid := []Agents{
+		{},
+		{Count: 3, InstanceType: "t3.medium", Spot: true},
+		{Count: 3, Spot: true, SpotTypes: []string{"t3a.small", "t2.small"}},
+		{Count: maxAgents, InstanceType: "m7i-flex.large"},
+	}
+	for _, a := range valid {
+		if errs := a.withDefaults().Validate(); len(errs) > 0 {
+			t.Errorf("%+v: unexpected errors %v", a, errs)
+		}
+	}
+
+	invalid := []struct {
+		agents Agents
+		want   string
+	}{
+		{Agents{Count: -1}, "outside 0-10"},
+		{Agents{Count: maxAgents + 1}, "outside 0-10"},
+		{Agents{InstanceType: "small"}, "is not an EC2 instance type"},
+		{Agents{Spot: true, SpotTypes: []string{"t3a"}}, `spotTypes "t3a" is not`},
+		{Agents{SpotTypes: []string{"t3a.small"}}, "spot is not"},
+	}
+	for _, c := range invalid {
+		errs := c.agents.withDefaults().Validate()
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), c.want) {
+			t.Errorf("%+v: expected an error mentioning %q, got %v", c.agents, c.want, errs)
+		}
+	}
+}
+
+func TestProgramWithoutAgents(t *testing.T) {
+	setConfig(t, nil)
+	m := &mocks{}
+	if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.byType("aws:ec2/instance:Instance")); got != 1 {
+		t.Errorf("expected only the server, got %d instances", got)
+	}
+
+	server := m.byType("aws:ec2/instance:Instance")["k3s-server-v6"]
+	if !server.Inputs["userData"].IsSecret() {
+		t.Error("server user data holding the node token is not secret")
+	}
+	if userData := secretString(server.Inputs["userData"]); !strings.Contains(userData, "K3S_TOKEN="+mockNodeToken) {
+		t.Errorf("server does not use the node token:\n%s", userData)
+	}
+}
+
+func TestProgramAgentPool(t *testing.T) {
+	setConfig(t, map[string]string{
+		"agents":   `{"count": 3, "instanceType": "t3.medium"}`,
+		"schedule": `{}`,
+	})
+	m := &mocks{}
+	if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+		t.Fatal(err)
+	}
+
+	instances := m.byType("aws:ec2/instance:Instance")
+	for i, subnet := range []string{"subnet-a", "subnet-b", "subnet-a"} {
+		name := fmt.Sprintf("k3s-agent-%d", i)
+		agent, ok := instances[name]
+		if !ok {
+			t.Errorf("no %s", name)
+			continue
+		}
+		if got := agent.Inputs["subnetId"].StringValue(); got != subnet {
+			t.Errorf("%s: subnet %q, want %q", name, got, subnet)
+		}
+		if got := agent.Inputs["instanceType"].StringValue(); got != "t3.medium" {
+			t.Errorf("%s: instance type %q", name, got)
+		}
+		if _, ok := agent.Inputs["instanceMarketOptions"]; ok {
+			t.Errorf("%s: on-demand agent has market options", name)
+		}
+		if !agent.Inputs["userData"].IsSecret() {
+			t.Errorf("%s: user data holding the node token is not secret", name)
+		}
+		userData := secretString(agent.Inputs["userData"])
+		if !strings.Contains(userData, "K3S_URL=https://"+mockPrivateIP+":6443 K3S_TOKEN="+mockNodeToken+" sh -s - agent\n") {
+			t.Errorf("%s: does not join the server:\n%s", name, userData)
+		}
+		if !strings.Contains(userData, credentialProviderConfig) {
+			t.Errorf("%s: no ECR credential provider", name)
+		}
+	}
+	if got := instances["k3s-server-v6"].Inputs["subnetId"].StringValue(); got != "subnet-a" {
+		t.Errorf("server subnet %q", got)
+	}
+
+	var input struct{ InstanceIds []string }
+	stop := m.byType("aws:scheduler/schedule:Schedule")["k3s-stop"]
+	if err := json.Unmarshal([]byte(stop.Inputs["target"].ObjectValue()["input"].StringValue()), &input); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(input.InstanceIds, ","); got != "k3s-server-v6_id,k3s-agent-0_id,k3s-agent-1_id,k3s-agent-2_id" {
+		t.Errorf("schedule stops %s", got)
+	}
+}
+
+func TestProgramRefusesBadAgents(t *testing.T) {
+	setConfig(t, map[string]string{"agents": `{"count": 50}`})
+	m := &mocks{}
+	err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err == nil || !strings.Contains(err.Error(), "invalid agents") {
+		t.Errorf("expected an invalid agents error, got %v", err)
+	}
+	if got := len(m.all()); got != 0 {
+		t.Errorf("expected no resources before agents are valid, got %d", got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// appsManifest is the shared app registry, read by both the foundation and
+// platform stacks. It lives at the repository root.
+const appsManifest = "../apps.json"
+
+// App is a single entry in apps.json. Namespace defaults to the app name and
+// Path to the app's k8s/ directory.
+type App struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Path      string `json:"path,omitempty"`
+}
+
+// loadApps reads the app registry from disk and fills in defaults.
+func loadApps(path string) ([]App, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var apps []App
+	if err := json.Unmarshal(content, &apps); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i := range apps {
+		if apps[i].Name == "" {
+			return nil, fmt.Errorf("%s: entry %d has no name", path, i)
+		}
+		if apps[i].Namespace == "" {
+			apps[i].Namespace = apps[i].Name
+		}
+		if apps[i].Path == "" {
+			apps[i].Path = "./app/" + apps[i].Name + "/k8s"
+		}
+	}
+	return apps, nil
+}
+
+// kustomizationYAML renders the Flux Kustomization for an app.
+// postBuild.substituteFrom reads PUBLIC_IP and ECR_REGISTRY from the
+// cluster-vars ConfigMap.
+func kustomizationYAML(app App) string {
+	return fmt.Sprintf(`apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: %s
+  namespace: flux-system
+spec:
+  interval: 1m0s
+  targetNamespace: %s
+  sourceRef:
+    kind: GitRepository
+    name: teamchikynbitts-repo
+  path: "%s"
+  prune: true
+  wait: true
+  postBuild:
+    substituteFrom:
+      - kind: ConfigMap
+        name: cluster-vars
+`, app.Name, app.Namespace, app.Path)
+}
+
+// createAppNamespaces creates the target namespace of every app. They are
+// owned by Pulumi, not Flux, so they exist before the first sync. A namespace
+// Flux already created is adopted: server-side apply upserts it, and
+// patchForce takes over the fields kustomize-controller manages.
+func createAppNamespaces(ctx *pulumi.Context, apps []App, provider *kubernetes.Provider) ([]pulumi.Resource, error) {
+	var namespaces []pulumi.Resource
+	for _, app := range apps {
+		ns, err := corev1.NewNamespace(ctx, "ns-"+app.Namespace, &corev1.NamespaceArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(app.Namespace),
+				Annotations: pulumi.StringMap{
+					// Keep Flux from pruning a namespace it didn't create.
+					"kustomize.toolkit.fluxcd.io/prune": pulumi.String("disabled"),
+					"pulumi.com/patchForce":             pulumi.String("true"),
+				},
+			},
+		}, pulumi.Provider(provider))
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, nil
+}
+
+// createAppKustomizations registers one Flux Kustomization per app.
+func createAppKustomizations(ctx *pulumi.Context, apps []App, provider *kubernetes.Provider, dependsOn []pulumi.Resource) error {
+	var docs []string
+	for _, app := range apps {
+		docs = append(docs, kustomizationYAML(app))
+	}
+	_, err := yaml.NewConfigGroup(ctx, "flux-apps", &yaml.ConfigGroupArgs{
+		YAML: docs,
+	}, pulumi.Provider(provider), pulumi.DependsOn(dependsOn))
+	return err
+}
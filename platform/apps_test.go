@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestLoadAppsDefaults(t *testing.T) {
+	apps, err := loadApps(appsManifest)
+	if err != nil {
+		t.Fatalf("loading %s: %v", appsManifest, err)
+	}
+	for _, app := range apps {
+		if app.Namespace == "" || app.Path == "" {
+			t.Errorf("app %s missing defaults: %+v", app.Name, app)
+		}
+	}
+}
+
+func TestCreateAppsAddsNamespaceAndKustomization(t *testing.T) {
+	apps := []App{
+		{Name: "josh-app", Namespace: "josh-app", Path: "./app/josh-app/k8s"},
+		{Name: "new-app", Namespace: "new-app", Path: "./app/new-app/k8s"},
+	}
+
+	m := &mocks{}
+	err := pulumi.RunErr(func(ctx *pulumi.Context) error {
+		provider, err := kubernetes.NewProvider(ctx, "k8s", &kubernetes.ProviderArgs{})
+		if err != nil {
+			return err
+		}
+		namespaces, err := createAppNamespaces(ctx, apps, provider)
+		if err != nil {
+			return err
+		}
+		return createAppKustomizations(ctx, apps, provider, namespaces)
+	}, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces := m.byType("kubernetes:core/v1:Namespace")
+	kustomizations := m.byType("kubernetes:kustomize.toolkit.fluxcd.io/v1:Kustomization")
+	for _, app := range apps {
+		ns, ok := namespaces["ns-"+app.Namespace]
+		if !ok {
+			t.Errorf("no namespace registered for %s", app.Name)
+		} else {
+			annotations := ns.Inputs["metadata"].ObjectValue()["annotations"].ObjectValue()
+			if annotations["kustomize.toolkit.fluxcd.io/prune"].StringValue() != "disabled" || annotations["pulumi.com/patchForce"].StringValue() != "true" {
+				t.Errorf("namespace %s can't adopt one Flux created: %v", app.Namespace, annotations)
+			}
+		}
+		k, ok := kustomizations["flux-system/"+app.Name]
+		if !ok {
+			t.Errorf("no Kustomization registered for %s", app.Name)
+			continue
+		}
+		spec := k.Inputs["spec"].ObjectValue()
+		if got := spec["targetNamespace"].StringValue(); got != app.Namespace {
+			t.Errorf("%s targets namespace %q", app.Name, got)
+		}
+		if got := spec["path"].StringValue(); !strings.HasSuffix(got, app.Name+"/k8s") {
+			t.Errorf("%s syncs path %q", app.Name, got)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// permissionsBoundary reads the permissions boundary from the foundation
+// stack's outputs. The deploy role may only create roles that carry it.
+func permissionsBoundary(ref *pulumi.StackReference, stack string) pulumi.StringOutput {
+	return ref.GetOutput(pulumi.String("PermissionsBoundaryARN")).ApplyT(func(arn interface{}) (string, error) {
+		if arn, ok := arn.(string); ok && arn != "" {
+			return arn, nil
+		}
+		return "", fmt.Errorf("foundation stack %s has no PermissionsBoundaryARN output: run `pulumi up` in foundation first", stack)
+	}).(pulumi.StringOutput)
+}
+
+// registerBoundary adds a stack transformation that sets boundary on every
+// IAM role registered afterwards.
+func registerBoundary(ctx *pulumi.Context, boundary pulumi.StringOutput) error {
+	return ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		props, ok := args.Props.(*iam.RoleArgs)
+		if !ok || props == nil || props.PermissionsBoundary != nil {
+			return nil
+		}
+		copied := *props
+		copied.PermissionsBoundary = boundary
+		return &pulumi.ResourceTransformationResult{Props: &copied, Opts: args.Opts}
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestProgramBoundsEveryRole(t *testing.T) {
+	setConfig(t, map[string]string{
+		"agents":   `{"count": 1, "spot": true}`,
+		"schedule": `{}`,
+	})
+	m := &mocks{}
+	if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+		t.Fatal(err)
+	}
+	roles := m.byType("aws:iam/role:Role")
+	if len(roles) < 4 {
+		t.Fatalf("expected the instance, schedule and fallback roles, got %d", len(roles))
+	}
+	for name, role := range roles {
+		if got := role.Inputs["permissionsBoundary"]; !got.IsString() || got.StringValue() != mockBoundary {
+			t.Errorf("role %s has permissions boundary %v, want %s", name, got, mockBoundary)
+		}
+	}
+}
+
+func TestProgramNeedsFoundationBoundary(t *testing.T) {
+	setConfig(t, nil)
+	m := &mocks{stackOutputs: map[string]interface{}{"RegistryHost": mockRegistryHost}}
+	err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err == nil || !strings.Contains(err.Error(), "no PermissionsBoundaryARN output") {
+		t.Errorf("expected a missing boundary error, got %v", err)
+	}
+}
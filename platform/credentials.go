@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ecrCredentialProviderVersion is the cloud-provider-aws release the
+// kubelet's ECR credential provider is downloaded from.
+const ecrCredentialProviderVersion = "v1.31.0"
+
+// k3s picks up a kubelet image credential provider from these paths.
+const (
+	credentialProviderBinDir = "/var/lib/rancher/credentialprovider/bin"
+	credentialProviderConfig = "/var/lib/rancher/credentialprovider/config.yaml"
+)
+
+// credentialProviderURL is where the credential provider binary is
+// downloaded from.
+var credentialProviderURL = fmt.Sprintf("https://artifacts.k8s.io/binaries/cloud-provider-aws/%s/linux/amd64/ecr-credential-provider-linux-amd64", ecrCredentialProviderVersion)
+
+// credentialProviderYAML is the kubelet's CredentialProviderConfig.
+const credentialProviderYAML = `apiVersion: kubelet.config.k8s.io/v1
+kind: CredentialProviderConfig
+providers:
+- name: ecr-credential-provider
+  apiVersion: credentialprovider.kubelet.k8s.io/v1
+  matchImages:
+  - "*.dkr.ecr.*.amazonaws.com"
+  - "*.dkr.ecr.*.amazonaws.com.cn"
+  defaultCacheDuration: 6h
+`
+
+// credentialProviderScript installs the ECR kubelet credential provider before
+// k3s starts. The kubelet then gets ECR logins from the instance role
+// (AmazonEC2ContainerRegistryReadOnly) whenever it pulls from any ECR
+// registry, so no namespace needs a pull secret and nothing expires.
+func credentialProviderScript() string {
+	return fmt.Sprintf(`# ECR pulls use the instance role through the kubelet credential provider
+mkdir -p %[1]s
+curl -sfL -o %[1]s/ecr-credential-provider \
+  %[3]s
+chmod 755 %[1]s/ecr-credential-provider
+cat > %[2]s <<'EOF'
+%[4]sEOF
+`, credentialProviderBinDir, credentialProviderConfig, credentialProviderURL, credentialProviderYAML)
+}
+
+// credentialProviderSyncScript installs or updates the credential provider on
+// a running node and restarts k3s if anything changed, so the kubelet picks
+// it up. Files are swapped in whole, so it can race the first-boot user data.
+func credentialProviderSyncScript() string {
+	return fmt.Sprintf(`set -eu
+mkdir -p %[1]s
+tmp=$(mktemp -d -p %[1]s/..)
+trap 'rm -rf "$tmp"' EXIT
+curl -sfL -o "$tmp/ecr-credential-provider" %[3]s
+chmod 755 "$tmp/ecr-credential-provider"
+cat > "$tmp/config.yaml" <<'EOF'
+%[4]sEOF
+changed=0
+if ! cmp -s "$tmp/ecr-credential-provider" %[1]s/ecr-credential-provider; then
+  mv "$tmp/ecr-credential-provider" %[1]s/ecr-credential-provider
+  changed=1
+fi
+if ! cmp -s "$tmp/config.yaml" %[2]s; then
+  mv "$tmp/config.yaml" %[2]s
+  changed=1
+fi
+if [ "$changed" = 1 ]; then
+  for unit in k3s k3s-agent; do
+    if systemctl is-active --quiet "$unit"; then systemctl restart "$unit"; fi
+  done
+fi
+`, credentialProviderBinDir, credentialProviderConfig, credentialProviderURL, credentialProviderYAML)
+}
+
+// createCredentialProviderAssociation keeps the credential provider installed
+// on the nodes named nodes with a State Manager association, so running nodes
+// get it, or a new version, without being replaced. It runs on one node at a
+// time, so HA servers restart in turn, and stops at the first failure.
+func createCredentialProviderAssociation(ctx *pulumi.Context, nodes pulumi.StringArray) error {
+	_, err := ssm.NewAssociation(ctx, "k3s-credential-provider", &ssm.AssociationArgs{
+		AssociationName: pulumi.String("k3s-credential-provider"),
+		Name:            pulumi.String("AWS-RunShellScript"),
+		Parameters: pulumi.StringMap{
+			"commands": pulumi.String(credentialProviderSyncScript()),
+		},
+		Targets: ssm.AssociationTargetArray{
+			ssm.AssociationTargetArgs{
+				Key:    pulumi.String("tag:Name"),
+				Values: nodes,
+			},
+		},
+		MaxConcurrency: pulumi.String("1"),
+		MaxErrors:      pulumi.String("0"),
+	})
+	return err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/lb"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// haServers is the number of k3s servers in HA mode: the smallest etcd
+// cluster that survives losing a member.
+const haServers = 3
+
+// API endpoints an HA control plane can sit behind.
+const (
+	endpointEIP = "eip"
+	endpointNLB = "nlb"
+)
+
+// vpcCIDR is the awsx default, spelled out so the NLB's health checks can be
+// let in to the API port.
+const vpcCIDR = "10.0.0.0/16"
+
+// HA is the optional highly available control plane (the "ha" stack config).
+// When Enabled, three k3s servers run embedded etcd (--cluster-init) across
+// the VPC's AZs, and the kubeconfig points at Endpoint: the Elastic IP
+// ("eip", the default) or a Network Load Balancer in front of every server
+// ("nlb"). Single-node is the default.
+//
+// Only "nlb" makes the endpoint highly available. With "eip" the address,
+// the kubeconfig and the app URLs all point at the first server, k3s-server-v6:
+// etcd survives losing it, but nothing reaches the API until the EIP is moved
+// to another server by hand, and the next `pulumi up` moves it back.
+type HA struct {
+	Enabled  bool   `json:"enabled"`
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// loadHA reads the "ha" config key. It returns single-node if the key is
+// unset and an error if it is invalid, so a typo fails the preview.
+func loadHA(cfg *config.Config) (HA, error) {
+	var h HA
+	if err := cfg.TryObject("ha", &h); err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return h, fmt.Errorf("ha: %w", err)
+	}
+	if errs := h.Validate(); len(errs) > 0 {
+		return h, fmt.Errorf("invalid ha:\n%w", errors.Join(errs...))
+	}
+	return h.withDefaults(), nil
+}
+
+// withDefaults fills in the default endpoint.
+func (h HA) withDefaults() HA {
+	if h.Enabled && h.Endpoint == "" {
+		h.Endpoint = endpointEIP
+	}
+	return h
+}
+
+// Validate checks the endpoint.
+func (h HA) Validate() []error {
+	var errs []error
+	switch {
+	case h.Endpoint != "" && !h.Enabled:
+		errs = append(errs, fmt.Errorf("ha endpoint %q is set but ha.enabled is false", h.Endpoint))
+	case h.Endpoint != "" && h.Endpoint != endpointEIP && h.Endpoint != endpointNLB:
+		errs = append(errs, fmt.Errorf("ha endpoint %q is not %q or %q", h.Endpoint, endpointEIP, endpointNLB))
+	}
+	return errs
+}
+
+// nlb reports whether the API sits behind a Network Load Balancer.
+func (h HA) nlb() bool {
+	return h.Enabled && h.Endpoint == endpointNLB
+}
+
+// apiIngress returns the extra security group rules the endpoint needs: the
+// NLB health checks come from inside the VPC. Clients keep their own IPs
+// through the NLB, so the admin CIDRs still apply.
+func (h HA) apiIngress() []*ec2.SecurityGroupIngressArgs {
+	if !h.nlb() {
+		return nil
+	}
+	return []*ec2.SecurityGroupIngressArgs{{
+		Protocol:    pulumi.String("tcp"),
+		FromPort:    pulumi.Int(apiPort),
+		ToPort:      pulumi.Int(apiPort),
+		CidrBlocks:  pulumi.StringArray{pulumi.String(vpcCIDR)},
+		Description: pulumi.String("Kubernetes API load balancer"),
+	}}
+}
+
+// createAPILoadBalancer registers the NLB that fronts the Kubernetes API and
+// its target group. Servers are attached with attachAPIServers once they
+// exist; the NLB comes first because its name is in their certificates.
+func createAPILoadBalancer(ctx *pulumi.Context, vpcID pulumi.StringOutput, subnets pulumi.StringArrayOutput) (*lb.LoadBalancer, *lb.TargetGroup, error) {
+	nlb, err := lb.NewLoadBalancer(ctx, "k3s-api", &lb.LoadBalancerArgs{
+		LoadBalancerType:             pulumi.String("network"),
+		Internal:                     pulumi.Bool(false),
+		Subnets:                      subnets,
+		EnableCrossZoneLoadBalancing: pulumi.Bool(true),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	targets, err := lb.NewTargetGroup(ctx, "k3s-api", &lb.TargetGroupArgs{
+		Port:       pulumi.Int(apiPort),
+		Protocol:   pulumi.String("TCP"),
+		TargetType: pulumi.String("instance"),
+		VpcId:      vpcID,
+		HealthCheck: &lb.TargetGroupHealthCheckArgs{
+			Protocol: pulumi.String("TCP"),
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = lb.NewListener(ctx, "k3s-api", &lb.ListenerArgs{
+		LoadBalancerArn: nlb.Arn,
+		Port:            pulumi.Int(apiPort),
+		Protocol:        pulumi.String("TCP"),
+		DefaultActions: lb.ListenerDefaultActionArray{
+			&lb.ListenerDefaultActionArgs{
+				Type:           pulumi.String("forward"),
+				TargetGroupArn: targets.Arn,
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return nlb, targets, nil
+}
+
+// attachAPIServers registers every server with the API target group.
+func attachAPIServers(ctx *pulumi.Context, targets *lb.TargetGroup, servers []*ec2.Instance) error {
+	for i, server := range servers {
+		_, err := lb.NewTargetGroupAttachment(ctx, fmt.Sprintf("k3s-api-%d", i), &lb.TargetGroupAttachmentArgs{
+			TargetGroupArn: targets.Arn,
+			TargetId:       server.ID().ToStringOutput(),
+			Port:           pulumi.Int(apiPort),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createJoiningServers launches the other HA servers from base, alternating
+// between subnets after the first server's. They join the first server's
+// etcd cluster at its private IP and carry the same TLS SANs. They are
+// replaced along with the first server, or when trigger, the first server's
+// replacement trigger, changes, so they never keep a stale etcd membership.
+func createJoiningServers(ctx *pulumi.Context, base ec2.InstanceArgs, subnets pulumi.StringArrayOutput, first *ec2.Instance, token pulumi.StringOutput, sans pulumi.StringOutput, trigger pulumi.StringOutput) ([]*ec2.Instance, error) {
+	userData := pulumi.Sprintf(`#!/bin/bash
+%s
+curl -sfL https://get.k3s.io | K3S_TOKEN=%s sh -s - server --server https://%s:%d --write-kubeconfig-mode 644%s
+`, credentialProviderScript(), token, first.PrivateIp, apiPort, sans)
+
+	var servers []*ec2.Instance
+	for i := 1; i < haServers; i++ {
+		name := fmt.Sprintf("k3s-server-%d", i)
+		args := base
+		args.InstanceType = pulumi.String("t3.small")
+		args.SubnetId = subnets.Index(pulumi.Int(i % 2))
+		args.UserData = userData
+		args.Tags = pulumi.StringMap{
+			"Name": pulumi.String(name),
+		}
+		server, err := ec2.NewInstance(ctx, name, &args, pulumi.DependsOn([]pulumi.Resource{first}),
+			pulumi.ReplacementTrigger(pulumi.Sprintf("%s %s", trigger, joinTrigger(first, token))))
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
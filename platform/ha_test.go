@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestHAValidate(t *testing.T) {
+	valid := []HA{
+		{},
+		{Enabled: true},
+		{Enabled: true, Endpoint: endpointEIP},
+		{Enabled: true, Endpoint: endpointNLB},
+	}
+	for _, h := range valid {
+		if errs := h.Validate(); len(errs) > 0 {
+			t.Errorf("%+v: unexpected errors %v", h, errs)
+		}
+	}
+
+	invalid := []struct {
+		ha   HA
+		want string
+	}{
+		{HA{Endpoint: endpointNLB}, "ha.enabled is false"},
+		{HA{Enabled: true, Endpoint: "alb"}, `is not "eip" or "nlb"`},
+	}
+	for _, c := range invalid {
+		errs := c.ha.Validate()
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), c.want) {
+			t.Errorf("%+v: expected an error mentioning %q, got %v", c.ha, c.want, errs)
+		}
+	}
+}
+
+func TestProgramSingleServerByDefault(t *testing.T) {
+	setConfig(t, nil)
+	m := &mocks{}
+	if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+		t.Fatal(err)
+	}
+	userData := secretString(m.byType("aws:ec2/instance:Instance")["k3s-server-v6"].Inputs["userData"])
+	if strings.Contains(userData, "--cluster-init") {
+		t.Errorf("single server starts etcd:\n%s", userData)
+	}
+	if len(m.byType("aws:lb/loadBalancer:LoadBalancer")) != 0 {
+		t.Error("single server has a load balancer")
+	}
+}
+
+// runHA runs the whole platform program in HA mode with the given config.
+func runHA(t *testing.T, ha string) *mocks {
+	t.Helper()
+	setConfig(t, map[string]string{"ha": ha})
+	m := &mocks{}
+	if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestProgramHAServers(t *testing.T) {
+	m := runHA(t, `{"enabled": true}`)
+	instances := m.byType("aws:ec2/instance:Instance")
+	if got := len(instances); got != haServers {
+		t.Fatalf("expected %d servers, got %d", haServers, got)
+	}
+
+	first := secretString(instances["k3s-server-v6"].Inputs["userData"])
+	if !strings.Contains(first, "--cluster-init") {
+		t.Errorf("first server does not start etcd:\n%s", first)
+	}
+	for name, subnet := range map[string]string{"k3s-server-1": "subnet-b", "k3s-server-2": "subnet-a"} {
+		server, ok := instances[name]
+		if !ok {
+			t.Errorf("no %s", name)
+			continue
+		}
+		if got := server.Inputs["subnetId"].StringValue(); got != subnet {
+			t.Errorf("%s: subnet %q, want %q", name, got, subnet)
+		}
+		if !server.Inputs["userData"].IsSecret() {
+			t.Errorf("%s: user data holding the node token is not secret", name)
+		}
+		userData := secretString(server.Inputs["userData"])
+		if !strings.Contains(userData, "K3S_TOKEN="+mockNodeToken+" sh -s - server --server https://"+mockPrivateIP+":6443") {
+			t.Errorf("%s: does not join the first server:\n%s", name, userData)
+		}
+		if strings.Contains(userData, "--cluster-init") {
+			t.Errorf("%s: starts its own etcd cluster", name)
+		}
+	}
+	if len(m.byType("aws:lb/loadBalancer:LoadBalancer")) != 0 {
+		t.Error("EIP endpoint has a load balancer")
+	}
+}
+
+func TestProgramHALoadBalancer(t *testing.T) {
+	m := runHA(t, `{"enabled": true, "endpoint": "nlb"}`)
+
+	nlb, ok := m.byType("aws:lb/loadBalancer:LoadBalancer")["k3s-api"]
+	if !ok {
+		t.Fatal("no API load balancer")
+	}
+	if got := nlb.Inputs["loadBalancerType"].StringValue(); got != "network" {
+		t.Errorf("load balancer type %q", got)
+	}
+	listener := m.byType("aws:lb/listener:Listener")["k3s-api"]
+	if got := listener.Inputs["port"].NumberValue(); got != apiPort {
+		t.Errorf("listener port %v", got)
+	}
+
+	targets := map[string]bool{}
+	for _, a := range m.byType("aws:lb/targetGroupAttachment:TargetGroupAttachment") {
+		targets[a.Inputs["targetId"].StringValue()] = true
+	}
+	for _, id := range []string{"k3s-server-v6_id", "k3s-server-1_id", "k3s-server-2_id"} {
+		if !targets[id] {
+			t.Errorf("%s is not behind the load balancer", id)
+		}
+	}
+
+	for name, server := range m.byType("aws:ec2/instance:Instance") {
+		if userData := secretString(server.Inputs["userData"]); !strings.Contains(userData, "--tls-san "+mockNLBDNSName) {
+			t.Errorf("%s: load balancer is not in the certificate:\n%s", name, userData)
+		}
+	}
+
+	provider := m.byType("pulumi:providers:kubernetes")["k3s-provider-v2"]
+	if kubeconfig := secretString(provider.Inputs["kubeconfig"]); !strings.Contains(kubeconfig, "https://"+mockNLBDNSName+":6443") {
+		t.Errorf("kubeconfig does not point at the load balancer:\n%s", kubeconfig)
+	}
+
+	sg := m.byType("aws:ec2/securityGroup:SecurityGroup")["k3s-sg"]
+	found := false
+	for _, v := range sg.Inputs["ingress"].ArrayValue() {
+		rule := v.ObjectValue()
+		if rule["fromPort"].NumberValue() == apiPort && strings.Join(stringList(rule["cidrBlocks"]), ",") == vpcCIDR {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("load balancer health checks cannot reach the API port")
+	}
+}
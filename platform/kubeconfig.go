@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ssmKubeconfigScript reads k3s.yaml through SSM Run Command, retrying until
+// the SSM agent has registered and k3s has written the file. It runs where
+// Pulumi runs, with the AWS CLI and the deployer's credentials.
+const ssmKubeconfigScript = `set -u
+for i in $(seq 1 40); do
+  CMD=$(aws ssm send-command --instance-ids "$INSTANCE_ID" --document-name AWS-RunShellScript \
+    --parameters 'commands=["cat /etc/rancher/k3s/k3s.yaml"]' --query Command.CommandId --output text 2>/dev/null) || { sleep 15; continue; }
+  if aws ssm wait command-executed --command-id "$CMD" --instance-id "$INSTANCE_ID" 2>/dev/null; then
+    aws ssm get-command-invocation --command-id "$CMD" --instance-id "$INSTANCE_ID" --query StandardOutputContent --output text
+    exit 0
+  fi
+  sleep 15
+done
+echo 'Timed out waiting for kubeconfig over SSM' >&2
+exit 1
+`
+
+// kubeconfigOverSSM fetches the k3s kubeconfig through SSM Session Manager's
+// Run Command, for SSM-only mode. The instance role already has
+// AmazonSSMManagedInstanceCore. The output is kept secret.
+func kubeconfigOverSSM(ctx *pulumi.Context, instance *ec2.Instance, region string) (pulumi.StringOutput, error) {
+	cmd, err := local.NewCommand(ctx, "get-kubeconfig-ssm", &local.CommandArgs{
+		Create: pulumi.String(ssmKubeconfigScript),
+		Environment: pulumi.StringMap{
+			"INSTANCE_ID": instance.ID().ToStringOutput(),
+			"AWS_REGION":  pulumi.String(region),
+		},
+		Triggers: pulumi.Array{
+			instance.ID(),
+		},
+	}, pulumi.DependsOn([]pulumi.Resource{instance}), pulumi.AdditionalSecretOutputs([]string{"stdout"}))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	return cmd.Stdout, nil
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"strings"
 
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws"
 	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
 	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/lb"
 	ec2x "github.com/pulumi/pulumi-awsx/sdk/v2/go/awsx/ec2"
 	"github.com/pulumi/pulumi-command/sdk/go/command/remote"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
@@ -14,12 +16,64 @@ import (
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi-tls/sdk/v4/go/tls"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"teamchikynbitts-tagging"
 )
 
 func main() {
-	pulumi.Run(func(ctx *pulumi.Context) error {
-		// 1. SSH Key Generation
-		sshKey, err := tls.NewPrivateKey(ctx, "k3s-ssh-key", &tls.PrivateKeyArgs{
+	pulumi.Run(program)
+}
+
+// program registers the whole platform stack.
+func program(ctx *pulumi.Context) error {
+	// 0. Tag everything for cost allocation
+	tags := tagging.Tags(ctx, "platform-engineers")
+	if err := tagging.Register(ctx, tags); err != nil {
+		return err
+	}
+	cfg := config.New(ctx, "")
+
+	// Admin access is checked before anything is created, so an open SSH or API port
+	// fails the preview.
+	access, err := loadAccess(cfg)
+	if err != nil {
+		return err
+	}
+	agents, err := loadAgents(cfg)
+	if err != nil {
+		return err
+	}
+	ha, err := loadHA(cfg)
+	if err != nil {
+		return err
+	}
+	snapshots, err := loadSnapshots(cfg)
+	if err != nil {
+		return err
+	}
+	if err := checkDatastore(ctx, snapshots, ha); err != nil {
+		return err
+	}
+
+	// Every role carries the foundation's permissions boundary; the registry
+	// host comes from its outputs too, so moving regions or accounts is a
+	// config change there.
+	foundation := foundationStack(ctx, cfg)
+	ref, err := foundationRef(ctx, foundation)
+	if err != nil {
+		return err
+	}
+	if err := registerBoundary(ctx, permissionsBoundary(ref, foundation)); err != nil {
+		return err
+	}
+
+	// 1. SSH Key Generation
+	// Skipped in SSM-only mode (access.ssh false), so no private key ends up in state.
+	var sshKey *tls.PrivateKey
+	var keyName pulumi.StringPtrInput
+	if access.sshOpen() {
+		sshKey, err = tls.NewPrivateKey(ctx, "k3s-ssh-key", &tls.PrivateKeyArgs{
 			Algorithm: pulumi.String("RSA"),
 			RsaBits:   pulumi.Int(4096),
 		})
@@ -33,80 +87,66 @@ func main() {
 		if err != nil {
 			return err
 		}
+		keyName = keyPair.KeyName
+	}
 
-		// 2. Network: Create a simple VPC
-		vpc, err := ec2x.NewVpc(ctx, "eks-vpc", &ec2x.VpcArgs{
-			AvailabilityZoneNames: []string{"us-east-1a", "us-east-1b"},
-		})
-		if err != nil {
-			return err
-		}
+	// 2. Network: Create a simple VPC in two AZs of the stack's region
+	region, err := aws.GetRegion(ctx, nil, nil)
+	if err != nil {
+		return err
+	}
+	// awsx creates the subnets, route tables and NAT gateways inside its component, out of
+	// reach of the stack's transformation, so the tags are passed in. The subnets are awsx's
+	// defaults, a private and a public one per AZ.
+	vpc, err := ec2x.NewVpc(ctx, "eks-vpc", &ec2x.VpcArgs{
+		AvailabilityZoneNames: []string{region.Name + "a", region.Name + "b"},
+		CidrBlock:             pulumi.StringRef(vpcCIDR),
+		Tags:                  pulumi.ToStringMap(tags),
+		SubnetSpecs: []ec2x.SubnetSpecArgs{
+			{Type: ec2x.SubnetTypePrivate, Tags: pulumi.ToStringMap(tags)},
+			{Type: ec2x.SubnetTypePublic, Tags: pulumi.ToStringMap(tags)},
+		},
+	})
+	if err != nil {
+		return err
+	}
 
-		// 3. Security Group
-		sg, err := ec2.NewSecurityGroup(ctx, "k3s-sg", &ec2.SecurityGroupArgs{
-			VpcId: vpc.VpcId,
-			Ingress: ec2.SecurityGroupIngressArray{
-				&ec2.SecurityGroupIngressArgs{
-					Protocol:    pulumi.String("tcp"),
-					FromPort:    pulumi.Int(22),
-					ToPort:      pulumi.Int(22),
-					CidrBlocks:  pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-					Description: pulumi.String("SSH"),
-				},
-				&ec2.SecurityGroupIngressArgs{
-					Protocol:    pulumi.String("tcp"),
-					FromPort:    pulumi.Int(6443),
-					ToPort:      pulumi.Int(6443),
-					CidrBlocks:  pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-					Description: pulumi.String("Kubernetes API"),
-				},
-				// Allow HTTP/HTTPS for Apps (via K3s Traefik/ServiceLB)
-				&ec2.SecurityGroupIngressArgs{
-					Protocol:    pulumi.String("tcp"),
-					FromPort:    pulumi.Int(80),
-					ToPort:      pulumi.Int(80),
-					CidrBlocks:  pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-					Description: pulumi.String("HTTP"),
-				},
-				&ec2.SecurityGroupIngressArgs{
-					Protocol:    pulumi.String("tcp"),
-					FromPort:    pulumi.Int(443),
-					ToPort:      pulumi.Int(443),
-					CidrBlocks:  pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-					Description: pulumi.String("HTTPS"),
-				},
-			},
-			Egress: ec2.SecurityGroupEgressArray{
-				&ec2.SecurityGroupEgressArgs{
-					Protocol:   pulumi.String("-1"),
-					FromPort:   pulumi.Int(0),
-					ToPort:     pulumi.Int(0),
-					CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-				},
+	// 3. Security Group
+	// SSH and the Kubernetes API are only open to the admin CIDRs.
+	sg, err := ec2.NewSecurityGroup(ctx, "k3s-sg", &ec2.SecurityGroupArgs{
+		VpcId:   vpc.VpcId,
+		Ingress: access.ingress(ha.apiIngress()...),
+		Egress: ec2.SecurityGroupEgressArray{
+			&ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
 			},
-		})
-		if err != nil {
-			return err
-		}
+		},
+	})
+	if err != nil {
+		return err
+	}
 
-		// 4. AMI: Ubuntu 24.04 LTS
-		ubuntu, err := ec2.LookupAmi(ctx, &ec2.LookupAmiArgs{
-			MostRecent: pulumi.BoolRef(true),
-			Owners:     []string{"099720109477"},
-			Filters: []ec2.GetAmiFilter{
-				{
-					Name:   "name",
-					Values: []string{"ubuntu/images/hvm-ssd-gp3/ubuntu-noble-24.04-amd64-server-*"},
-				},
+	// 4. AMI: Ubuntu 24.04 LTS
+	ubuntu, err := ec2.LookupAmi(ctx, &ec2.LookupAmiArgs{
+		MostRecent: pulumi.BoolRef(true),
+		Owners:     []string{"099720109477"},
+		Filters: []ec2.GetAmiFilter{
+			{
+				Name:   "name",
+				Values: []string{"ubuntu/images/hvm-ssd-gp3/ubuntu-noble-24.04-amd64-server-*"},
 			},
-		})
-		if err != nil {
-			return err
-		}
+		},
+	})
+	if err != nil {
+		return err
+	}
 
-		// 4a. IAM Role for ECR Access
-		role, err := iam.NewRole(ctx, "k3s-role", &iam.RoleArgs{
-			AssumeRolePolicy: pulumi.String(`{
+	// 4a. IAM Role for ECR Access
+	role, err := iam.NewRole(ctx, "k3s-role", &iam.RoleArgs{
+		AssumeRolePolicy: pulumi.String(`{
 				"Version": "2012-10-17",
 				"Statement": [{
 					"Action": "sts:AssumeRole",
@@ -116,89 +156,228 @@ func main() {
 					}
 				}]
 			}`),
-		})
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = iam.NewRolePolicyAttachment(ctx, "k3s-ecr-attach", &iam.RolePolicyAttachmentArgs{
+		Role:      role.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Also SSM for Session Manager (Debug access)
+	_, err = iam.NewRolePolicyAttachment(ctx, "k3s-ssm-attach", &iam.RolePolicyAttachmentArgs{
+		Role:      role.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// 4b. etcd snapshots (optional), written to S3 with the instance role
+	snapshotBucket := pulumi.String("").ToStringOutput()
+	if snapshots != nil {
+		bucket, err := createSnapshotBucket(ctx, role)
 		if err != nil {
 			return err
 		}
+		snapshotBucket = bucket.Bucket
+		ctx.Export("snapshotBucket", bucket.Bucket)
+	}
 
-		_, err = iam.NewRolePolicyAttachment(ctx, "k3s-ecr-attach", &iam.RolePolicyAttachmentArgs{
-			Role:      role.Name,
-			PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly"),
-		})
+	instanceProfile, err := iam.NewInstanceProfile(ctx, "k3s-profile", &iam.InstanceProfileArgs{
+		Role: role.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 5. Elastic IP (Stable Public Access)
+	// We use an EIP to ensure the public IP address is static across instance replacements.
+	// This is a cost-effective alternative to an AWS Application Load Balancer (ALB).
+	// While LB costs ~$18/mo, an EIP is $0 while attached to a running instance.
+	eip, err := ec2.NewEip(ctx, "k3s-eip", &ec2.EipArgs{
+		Vpc: pulumi.Bool(true),
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String("k3s-eip"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Export("publicIP", eip.PublicIp)
+
+	// 5a. API endpoint
+	// The kubeconfig points at the EIP, or in HA mode optionally at an NLB in front of every
+	// server. Either way the endpoint is in every server's certificate.
+	endpoint := eip.PublicIp
+	nlbSAN := pulumi.String("").ToStringOutput()
+	var apiTargets *lb.TargetGroup
+	if ha.nlb() {
+		var nlb *lb.LoadBalancer
+		nlb, apiTargets, err = createAPILoadBalancer(ctx, vpc.VpcId, vpc.PublicSubnetIds)
 		if err != nil {
 			return err
 		}
+		endpoint = nlb.DnsName
+		nlbSAN = pulumi.Sprintf(" --tls-san %s", nlb.DnsName)
+	}
+	ctx.Export("apiEndpoint", endpoint)
 
-		// Also SSM for Session Manager (Debug access)
-		_, err = iam.NewRolePolicyAttachment(ctx, "k3s-ssm-attach", &iam.RolePolicyAttachmentArgs{
-			Role:      role.Name,
-			PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"),
-		})
+	// In HA mode the first server starts the embedded etcd cluster the others join.
+	// Snapshots need embedded etcd too, but a single server gets it from the snapshot
+	// config on the running instance (6d), so turning them on doesn't touch user data.
+	clusterInit := ""
+	if ha.Enabled {
+		clusterInit = " --cluster-init"
+	}
+
+	// 6. EC2 Instance
+	// Install K3s via UserData (with IMDSv2 token)
+	// We explicitly add the EIP to the TLS SAN list. Agents join with the generated node token.
+	// With snapshots.restore, k3s is bootstrapped from that snapshot before it first starts.
+	token, err := nodeToken(ctx)
+	if err != nil {
+		return err
+	}
+	userData := pulumi.Sprintf(`#!/bin/bash
+TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 21600")
+PUBLIC_IP=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/public-ipv4)
+%s
+curl -sfL https://get.k3s.io | K3S_TOKEN=%s%s sh -s - --write-kubeconfig-mode 644 --tls-san $PUBLIC_IP --tls-san %s%s%s
+%s`, credentialProviderScript(), token, snapshots.installEnv(), eip.PublicIp, nlbSAN, clusterInit, snapshots.restore(token, snapshotBucket, region.Name))
+
+	// HA servers and agents share everything but the subnet, size and user data with the server.
+	nodeArgs := ec2.InstanceArgs{
+		Ami:                      pulumi.String(ubuntu.Id),
+		VpcSecurityGroupIds:      pulumi.StringArray{sg.ID()},
+		IamInstanceProfile:       instanceProfile.Name,
+		AssociatePublicIpAddress: pulumi.Bool(true),
+		KeyName:                  keyName,
+		// User data only runs on first boot, and replacing a node loses its state, so by
+		// default a change to it only stops and starts the node. replaceOnUserDataChange
+		// opts in to new nodes instead.
+		UserDataReplaceOnChange: pulumi.Bool(cfg.GetBool("replaceOnUserDataChange")),
+	}
+	serverArgs := nodeArgs
+	serverArgs.InstanceType = pulumi.String("t3.small")
+	serverArgs.SubnetId = vpc.PublicSubnetIds.Index(pulumi.Int(0))
+	serverArgs.UserData = userData
+	serverTags := pulumi.StringMap{
+		"Name": pulumi.String("k3s-server-v6"),
+	}
+	if ha.Enabled || snapshots != nil {
+		serverTags[datastoreTag] = pulumi.String("etcd")
+	}
+	serverTags[nodeTokenTag] = pulumi.String("generated")
+	serverArgs.Tags = serverTags
+	// User data only runs on first boot, so the server is replaced when anything
+	// it bootstraps the cluster with changes: the node token, HA mode (--cluster-init)
+	// or snapshots.restore. A server from before the node token has no trigger to
+	// compare yet, so its new user data replaces it once.
+	serverTrigger := pulumi.Sprintf("token=%s ha=%t restore=%s", tokenDigest(token), ha.Enabled, snapshots.restored())
+	untokened, err := serversWithout(ctx, nodeTokenTag, "generated")
+	if err != nil {
+		return err
+	}
+	if len(untokened) > 0 {
+		serverArgs.UserDataReplaceOnChange = pulumi.Bool(true)
+	}
+	instance, err := ec2.NewInstance(ctx, "k3s-server-v6", &serverArgs, pulumi.ReplacementTrigger(serverTrigger))
+	if err != nil {
+		return err
+	}
+
+	// 6a. HA servers (optional), joining the first server's etcd across both AZs
+	servers := []*ec2.Instance{instance}
+	if ha.Enabled {
+		joining, err := createJoiningServers(ctx, nodeArgs, vpc.PublicSubnetIds, instance, token,
+			pulumi.Sprintf(" --tls-san %s%s", eip.PublicIp, nlbSAN), serverTrigger)
 		if err != nil {
 			return err
 		}
+		servers = append(servers, joining...)
+	}
+	if apiTargets != nil {
+		if err := attachAPIServers(ctx, apiTargets, servers); err != nil {
+			return err
+		}
+	}
 
-		instanceProfile, err := iam.NewInstanceProfile(ctx, "k3s-profile", &iam.InstanceProfileArgs{
-			Role: role.Name,
-		})
+	// 6b. Agent pool (optional), spread across both AZs
+	// Spot agents run in an Auto Scaling group that falls back to on-demand.
+	var agentInstances []*ec2.Instance
+	var agentGroups []scalingGroup
+	if agents.Spot && agents.Count > 0 {
+		group, err := createSpotAgents(ctx, agents, nodeArgs, role, instanceProfile.Name, vpc.PublicSubnetIds, instance, token, tags)
 		if err != nil {
 			return err
 		}
-
-		// 5. Elastic IP (Stable Public Access)
-		// We use an EIP to ensure the public IP address is static across instance replacements.
-		// This is a cost-effective alternative to an AWS Application Load Balancer (ALB).
-		// While LB costs ~$18/mo, an EIP is $0 while attached to a running instance.
-		eip, err := ec2.NewEip(ctx, "k3s-eip", &ec2.EipArgs{
-			Vpc: pulumi.Bool(true),
-			Tags: pulumi.StringMap{
-				"Name": pulumi.String("k3s-eip"),
-			},
-		})
+		agentGroups = append(agentGroups, scalingGroup{name: "k3s-agent-spot", group: group, size: agents.Count})
+		ctx.Export("spotAgentGroup", group.Name)
+	} else {
+		agentInstances, err = createAgents(ctx, agents, nodeArgs, vpc.PublicSubnetIds, instance, token)
 		if err != nil {
 			return err
 		}
+	}
 
-		ctx.Export("publicIP", eip.PublicIp)
+	// Associate the EIP with the new instance
+	_, err = ec2.NewEipAssociation(ctx, "k3s-eip-assoc", &ec2.EipAssociationArgs{
+		InstanceId:   instance.ID(),
+		AllocationId: eip.AllocationId,
+	})
+	if err != nil {
+		return err
+	}
 
-		// 6. EC2 Instance
-		// Install K3s via UserData (with IMDSv2 token)
-		// We explicitly add the EIP to the TLS SAN list.
-		userData := pulumi.Sprintf(`#!/bin/bash
-TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 21600")
-PUBLIC_IP=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/public-ipv4)
-curl -sfL https://get.k3s.io | sh -s - --write-kubeconfig-mode 644 --tls-san $PUBLIC_IP --tls-san %s
-`, eip.PublicIp)
-
-		instance, err := ec2.NewInstance(ctx, "k3s-server-v6", &ec2.InstanceArgs{
-			Ami:                      pulumi.String(ubuntu.Id),
-			InstanceType:             pulumi.String("t3.small"),
-			VpcSecurityGroupIds:      pulumi.StringArray{sg.ID()},
-			SubnetId:                 vpc.PublicSubnetIds.Index(pulumi.Int(0)),
-			IamInstanceProfile:       instanceProfile.Name,
-			AssociatePublicIpAddress: pulumi.Bool(true),
-			KeyName:                  keyPair.KeyName,
-			UserData:                 userData,
-			Tags: pulumi.StringMap{
-				"Name": pulumi.String("k3s-server-v6"),
-			},
-		})
-		if err != nil {
+	// 6c. ECR credential provider on running nodes
+	// User data installs it on new nodes; the association brings existing ones up to date.
+	var nodeNames pulumi.StringArray
+	for _, node := range append(servers, agentInstances...) {
+		nodeNames = append(nodeNames, node.Tags.MapIndex(pulumi.String("Name")))
+	}
+	for _, g := range agentGroups {
+		nodeNames = append(nodeNames, pulumi.String(g.name))
+	}
+	if err := createCredentialProviderAssociation(ctx, nodeNames); err != nil {
+		return err
+	}
+
+	// 6d. Snapshot config on running servers (optional)
+	// Cron and retention changes restart k3s in place rather than replacing a server.
+	if snapshots != nil {
+		var serverNames pulumi.StringArray
+		for _, server := range servers {
+			serverNames = append(serverNames, server.Tags.MapIndex(pulumi.String("Name")))
+		}
+		dropIn := snapshots.dropIn(snapshotBucket, region.Name, !ha.Enabled)
+		if err := createSnapshotAssociation(ctx, dropIn, serverNames); err != nil {
 			return err
 		}
+	}
 
-		// Associate the EIP with the new instance
-		_, err = ec2.NewEipAssociation(ctx, "k3s-eip-assoc", &ec2.EipAssociationArgs{
-			InstanceId:   instance.ID(),
-			AllocationId: eip.AllocationId,
-		})
-		if err != nil {
+	// 6e. Working-hours schedule (optional)
+	// Stops the nodes out of hours; the EIP stays associated so the IP doesn't change.
+	// Spot agents are scaled to zero instead.
+	schedule, err := loadSchedule(cfg)
+	if err != nil {
+		return err
+	}
+	if schedule != nil {
+		if err := createSchedule(ctx, *schedule, append(servers, agentInstances...), agentGroups); err != nil {
 			return err
 		}
+	}
 
-		ctx.Export("privateKey", sshKey.PrivateKeyOpenssh)
-
-		// 7. Retrieve Kubeconfig
+	// 7. Retrieve Kubeconfig
+	var rawKubeconfig pulumi.StringOutput
+	if access.kubeconfigOverSSH() {
 		// We use a remote command to CAT the file.
 		// We depend on the instance enabling SSH, which takes a moment.
 		// The Connection uses the Public IP.
@@ -216,170 +395,81 @@ curl -sfL https://get.k3s.io | sh -s - --write-kubeconfig-mode 644 --tls-san $PU
 		if err != nil {
 			return err
 		}
-
-		// Fix the Kubeconfig: Replace 127.0.0.1 with Public IP (EIP)
-		kubeconfig := pulumi.All(kubeconfigCmd.Stdout, eip.PublicIp).ApplyT(
-			func(args []interface{}) (string, error) {
-				kconf := args[0].(string)
-				ip := args[1].(string)
-				return strings.Replace(kconf, "127.0.0.1", ip, -1), nil
-			}).(pulumi.StringOutput)
-
-		ctx.Export("kubeconfig", pulumi.ToSecret(kubeconfig))
-		ctx.Export("publicIP", eip.PublicIp)
-		ctx.Export("privateKey", sshKey.PrivateKeyOpenssh)
-
-		// 7. Kubernetes Provider
-		k8sProvider, err := kubernetes.NewProvider(ctx, "k3s-provider-v2", &kubernetes.ProviderArgs{
-			Kubeconfig: kubeconfig,
-		})
+		rawKubeconfig = kubeconfigCmd.Stdout
+	} else {
+		// SSM-only and tunnel modes: the file is read through SSM Run Command.
+		rawKubeconfig, err = kubeconfigOverSSM(ctx, instance, region.Name)
 		if err != nil {
 			return err
 		}
+	}
 
-		// 8. Install Flux V2 via Helm
-		// Flux was chosen over ArgoCD to reduce resource consumption on the single t3.small node.
-		// It manages GitOps synchronization by watching the repository for manifest changes.
-		fluxRelease, err := helm.NewRelease(ctx, "flux2", &helm.ReleaseArgs{
-			Chart:   pulumi.String("flux2"),
-			Version: pulumi.String("2.13.0"), // Stable version
-			RepositoryOpts: &helm.RepositoryOptsArgs{
-				Repo: pulumi.String("https://fluxcd-community.github.io/helm-charts"),
-			},
-			Namespace:       pulumi.String("flux-system"),
-			CreateNamespace: pulumi.Bool(true),
-		}, pulumi.Provider(k8sProvider))
-		if err != nil {
-			return err
-		}
+	// Fix the Kubeconfig: Replace 127.0.0.1 with the API endpoint (EIP or NLB)
+	kubeconfig := pulumi.All(rawKubeconfig, endpoint).ApplyT(
+		func(args []interface{}) (string, error) {
+			kconf := args[0].(string)
+			host := args[1].(string)
+			return strings.Replace(kconf, "127.0.0.1", host, -1), nil
+		}).(pulumi.StringOutput)
 
-		// 10. ECR Credentials CronJob
-		// K3s needs a way to pull private images from ECR. Since ECR tokens expire every 12 hours,
-		// we deploy a CronJob that refreshes the 'regcred' secret every 6 hours.
-		ecrCronYAML := `apiVersion: v1
-kind: ServiceAccount
-metadata:
-  name: ecr-refresher
-  namespace: default
----
-apiVersion: rbac.authorization.k8s.io/v1
-kind: ClusterRole
-metadata:
-  name: ecr-refresher
-rules:
-- apiGroups: [""]
-  resources: ["secrets", "serviceaccounts"]
-  verbs: ["get", "delete", "create", "patch"]
----
-apiVersion: rbac.authorization.k8s.io/v1
-kind: ClusterRoleBinding
-metadata:
-  name: ecr-refresher
-subjects:
-- kind: ServiceAccount
-  name: ecr-refresher
-  namespace: default
-roleRef:
-  kind: ClusterRole
-  name: ecr-refresher
-  apiGroup: rbac.authorization.k8s.io
----
-apiVersion: batch/v1
-kind: CronJob
-metadata:
-  name: ecr-refresher
-  namespace: default
-spec:
-  schedule: "0 */6 * * *" # Every 6 hours
-  successfulJobsHistoryLimit: 1
-  failedJobsHistoryLimit: 1
-  jobTemplate:
-    spec:
-      template:
-        spec:
-          serviceAccountName: ecr-refresher
-          containers:
-          - name: refresher
-            image: amazon/aws-cli:latest
-            command:
-            - /bin/bash
-            - -c
-            - |
-              # Install kubectl
-              curl -o kubectl https://s3.us-west-2.amazonaws.com/amazon-eks/1.27.1/2023-04-19/bin/linux/amd64/kubectl
-              chmod +x kubectl
-              mv kubectl /usr/bin/
-              
-              # Get ECR Token
-              echo "Getting ECR Token..."
-              TOKEN=$(aws ecr get-login-password --region us-east-1)
-              REGISTRY="347788108263.dkr.ecr.us-east-1.amazonaws.com"
-              
-              # Delete existing secret (ignore if not exists)
-              # Update secrets in all app namespaces
-              NAMESPACES=("default" "josh-app" "teamchikynbitts-app")
-              for NS in "${NAMESPACES[@]}"; do
-                echo "Updating secret in namespace $NS"
-                kubectl delete secret regcred -n $NS --ignore-not-found
-                kubectl create secret docker-registry regcred -n $NS \
-                  --docker-server=$REGISTRY \
-                  --docker-username=AWS \
-                  --docker-password=$TOKEN
-                kubectl patch serviceaccount default -n $NS -p '{"imagePullSecrets":[{"name":"regcred"}]}' || echo "SA not found in $NS, skipping patch"
-              done
-              
-              echo "Done!"
-          restartPolicy: Never
----
-apiVersion: batch/v1
-kind: Job
-metadata:
-  name: ecr-refresher-init
-  namespace: default
-spec:
-  template:
-    spec:
-      serviceAccountName: ecr-refresher
-      containers:
-      - name: refresher
-        image: amazon/aws-cli:latest
-        command:
-        - /bin/bash
-        - -c
-        - |
-          # Install kubectl
-          curl -o kubectl https://s3.us-west-2.amazonaws.com/amazon-eks/1.27.1/2023-04-19/bin/linux/amd64/kubectl
-          chmod +x kubectl
-          mv kubectl /usr/bin/
-          
-          # Get ECR Token
-          echo "Getting ECR Token..."
-          TOKEN=$(aws ecr get-login-password --region us-east-1)
-          REGISTRY="347788108263.dkr.ecr.us-east-1.amazonaws.com"
-          
-          NAMESPACES=("default" "josh-app" "teamchikynbitts-app")
-          for NS in "${NAMESPACES[@]}"; do
-            echo "Updating secret in namespace $NS"
-            kubectl delete secret regcred -n $NS --ignore-not-found
-            kubectl create secret docker-registry regcred -n $NS \
-              --docker-server=$REGISTRY \
-              --docker-username=AWS \
-              --docker-password=$TOKEN
-            kubectl patch serviceaccount default -n $NS -p '{"imagePullSecrets":[{"name":"regcred"}]}' || echo "SA not found in $NS, skipping patch"
-          done
-          echo "Done!"
-      restartPolicy: Never
-`
-		_, err = yaml.NewConfigGroup(ctx, "ecr-cron", &yaml.ConfigGroupArgs{
-			YAML: []string{ecrCronYAML},
-		}, pulumi.Provider(k8sProvider))
-		if err != nil {
+	ctx.Export("kubeconfig", pulumi.ToSecret(kubeconfig))
+	ctx.Export("publicIP", eip.PublicIp)
+	if sshKey != nil {
+		ctx.Export("privateKey", pulumi.ToSecret(sshKey.PrivateKeyOpenssh))
+	}
+
+	// 7. Kubernetes Provider
+	// In tunnel mode Pulumi reaches the API through an SSM port-forward to localhost
+	// instead, which k3s's certificate covers.
+	providerKubeconfig := kubeconfig
+	if access.Tunnel {
+		providerKubeconfig = rawKubeconfig
+	}
+	k8sProvider, err := kubernetes.NewProvider(ctx, "k3s-provider-v2", &kubernetes.ProviderArgs{
+		Kubeconfig: providerKubeconfig,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 7a. Spot handler
+	// Drains Spot agents before they are interrupted or scaled in.
+	if len(agentGroups) > 0 {
+		if err := createSpotHandler(ctx, spotHandlerImage(cfg), k8sProvider); err != nil {
 			return err
 		}
+	}
+
+	// 7b. App Namespaces
+	// Every app in the shared registry (apps.json) gets its own namespace.
+	apps, err := loadApps(appsManifest)
+	if err != nil {
+		return err
+	}
+	appNamespaces, err := createAppNamespaces(ctx, apps, k8sProvider)
+	if err != nil {
+		return err
+	}
 
-		// 11. Flux GitRepository
-		// The Source for our Apps
-		repoYAML := `apiVersion: source.toolkit.fluxcd.io/v1
+	// 8. Install Flux V2 via Helm
+	// Flux was chosen over ArgoCD to reduce resource consumption on the single t3.small node.
+	// It manages GitOps synchronization by watching the repository for manifest changes.
+	fluxRelease, err := helm.NewRelease(ctx, "flux2", &helm.ReleaseArgs{
+		Chart:   pulumi.String("flux2"),
+		Version: pulumi.String("2.13.0"), // Stable version
+		RepositoryOpts: &helm.RepositoryOptsArgs{
+			Repo: pulumi.String("https://fluxcd-community.github.io/helm-charts"),
+		},
+		Namespace:       pulumi.String("flux-system"),
+		CreateNamespace: pulumi.Bool(true),
+	}, pulumi.Provider(k8sProvider))
+	if err != nil {
+		return err
+	}
+
+	// 11. Flux GitRepository
+	// The Source for our Apps
+	repoYAML := `apiVersion: source.toolkit.fluxcd.io/v1
 kind: GitRepository
 metadata:
   name: teamchikynbitts-repo
@@ -390,79 +480,38 @@ spec:
   ref:
     branch: main
 `
-		// We need to wait for Flux CRDs to be installed by the Helm chart
-		gitRepo, err := yaml.NewConfigGroup(ctx, "flux-repo", &yaml.ConfigGroupArgs{
-			YAML: []string{repoYAML},
-		}, pulumi.Provider(k8sProvider), pulumi.DependsOn([]pulumi.Resource{fluxRelease}))
-		if err != nil {
-			return err
-		}
+	// We need to wait for Flux CRDs to be installed by the Helm chart
+	gitRepo, err := yaml.NewConfigGroup(ctx, "flux-repo", &yaml.ConfigGroupArgs{
+		YAML: []string{repoYAML},
+	}, pulumi.Provider(k8sProvider), pulumi.DependsOn([]pulumi.Resource{fluxRelease}))
+	if err != nil {
+		return err
+	}
 
-		// Create cluster-vars ConfigMap for Flux variable substitution
-		// This allows manifests to use ${PUBLIC_IP} which Flux will replace at reconcile time
-		_, err = corev1.NewConfigMap(ctx, "cluster-vars", &corev1.ConfigMapArgs{
-			Metadata: &metav1.ObjectMetaArgs{
-				Name:      pulumi.String("cluster-vars"),
-				Namespace: pulumi.String("flux-system"),
-			},
-			Data: pulumi.StringMap{
-				"PUBLIC_IP": eip.PublicIp,
-			},
-		}, pulumi.Provider(k8sProvider), pulumi.DependsOn([]pulumi.Resource{fluxRelease}))
-		if err != nil {
-			return err
-		}
+	registry := registryHost(ref, foundation, region.Name)
 
-		// 10. Flux Kustomizations
-		// App 1: TeamChikynbitts App
-		// postBuild.substituteFrom reads PUBLIC_IP from cluster-vars ConfigMap
-		app1YAML := `apiVersion: kustomize.toolkit.fluxcd.io/v1
-kind: Kustomization
-metadata:
-  name: teamchikynbitts-app
-  namespace: flux-system
-spec:
-  interval: 1m0s
-  targetNamespace: teamchikynbitts-app
-  sourceRef:
-    kind: GitRepository
-    name: teamchikynbitts-repo
-  path: "./app/teamchikynbitts-app/k8s"
-  prune: true
-  wait: true
-  postBuild:
-    substituteFrom:
-      - kind: ConfigMap
-        name: cluster-vars
-`
+	// Create cluster-vars ConfigMap for Flux variable substitution
+	// This allows manifests to use ${PUBLIC_IP} and ${ECR_REGISTRY} which Flux will replace at reconcile time
+	_, err = corev1.NewConfigMap(ctx, "cluster-vars", &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("cluster-vars"),
+			Namespace: pulumi.String("flux-system"),
+		},
+		Data: pulumi.StringMap{
+			"PUBLIC_IP":    eip.PublicIp,
+			"ECR_REGISTRY": registry,
+		},
+	}, pulumi.Provider(k8sProvider), pulumi.DependsOn([]pulumi.Resource{fluxRelease}))
+	if err != nil {
+		return err
+	}
 
-		// App 2: Josh App
-		app2YAML := `apiVersion: kustomize.toolkit.fluxcd.io/v1
-kind: Kustomization
-metadata:
-  name: josh-app
-  namespace: flux-system
-spec:
-  interval: 1m0s
-  targetNamespace: josh-app
-  sourceRef:
-    kind: GitRepository
-    name: teamchikynbitts-repo
-  path: "./app/josh-app/k8s"
-  prune: true
-  wait: true
-  postBuild:
-    substituteFrom:
-      - kind: ConfigMap
-        name: cluster-vars
-`
-		_, err = yaml.NewConfigGroup(ctx, "flux-apps", &yaml.ConfigGroupArgs{
-			YAML: []string{app1YAML, app2YAML},
-		}, pulumi.Provider(k8sProvider), pulumi.DependsOn([]pulumi.Resource{gitRepo}))
-		if err != nil {
-			return err
-		}
+	// 10. Flux Kustomizations
+	// One per app in the shared registry (apps.json)
+	err = createAppKustomizations(ctx, apps, k8sProvider, append([]pulumi.Resource{gitRepo}, appNamespaces...))
+	if err != nil {
+		return err
+	}
 
-		return nil
-	})
+	return nil
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-tagging"
+)
+
+func TestProgramTagsEverything(t *testing.T) {
+	setConfig(t, nil)
+	m := &mocks{}
+	var mu sync.Mutex
+	taggable := map[string]bool{}
+	err := pulumi.RunErr(func(ctx *pulumi.Context) error {
+		err := ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+			mu.Lock()
+			defer mu.Unlock()
+			taggable[args.Type+"::"+args.Name] = tagging.Taggable(args.Props)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return program(ctx)
+	}, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every taggable resource carries the shared tags with the stack's
+	// values, whatever it sets itself.
+	want := map[string]string{
+		tagging.KeyProject:   "teamchikynbitts",
+		tagging.KeyStack:     "platform",
+		tagging.KeyOwner:     "platform-engineers",
+		tagging.KeyManagedBy: "pulumi",
+	}
+	tagged := 0
+	for _, r := range m.all() {
+		if !taggable[r.TypeToken+"::"+r.Name] {
+			continue
+		}
+		tagged++
+		tags := r.Inputs["tags"]
+		if !tags.IsObject() {
+			t.Errorf("%s %s is not tagged", r.TypeToken, r.Name)
+			continue
+		}
+		for key, want := range want {
+			got := tags.ObjectValue()[resource.PropertyKey(key)]
+			if !got.IsString() || got.StringValue() != want {
+				t.Errorf("%s %s: %s tag %v, want %q", r.TypeToken, r.Name, key, got, want)
+			}
+		}
+	}
+	// The VPC, security group, role, instance profile, key pair, EIP and
+	// instance.
+	if tagged < 7 {
+		t.Errorf("expected at least 7 taggable resources, got %d", tagged)
+	}
+
+	// awsx tags the VPC's children with what it is given, not what the
+	// transformation sees.
+	vpc := m.byType("awsx:ec2:Vpc")["eks-vpc"].Inputs
+	specs := vpc["subnetSpecs"].ArrayValue()
+	if len(specs) != 2 || specs[0].ObjectValue()["type"].StringValue() != "Private" || specs[1].ObjectValue()["type"].StringValue() != "Public" {
+		t.Fatalf("VPC subnets are not awsx's defaults: %v", specs)
+	}
+	for _, spec := range specs {
+		tags := spec.ObjectValue()["tags"].ObjectValue()
+		for key, want := range want {
+			if got := tags[resource.PropertyKey(key)]; !got.IsString() || got.StringValue() != want {
+				t.Errorf("%s subnets: %s tag %v, want %q", spec.ObjectValue()["type"].StringValue(), key, got, want)
+			}
+		}
+	}
+
+	instance := m.byType("aws:ec2/instance:Instance")["k3s-server-v6"]
+	volumeTags := instance.Inputs["volumeTags"]
+	if !volumeTags.IsObject() || volumeTags.ObjectValue()[tagging.KeyStack].StringValue() != "platform" {
+		t.Errorf("instance volumes are not tagged: %v", volumeTags)
+	}
+	if name := instance.Inputs["tags"].ObjectValue()["Name"].StringValue(); name != "k3s-server-v6" {
+		t.Errorf("instance Name tag %q was overwritten", name)
+	}
+}
+
+func TestProgramReadsRegistryFromFoundation(t *testing.T) {
+	setConfig(t, nil)
+	m := &mocks{}
+	err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := m.byType("kubernetes:core/v1:ConfigMap")["cluster-vars"].Inputs["data"].ObjectValue()
+	if got := vars["ECR_REGISTRY"].StringValue(); got != mockRegistryHost {
+		t.Errorf("cluster-vars ECR_REGISTRY %q, want %q", got, mockRegistryHost)
+	}
+}
+
+func TestProgramPullsWithInstanceRole(t *testing.T) {
+	setConfig(t, nil)
+	m := &mocks{}
+	err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance := m.byType("aws:ec2/instance:Instance")["k3s-server-v6"]
+	userData := secretString(instance.Inputs["userData"])
+	provider := strings.Index(userData, credentialProviderConfig)
+	install := strings.Index(userData, "get.k3s.io")
+	if provider < 0 || install < provider {
+		t.Errorf("user data does not configure the credential provider before installing k3s:\n%s", userData)
+	}
+	if !strings.Contains(userData, credentialProviderBinDir+"/ecr-credential-provider") {
+		t.Errorf("user data does not install ecr-credential-provider:\n%s", userData)
+	}
+	if instance.Inputs["userDataReplaceOnChange"].BoolValue() {
+		t.Error("a user data change replaces the server and its state")
+	}
+
+	// Running nodes get the provider from the association instead.
+	association, ok := m.byType("aws:ssm/association:Association")["k3s-credential-provider"]
+	if !ok {
+		t.Fatal("no credential provider association")
+	}
+	if got := association.Inputs["name"].StringValue(); got != "AWS-RunShellScript" {
+		t.Errorf("association runs %q", got)
+	}
+	script := association.Inputs["parameters"].ObjectValue()["commands"].StringValue()
+	for _, want := range []string{credentialProviderBinDir + "/ecr-credential-provider", credentialProviderConfig, "systemctl restart"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("association script has no %q:\n%s", want, script)
+		}
+	}
+	target := association.Inputs["targets"].ArrayValue()[0].ObjectValue()
+	if got := stringList(target["values"]); target["key"].StringValue() != "tag:Name" || strings.Join(got, ",") != "k3s-server-v6" {
+		t.Errorf("association targets %v", target)
+	}
+	if got := association.Inputs["maxConcurrency"].StringValue(); got != "1" {
+		t.Errorf("association runs on %s nodes at once", got)
+	}
+
+	role := false
+	for _, attach := range m.byType("aws:iam/rolePolicyAttachment:RolePolicyAttachment") {
+		role = role || strings.HasSuffix(attach.Inputs["policyArn"].StringValue(), "/AmazonEC2ContainerRegistryReadOnly")
+	}
+	if !role {
+		t.Error("the instance role cannot pull from ECR")
+	}
+
+	// Nothing manages pull secrets any more.
+	for _, r := range m.all() {
+		if strings.Contains(r.Name, "ecr-refresher") || strings.Contains(r.Name, "ecr-cron") {
+			t.Errorf("%s %s is still deployed", r.TypeToken, r.Name)
+		}
+	}
+}
+
+func TestProgramReplacesOnUserDataChangeWhenAsked(t *testing.T) {
+	setConfig(t, map[string]string{"replaceOnUserDataChange": "true", "agents": `{"count": 1}`})
+	m := &mocks{}
+	if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+		t.Fatal(err)
+	}
+	for name, instance := range m.byType("aws:ec2/instance:Instance") {
+		if !instance.Inputs["userDataReplaceOnChange"].BoolValue() {
+			t.Errorf("%s is not replaced on a user data change", name)
+		}
+	}
+	target := m.byType("aws:ssm/association:Association")["k3s-credential-provider"].Inputs["targets"].ArrayValue()[0].ObjectValue()
+	if got := stringList(target["values"]); strings.Join(got, ",") != "k3s-server-v6,k3s-agent-0" {
+		t.Errorf("association targets %v", got)
+	}
+}
+
+func TestProgramReplacesNodesWhenTheClusterChanges(t *testing.T) {
+	run := func(m *mocks, values map[string]string) map[string]pulumi.MockResourceArgs {
+		t.Helper()
+		setConfig(t, values)
+		if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+			t.Fatal(err)
+		}
+		return m.byType("aws:ec2/instance:Instance")
+	}
+
+	instances := run(&mocks{}, map[string]string{"agents": `{"count": 1}`})
+	server := instances["k3s-server-v6"]
+	trigger := replacementTrigger(server)
+	if !strings.HasPrefix(trigger, "token=") || !strings.Contains(trigger, " ha=false ") || strings.Contains(trigger, mockNodeToken) {
+		t.Errorf("server replacement trigger %q", trigger)
+	}
+	if got := server.Inputs["tags"].ObjectValue()[nodeTokenTag].StringValue(); got != "generated" {
+		t.Errorf("server %s tag %q", nodeTokenTag, got)
+	}
+	if server.Inputs["userDataReplaceOnChange"].BoolValue() {
+		t.Error("a new stack's server is replaced on a user data change")
+	}
+	if got := replacementTrigger(instances["k3s-agent-0"]); !strings.HasSuffix(got, " server=k3s-server-v6_id") {
+		t.Errorf("agent replacement trigger %q", got)
+	}
+
+	// HA mode is part of the trigger, and joining servers follow the first.
+	instances = run(&mocks{}, map[string]string{"ha": `{"enabled": true}`})
+	if got := replacementTrigger(instances["k3s-server-v6"]); !strings.Contains(got, " ha=true ") {
+		t.Errorf("HA server replacement trigger %q", got)
+	}
+	for _, name := range []string{"k3s-server-1", "k3s-server-2"} {
+		got := replacementTrigger(instances[name])
+		if !strings.Contains(got, " ha=true ") || !strings.HasSuffix(got, " server=k3s-server-v6_id") {
+			t.Errorf("%s replacement trigger %q", name, got)
+		}
+	}
+
+	// A server from before the node token has no trigger recorded, so its
+	// new user data replaces it once; a tagged one is left alone.
+	legacy := run(&mocks{servers: []string{"i-0123"}}, nil)["k3s-server-v6"]
+	if !legacy.Inputs["userDataReplaceOnChange"].BoolValue() {
+		t.Error("a server without the node token is not replaced")
+	}
+	tagged := run(&mocks{servers: []string{"i-0123"}, serverTags: map[string][]string{nodeTokenTag: {"i-0123"}}}, nil)["k3s-server-v6"]
+	if tagged.Inputs["userDataReplaceOnChange"].BoolValue() {
+		t.Error("a server with the node token is replaced on a user data change")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"gopkg.in/yaml.v3"
+)
+
+// mocks records every resource registered during a test run so assertions can
+// be made about what a Pulumi program would create. It also implements the
+// kubernetes:yaml:decode invoke so ConfigGroups expand into their children,
+// reports mockRegion and mockAccountID as the AWS region and account,
+// answers stack references with stackOutputs (default: the foundation's
+// RegistryHost and PermissionsBoundaryARN), finds servers, tagged as in
+// serverTags, as the existing server instances, gives random passwords
+// mockNodeToken and the VPC, instances, load balancers, Auto Scaling groups,
+// SSM documents, launch templates, roles, buckets and commands plausible
+// outputs.
+type mocks struct {
+	mu           sync.Mutex
+	resources    []pulumi.MockResourceArgs
+	stackOutputs map[string]interface{}
+	// servers are the IDs of existing k3s-server-v6 instances, and
+	// serverTags the IDs among them that carry each tag key with the value
+	// the program looks for.
+	servers    []string
+	serverTags map[string][]string
+}
+
+// mockRegion is the region the mocked AWS provider reports.
+const mockRegion = "us-east-1"
+
+// mockAccountID is the account the mocked AWS provider reports.
+const mockAccountID = "123456789012"
+
+// mockRegistryHost is the RegistryHost output of the mocked foundation stack.
+const mockRegistryHost = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+
+// mockBoundary is the PermissionsBoundaryARN output of the mocked foundation
+// stack.
+const mockBoundary = "arn:aws:iam::123456789012:policy/teamchikynbitts-boundary"
+
+// mockNodeToken is the result of every mocked random password.
+const mockNodeToken = "mock-node-token"
+
+// mockSubnets are the public subnets of the mocked awsx VPC, one per AZ.
+var mockSubnets = []interface{}{"subnet-a", "subnet-b"}
+
+// mockPrivateIP is the private IP of every mocked instance.
+const mockPrivateIP = "10.0.0.10"
+
+// mockKubeconfig is what every mocked command prints: enough of k3s.yaml
+// to see where the kubeconfig points.
+const mockKubeconfig = "server: https://127.0.0.1:6443\n"
+
+// mockNLBDNSName is the DNS name of every mocked load balancer.
+const mockNLBDNSName = "k3s-api-0123456789.elb.us-east-1.amazonaws.com"
+
+func (m *mocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	m.mu.Lock()
+	m.resources = append(m.resources, args)
+	m.mu.Unlock()
+	switch args.TypeToken {
+	case "random:index/randomPassword:RandomPassword":
+		state := args.Inputs.Copy()
+		state["result"] = resource.MakeSecret(resource.NewStringProperty(mockNodeToken))
+		return args.Name + "_id", state, nil
+	case "awsx:ec2:Vpc":
+		state := args.Inputs.Copy()
+		state["publicSubnetIds"] = resource.NewArrayProperty(resource.NewPropertyValue(mockSubnets).ArrayValue())
+		return args.Name + "_id", state, nil
+	case "command:remote:Command", "command:local:Command":
+		state := args.Inputs.Copy()
+		state["stdout"] = resource.NewStringProperty(mockKubeconfig)
+		return args.Name + "_id", state, nil
+	case "aws:lb/loadBalancer:LoadBalancer":
+		state := args.Inputs.Copy()
+		state["dnsName"] = resource.NewStringProperty(mockNLBDNSName)
+		return args.Name + "_id", state, nil
+	case "aws:ec2/instance:Instance":
+		state := args.Inputs.Copy()
+		state["privateIp"] = resource.NewStringProperty(mockPrivateIP)
+		return args.Name + "_id", state, nil
+	case "aws:autoscaling/group:Group", "aws:ssm/document:Document":
+		state := args.Inputs.Copy()
+		state["name"] = resource.NewStringProperty(args.Name)
+		state["arn"] = resource.NewStringProperty("arn:aws:mock:" + args.Name)
+		return args.Name + "_id", state, nil
+	case "aws:ec2/launchTemplate:LaunchTemplate":
+		state := args.Inputs.Copy()
+		state["arn"] = resource.NewStringProperty("arn:aws:ec2:" + mockRegion + ":" + mockAccountID + ":launch-template/" + args.Name + "_id")
+		return args.Name + "_id", state, nil
+	case "aws:iam/role:Role":
+		state := args.Inputs.Copy()
+		state["arn"] = resource.NewStringProperty("arn:aws:iam::" + mockAccountID + ":role/" + args.Name)
+		return args.Name + "_id", state, nil
+	case "aws:s3/bucketV2:BucketV2":
+		state := args.Inputs.Copy()
+		state["bucket"] = resource.NewStringProperty(args.Name + "-bucket")
+		state["arn"] = resource.NewStringProperty("arn:aws:s3:::" + args.Name + "-bucket")
+		return args.Name + "-bucket", state, nil
+	}
+	if args.TypeToken == "pulumi:pulumi:StackReference" {
+		outputs := m.stackOutputs
+		if outputs == nil {
+			outputs = map[string]interface{}{"RegistryHost": mockRegistryHost, "PermissionsBoundaryARN": mockBoundary}
+		}
+		state := args.Inputs.Copy()
+		state["outputs"] = resource.NewObjectProperty(resource.NewPropertyMapFromMap(outputs))
+		return args.Name + "_id", state, nil
+	}
+	return args.Name + "_id", args.Inputs, nil
+}
+
+func (m *mocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	switch args.Token {
+	case "kubernetes:yaml:decode":
+		return decodeYAML(args.Args["text"].StringValue())
+	case "aws:index/getRegion:getRegion":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"name": mockRegion}), nil
+	case "aws:index/getCallerIdentity:getCallerIdentity":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"accountId": mockAccountID}), nil
+	case "aws:ec2/getInstances:getInstances":
+		ids := m.servers
+		for _, f := range args.Args["filters"].ArrayValue() {
+			if key, ok := strings.CutPrefix(f.ObjectValue()["name"].StringValue(), "tag:"); ok && key != "Name" {
+				ids = m.serverTags[key]
+			}
+		}
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"ids": ids}), nil
+	}
+	return args.Args, nil
+}
+
+// byType returns the registered resources of the given type token, keyed by
+// resource name.
+func (m *mocks) byType(typ string) map[string]pulumi.MockResourceArgs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := map[string]pulumi.MockResourceArgs{}
+	for _, r := range m.resources {
+		if r.TypeToken == typ {
+			found[r.Name] = r
+		}
+	}
+	return found
+}
+
+// all returns every registered resource, in registration order.
+func (m *mocks) all() []pulumi.MockResourceArgs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]pulumi.MockResourceArgs(nil), m.resources...)
+}
+
+func decodeYAML(text string) (resource.PropertyMap, error) {
+	var objs []interface{}
+	dec := yaml.NewDecoder(strings.NewReader(text))
+	for {
+		var obj map[string]interface{}
+		err := dec.Decode(&obj)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if obj != nil {
+			objs = append(objs, obj)
+		}
+	}
+	return resource.NewPropertyMapFromMap(map[string]interface{}{"result": objs}), nil
+}
+
+// testAccess is the admin access the full-program tests run with.
+const testAccess = `{"adminCidrs": ["203.0.113.0/24"]}`
+
+// setConfig sets the platform stack config for a test. values are keyed
+// without the project prefix, objects as JSON strings; access defaults to
+// testAccess.
+func setConfig(t *testing.T, values map[string]string) {
+	t.Helper()
+	cfg := map[string]string{"teamchikynbitts-platform:access": testAccess}
+	for key, value := range values {
+		cfg["teamchikynbitts-platform:"+key] = value
+	}
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PULUMI_CONFIG", string(raw))
+}
+
+// secretString returns a string input, unwrapping it if it is secret.
+func secretString(v resource.PropertyValue) string {
+	if v.IsSecret() {
+		v = v.SecretValue().Element
+	}
+	return v.StringValue()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// foundationStack returns the foundation stack the registry host is read
+// from: the foundationStack config value, or the stack of the same name in
+// the teamchikynbitts-foundation project.
+func foundationStack(ctx *pulumi.Context, cfg *config.Config) string {
+	if name := cfg.Get("foundationStack"); name != "" {
+		return name
+	}
+	return fmt.Sprintf("%s/teamchikynbitts-foundation/%s", ctx.Organization(), ctx.Stack())
+}
+
+// foundationRef references the foundation stack, whose outputs hold the
+// registry host and the permissions boundary.
+func foundationRef(ctx *pulumi.Context, stack string) (*pulumi.StackReference, error) {
+	return pulumi.NewStackReference(ctx, "foundation", &pulumi.StackReferenceArgs{
+		Name: pulumi.String(stack),
+	})
+}
+
+// registryHost reads the ECR registry host from the foundation stack's
+// outputs. A replica in the platform's own region is preferred, so images
+// are pulled without crossing regions.
+func registryHost(ref *pulumi.StackReference, stack, region string) pulumi.StringOutput {
+	return pulumi.All(
+		ref.GetOutput(pulumi.String("ReplicaRegistryHost-"+region)),
+		ref.GetOutput(pulumi.String("RegistryHost")),
+	).ApplyT(func(hosts []interface{}) (string, error) {
+		for _, host := range hosts {
+			if host, ok := host.(string); ok && host != "" {
+				return host, nil
+			}
+		}
+		return "", fmt.Errorf("foundation stack %s has no RegistryHost output: run `pulumi up` in foundation first", stack)
+	}).(pulumi.StringOutput)
+}
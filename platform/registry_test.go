@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// resolveRegistryHost runs registryHost against m and returns the host.
+func resolveRegistryHost(t *testing.T, m *mocks, region string) (string, error) {
+	t.Helper()
+	hosts := make(chan string, 1)
+	err := pulumi.RunErr(func(ctx *pulumi.Context) error {
+		stack := foundationStack(ctx, config.New(ctx, ""))
+		ref, err := foundationRef(ctx, stack)
+		if err != nil {
+			return err
+		}
+		host := registryHost(ref, stack, region)
+		// Exporting the host makes the run fail if it can't be resolved.
+		ctx.Export("registry", host.ApplyT(func(h string) string {
+			hosts <- h
+			return h
+		}))
+		return nil
+	}, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err != nil {
+		return "", err
+	}
+	select {
+	case host := <-hosts:
+		return host, nil
+	default:
+		t.Fatal("registry host was never resolved")
+		return "", nil
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	m := &mocks{}
+	host, err := resolveRegistryHost(t, m, mockRegion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != mockRegistryHost {
+		t.Errorf("host %q, want %q", host, mockRegistryHost)
+	}
+	ref := m.byType("pulumi:pulumi:StackReference")["foundation"]
+	if got := ref.Inputs["name"].StringValue(); !strings.HasSuffix(got, "/teamchikynbitts-foundation/dev") {
+		t.Errorf("stack reference name %q", got)
+	}
+
+	// A replica in the platform's region wins.
+	replica := "123456789012.dkr.ecr.eu-west-2.amazonaws.com"
+	m = &mocks{stackOutputs: map[string]interface{}{
+		"RegistryHost":                  mockRegistryHost,
+		"ReplicaRegistryHost-eu-west-2": replica,
+	}}
+	if host, err := resolveRegistryHost(t, m, "eu-west-2"); err != nil || host != replica {
+		t.Errorf("got %q, %v; want the eu-west-2 replica", host, err)
+	}
+
+	// The stack can be named in config.
+	t.Setenv("PULUMI_CONFIG", `{"teamchikynbitts-platform:foundationStack": "acme/foundation/prod"}`)
+	m = &mocks{}
+	if _, err := resolveRegistryHost(t, m, mockRegion); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.byType("pulumi:pulumi:StackReference")["foundation"].Inputs["name"].StringValue(); got != "acme/foundation/prod" {
+		t.Errorf("stack reference name %q, want the configured stack", got)
+	}
+}
+
+func TestRegistryHostMissing(t *testing.T) {
+	_, err := resolveRegistryHost(t, &mocks{stackOutputs: map[string]interface{}{}}, mockRegion)
+	if err == nil || !strings.Contains(err.Error(), "no RegistryHost output") {
+		t.Errorf("expected a missing output error, got %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Validate timezones the same way wherever preview runs.
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/scheduler"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// Default working hours: 08:00 to 19:00 on weekdays, UTC.
+const (
+	defaultScheduleStart    = "0 8 ? * MON-FRI *"
+	defaultScheduleStop     = "0 19 ? * MON-FRI *"
+	defaultScheduleTimezone = "UTC"
+)
+
+// Schedule stops the k3s nodes outside working hours (the optional
+// "schedule" stack config). Start and Stop are EventBridge Scheduler cron
+// expressions, with or without the "cron(...)" wrapper, evaluated in
+// Timezone (an IANA name such as "Europe/London"). The EIP stays associated
+// while the instance is stopped, so the public IP and nip.io URLs survive.
+type Schedule struct {
+	Start    string `json:"start,omitempty"`
+	Stop     string `json:"stop,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// loadSchedule reads the "schedule" config key. It returns nil if the key is
+// unset and an error if the schedule is invalid, so bad expressions fail the
+// preview rather than the deploy.
+func loadSchedule(cfg *config.Config) (*Schedule, error) {
+	var s Schedule
+	if err := cfg.TryObject("schedule", &s); err != nil {
+		if errors.Is(err, config.ErrMissingVar) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("schedule: %w", err)
+	}
+	s = s.withDefaults()
+	if errs := s.Validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid schedule:\n%w", errors.Join(errs...))
+	}
+	return &s, nil
+}
+
+// withDefaults fills in the default working hours and timezone.
+func (s Schedule) withDefaults() Schedule {
+	if s.Start == "" {
+		s.Start = defaultScheduleStart
+	}
+	if s.Stop == "" {
+		s.Stop = defaultScheduleStop
+	}
+	if s.Timezone == "" {
+		s.Timezone = defaultScheduleTimezone
+	}
+	return s
+}
+
+// Validate checks both cron expressions and the timezone.
+func (s Schedule) Validate() []error {
+	var errs []error
+	for _, e := range []struct{ name, expr string }{{"start", s.Start}, {"stop", s.Stop}} {
+		if err := validateCron(cronFields(e.expr)); err != nil {
+			errs = append(errs, fmt.Errorf("schedule %s %q: %w", e.name, e.expr, err))
+		}
+	}
+	if cronFields(s.Start) == cronFields(s.Stop) {
+		errs = append(errs, fmt.Errorf("schedule start and stop are both %q", s.Start))
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil || s.Timezone == "Local" {
+		errs = append(errs, fmt.Errorf("schedule timezone %q is not an IANA timezone", s.Timezone))
+	}
+	return errs
+}
+
+// cronFields strips the optional "cron(...)" wrapper and extra spaces.
+func cronFields(expr string) string {
+	expr = strings.TrimSpace(expr)
+	if inner, ok := strings.CutPrefix(expr, "cron("); ok {
+		expr = strings.TrimSuffix(inner, ")")
+	}
+	return strings.Join(strings.Fields(expr), " ")
+}
+
+// cronField describes the values one field of an EventBridge cron
+// expression accepts.
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names[i] stands for min+i
+	special  string   // extra characters allowed in a value, e.g. "LW#"
+}
+
+var cronSpec = []cronField{
+	{name: "minutes", min: 0, max: 59},
+	{name: "hours", min: 0, max: 23},
+	{name: "day-of-month", min: 1, max: 31, special: "LW"},
+	{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}},
+	{name: "day-of-week", min: 1, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}, special: "L#"},
+	{name: "year", min: 1970, max: 2199},
+}
+
+// validateCron checks a six-field EventBridge cron expression: minutes,
+// hours, day-of-month, month, day-of-week and year, where exactly one of
+// day-of-month and day-of-week is "?".
+func validateCron(expr string) error {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronSpec) {
+		return fmt.Errorf("want 6 fields (minutes hours day-of-month month day-of-week year), got %d", len(fields))
+	}
+	var errs []error
+	for i, f := range fields {
+		if err := cronSpec[i].validate(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if (fields[2] == "?") == (fields[4] == "?") {
+		errs = append(errs, errors.New(`exactly one of day-of-month and day-of-week must be "?"`))
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks one field: "*", "?" (day fields only) or a comma-separated
+// list of values, ranges and steps.
+func (c cronField) validate(field string) error {
+	if field == "?" {
+		if c.name != "day-of-month" && c.name != "day-of-week" {
+			return fmt.Errorf(`%s: "?" is only allowed in the day fields`, c.name)
+		}
+		return nil
+	}
+	for _, item := range strings.Split(field, ",") {
+		if err := c.validateItem(item); err != nil {
+			return fmt.Errorf("%s: %q: %w", c.name, item, err)
+		}
+	}
+	return nil
+}
+
+func (c cronField) validateItem(item string) error {
+	base, step, hasStep := strings.Cut(item, "/")
+	if hasStep {
+		n, err := strconv.Atoi(step)
+		if err != nil || n < 1 {
+			return fmt.Errorf("step %q is not a positive number", step)
+		}
+	}
+	if base == "*" {
+		return nil
+	}
+	if c.special != "" && strings.ContainsAny(base, c.special) {
+		return c.validateSpecial(base)
+	}
+	from, to, isRange := strings.Cut(base, "-")
+	low, err := c.value(from)
+	if err != nil {
+		return err
+	}
+	if isRange {
+		high, err := c.value(to)
+		if err != nil {
+			return err
+		}
+		if high < low {
+			return fmt.Errorf("range %s is backwards", base)
+		}
+	}
+	return nil
+}
+
+// validateSpecial checks the L, W and # forms of the day fields.
+func (c cronField) validateSpecial(base string) error {
+	switch {
+	case base == "L" || base == "LW" && c.name == "day-of-month":
+		return nil
+	case strings.HasSuffix(base, "W") && c.name == "day-of-month":
+		_, err := c.value(strings.TrimSuffix(base, "W"))
+		return err
+	case strings.HasSuffix(base, "L") && c.name == "day-of-week":
+		_, err := c.value(strings.TrimSuffix(base, "L"))
+		return err
+	case strings.Contains(base, "#") && c.name == "day-of-week":
+		day, nth, _ := strings.Cut(base, "#")
+		if _, err := c.value(day); err != nil {
+			return err
+		}
+		if n, err := strconv.Atoi(nth); err != nil || n < 1 || n > 5 {
+			return fmt.Errorf("#%s is not a week of the month (1-5)", nth)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported form %q", base)
+}
+
+// value parses a number or name within the field's range.
+func (c cronField) value(s string) (int, error) {
+	for i, name := range c.names {
+		if strings.EqualFold(s, name) {
+			return c.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if n < c.min || n > c.max {
+		return 0, fmt.Errorf("%d is outside %d-%d", n, c.min, c.max)
+	}
+	return n, nil
+}
+
+// scalingGroup is an Auto Scaling group the schedule empties outside working
+// hours and brings back to size.
+type scalingGroup struct {
+	name  string
+	group *autoscaling.Group
+	size  int
+}
+
+// createSchedule registers the EventBridge Scheduler schedules that start and
+// stop the instances and empty and refill the groups, and the role they run
+// as, which may only start and stop those instances and resize those groups.
+func createSchedule(ctx *pulumi.Context, s Schedule, instances []*ec2.Instance, groups []scalingGroup) error {
+	role, err := iam.NewRole(ctx, "k3s-schedule", &iam.RoleArgs{
+		AssumeRolePolicy: pulumi.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Action": "sts:AssumeRole",
+				"Effect": "Allow",
+				"Principal": {
+					"Service": "scheduler.amazonaws.com"
+				}
+			}]
+		}`),
+	})
+	if err != nil {
+		return err
+	}
+	var arns, ids, groupArns pulumi.StringArray
+	for _, instance := range instances {
+		arns = append(arns, instance.Arn)
+		ids = append(ids, instance.ID().ToStringOutput())
+	}
+	for _, g := range groups {
+		groupArns = append(groupArns, g.group.Arn)
+	}
+	policy := pulumi.All(arns, groupArns).ApplyT(func(args []interface{}) (string, error) {
+		statements := []map[string]interface{}{{
+			"Effect":   "Allow",
+			"Action":   []string{"ec2:StartInstances", "ec2:StopInstances"},
+			"Resource": args[0].([]string),
+		}}
+		if groupArns, _ := args[1].([]string); len(groupArns) > 0 {
+			statements = append(statements, map[string]interface{}{
+				"Effect":   "Allow",
+				"Action":   "autoscaling:UpdateAutoScalingGroup",
+				"Resource": groupArns,
+			})
+		}
+		doc, err := json.Marshal(map[string]interface{}{
+			"Version":   "2012-10-17",
+			"Statement": statements,
+		})
+		return string(doc), err
+	}).(pulumi.StringOutput)
+	_, err = iam.NewRolePolicy(ctx, "k3s-schedule", &iam.RolePolicyArgs{
+		Role:   role.Name,
+		Policy: policy,
+	})
+	if err != nil {
+		return err
+	}
+
+	input := ids.ToStringArrayOutput().ApplyT(func(ids []string) (string, error) {
+		doc, err := json.Marshal(map[string][]string{"InstanceIds": ids})
+		return string(doc), err
+	}).(pulumi.StringOutput)
+	for _, action := range []struct{ name, expr, api string }{
+		{"k3s-start", s.Start, "startInstances"},
+		{"k3s-stop", s.Stop, "stopInstances"},
+	} {
+		err := newSchedule(ctx, action.name, s, action.expr, "ec2:"+action.api, role, input)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, g := range groups {
+		for _, action := range []struct {
+			name, expr string
+			size       int
+		}{
+			{g.name + "-start", s.Start, g.size},
+			{g.name + "-stop", s.Stop, 0},
+		} {
+			size := action.size
+			input := g.group.Name.ApplyT(func(name string) (string, error) {
+				doc, err := json.Marshal(map[string]interface{}{
+					"AutoScalingGroupName": name,
+					"MinSize":              size,
+					"DesiredCapacity":      size,
+				})
+				return string(doc), err
+			}).(pulumi.StringOutput)
+			err := newSchedule(ctx, action.name, s, action.expr, "autoscaling:updateAutoScalingGroup", role, input)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newSchedule registers a schedule that calls api, "service:action", with
+// input as role at each time expr matches.
+func newSchedule(ctx *pulumi.Context, name string, s Schedule, expr, api string, role *iam.Role, input pulumi.StringOutput) error {
+	_, err := scheduler.NewSchedule(ctx, name, &scheduler.ScheduleArgs{
+		ScheduleExpression:         pulumi.String("cron(" + cronFields(expr) + ")"),
+		ScheduleExpressionTimezone: pulumi.String(s.Timezone),
+		FlexibleTimeWindow: &scheduler.ScheduleFlexibleTimeWindowArgs{
+			Mode: pulumi.String("OFF"),
+		},
+		Target: &scheduler.ScheduleTargetArgs{
+			// Universal target: EventBridge Scheduler calls the AWS API directly.
+			Arn:     pulumi.String("arn:aws:scheduler:::aws-sdk:" + api),
+			RoleArn: role.Arn,
+			Input:   input,
+		},
+	})
+	return err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+func TestValidateCron(t *testing.T) {
+	valid := []string{
+		"0 8 ? * MON-FRI *",
+		"30 18 ? * 2-6 *",
+		"0/15 * ? * * *",
+		"0 7,19 * * ? *",
+		"0 9 L * ? *",
+		"0 9 15W * ? *",
+		"0 9 ? * 6#3 *",
+		"0 9 ? JAN-MAR,DEC FRIL 2026-2030",
+	}
+	for _, expr := range valid {
+		if err := validateCron(expr); err != nil {
+			t.Errorf("%q: %v", expr, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"0 8 * * MON-FRI":       "want 6 fields",
+		"0 8 * * MON-FRI *":     "exactly one of day-of-month and day-of-week",
+		"0 8 ? * ? *":           "exactly one of day-of-month and day-of-week",
+		"60 8 ? * MON-FRI *":    "minutes",
+		"0 24 ? * MON-FRI *":    "hours",
+		"0 8 ? * FRI-MON *":     "backwards",
+		"0 8 ? * MON-FUN *":     `"FUN" is not a number`,
+		"0 8 ? * 2#6 *":         "week of the month",
+		"0 */0 ? * MON-FRI *":   "step",
+		"0 8 ? ? MON-FRI *":     `"?" is only allowed`,
+		"0 8 ? 13 MON-FRI 2026": "month",
+	}
+	for expr, want := range invalid {
+		err := validateCron(expr)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", expr, want, err)
+		}
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	s := Schedule{Start: "cron(0 8 ? * MON-FRI *)", Stop: "0 19 ? * MON-FRI *", Timezone: "Europe/London"}
+	if errs := s.Validate(); len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	s = Schedule{Start: "0 8 ? * MON-FRI *", Stop: "cron(0  8 ? * MON-FRI *)", Timezone: "Mars/Olympus"}
+	errs := s.Validate()
+	if len(errs) != 2 ||
+		!strings.Contains(errs[0].Error(), "both") ||
+		!strings.Contains(errs[1].Error(), `"Mars/Olympus" is not an IANA timezone`) {
+		t.Errorf("expected same-expression and timezone errors, got %v", errs)
+	}
+}
+
+func TestLoadSchedule(t *testing.T) {
+	run := func(value string) (s *Schedule, err error) {
+		cfg := map[string]string{}
+		if value != "" {
+			cfg["teamchikynbitts-platform:schedule"] = value
+		}
+		raw, _ := json.Marshal(cfg)
+		t.Setenv("PULUMI_CONFIG", string(raw))
+		runErr := pulumi.RunErr(func(ctx *pulumi.Context) error {
+			s, err = loadSchedule(config.New(ctx, ""))
+			return nil
+		}, pulumi.WithMocks("teamchikynbitts-platform", "dev", &mocks{}))
+		if runErr != nil {
+			t.Fatal(runErr)
+		}
+		return s, err
+	}
+
+	if s, err := run(""); s != nil || err != nil {
+		t.Errorf("unset schedule: got %+v, %v", s, err)
+	}
+	s, err := run(`{"timezone": "America/Chicago"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Start != defaultScheduleStart || s.Stop != defaultScheduleStop || s.Timezone != "America/Chicago" {
+		t.Errorf("defaults not applied: %+v", s)
+	}
+	if _, err := run(`{"stop": "0 25 ? * MON-FRI *"}`); err == nil || !strings.Contains(err.Error(), "hours") {
+		t.Errorf("expected the bad stop expression to fail, got %v", err)
+	}
+}
+
+func TestCreateSchedule(t *testing.T) {
+	m := &mocks{}
+	err := pulumi.RunErr(func(ctx *pulumi.Context) error {
+		instance, err := ec2.NewInstance(ctx, "k3s-server-v6", &ec2.InstanceArgs{
+			Ami:          pulumi.String("ami-123"),
+			InstanceType: pulumi.String("t3.small"),
+		})
+		if err != nil {
+			return err
+		}
+		return createSchedule(ctx, Schedule{
+			Start:    "cron(0 8 ? * MON-FRI *)",
+			Stop:     "0 19 ? * MON-FRI *",
+			Timezone: "Europe/London",
+		}, []*ec2.Instance{instance}, nil)
+	}, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedules := m.byType("aws:scheduler/schedule:Schedule")
+	for name, want := range map[string]struct{ expr, api string }{
+		"k3s-start": {"cron(0 8 ? * MON-FRI *)", "startInstances"},
+		"k3s-stop":  {"cron(0 19 ? * MON-FRI *)", "stopInstances"},
+	} {
+		s, ok := schedules[name]
+		if !ok {
+			t.Errorf("no %s schedule", name)
+			continue
+		}
+		if got := s.Inputs["scheduleExpression"].StringValue(); got != want.expr {
+			t.Errorf("%s: expression %q, want %q", name, got, want.expr)
+		}
+		if got := s.Inputs["scheduleExpressionTimezone"].StringValue(); got != "Europe/London" {
+			t.Errorf("%s: timezone %q", name, got)
+		}
+		target := s.Inputs["target"].ObjectValue()
+		if got := target["arn"].StringValue(); got != "arn:aws:scheduler:::aws-sdk:ec2:"+want.api {
+			t.Errorf("%s: target %q", name, got)
+		}
+		if got := target["input"].StringValue(); got != `{"InstanceIds":["k3s-server-v6_id"]}` {
+			t.Errorf("%s: input %q", name, got)
+		}
+	}
+	if len(m.byType("aws:iam/rolePolicy:RolePolicy")) != 1 {
+		t.Error("expected the schedule role policy")
+	}
+}
@@ -0,0 +1,52 @@
+#!/bin/bash
+set -u
+
+# Forwards localhost:6443 to the Kubernetes API of k3s-server-v6 through an
+# SSM Session Manager port-forward, for access.tunnel. Start it in the
+# background before `pulumi preview` or `pulumi up`; it follows the server
+# across replacements until it is killed. Needs the AWS CLI, the Session
+# Manager plugin and ssm:StartSession with AWS-StartPortForwardingSession.
+
+PORT=6443
+SESSION=""
+CURRENT=""
+
+if ! command -v session-manager-plugin > /dev/null; then
+    echo "Error: the AWS Session Manager plugin is not installed." >&2
+    exit 1
+fi
+
+# The newest running server: during a replacement the new one is the one
+# Pulumi's kubeconfig belongs to.
+server() {
+    aws ec2 describe-instances \
+        --filters Name=tag:Name,Values=k3s-server-v6 Name=instance-state-name,Values=running \
+        --query 'sort_by(Reservations[].Instances[], &LaunchTime)[-1].InstanceId' \
+        --output text 2> /dev/null
+}
+
+stop() {
+    if [ -n "$SESSION" ]; then
+        pkill -P "$SESSION" 2> /dev/null
+        kill "$SESSION" 2> /dev/null
+        wait "$SESSION" 2> /dev/null
+    fi
+    SESSION=""
+    CURRENT=""
+}
+trap stop EXIT
+
+while true; do
+    ID=$(server)
+    if [ "$ID" != "$CURRENT" ] || ! kill -0 "$SESSION" 2> /dev/null; then
+        stop
+        if [ -n "$ID" ] && [ "$ID" != "None" ]; then
+            echo "Forwarding localhost:$PORT to $ID:$PORT"
+            aws ssm start-session --target "$ID" --document-name AWS-StartPortForwardingSession \
+                --parameters "{\"portNumber\":[\"$PORT\"],\"localPortNumber\":[\"$PORT\"]}" &
+            SESSION=$!
+            CURRENT=$ID
+        fi
+    fi
+    sleep 15
+done
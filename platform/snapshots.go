@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/s3"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// Snapshot defaults: every six hours, keeping a week's worth per server.
+const (
+	defaultSnapshotCron      = "0 */6 * * *"
+	defaultSnapshotRetention = 28
+)
+
+// datastoreTag marks the servers that run embedded etcd rather than SQLite.
+const datastoreTag = "Datastore"
+
+// snapshotFolder is the bucket prefix k3s writes snapshots under.
+const snapshotFolder = "k3s"
+
+// noncurrentSnapshotDays is how long a snapshot k3s has pruned, or an
+// overwritten one, stays recoverable as a noncurrent version.
+const noncurrentSnapshotDays = 30
+
+// snapshotDropIn is the k3s config file the snapshot settings are written
+// to; k3s merges it into config.yaml on every start.
+const snapshotDropIn = "/etc/rancher/k3s/config.yaml.d/snapshots.yaml"
+
+var (
+	snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	cronFieldPattern    = regexp.MustCompile(`^[0-9A-Za-z*?/,-]+$`)
+)
+
+// cronDescriptors are the shorthands k3s accepts for a snapshot cron.
+var cronDescriptors = []string{"@yearly", "@annually", "@monthly", "@weekly", "@daily", "@midnight", "@hourly"}
+
+// Snapshots backs the k3s datastore up to S3 (the optional "snapshots" stack
+// config). Every server takes an etcd snapshot on Cron, a standard
+// five-field cron expression in UTC, and k3s keeps the latest Retention of
+// each. Snapshots need embedded etcd, so a single server runs it
+// (cluster-init) instead of SQLite; MigrateSQLite allows migrating an
+// existing server's SQLite datastore. Changing Restore replaces every server
+// and bootstraps the first from that snapshot in the bucket.
+type Snapshots struct {
+	Cron          string `json:"cron,omitempty"`
+	Retention     int    `json:"retention,omitempty"`
+	Restore       string `json:"restore,omitempty"`
+	MigrateSQLite bool   `json:"migrateSqlite,omitempty"`
+}
+
+// loadSnapshots reads the "snapshots" config key. It returns nil if the key
+// is unset and an error if it is invalid, so a bad cron or snapshot name
+// fails the preview rather than the server's first boot.
+func loadSnapshots(cfg *config.Config) (*Snapshots, error) {
+	var s Snapshots
+	if err := cfg.TryObject("snapshots", &s); err != nil {
+		if errors.Is(err, config.ErrMissingVar) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("snapshots: %w", err)
+	}
+	s = s.withDefaults()
+	if errs := s.Validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid snapshots:\n%w", errors.Join(errs...))
+	}
+	return &s, nil
+}
+
+// withDefaults fills in the default cron and retention.
+func (s Snapshots) withDefaults() Snapshots {
+	if s.Cron == "" {
+		s.Cron = defaultSnapshotCron
+	}
+	if s.Retention == 0 {
+		s.Retention = defaultSnapshotRetention
+	}
+	return s
+}
+
+// Validate checks the cron expression, retention and snapshot name.
+func (s Snapshots) Validate() []error {
+	var errs []error
+	if err := validateSnapshotCron(s.Cron); err != nil {
+		errs = append(errs, fmt.Errorf("snapshots cron %q: %w", s.Cron, err))
+	}
+	if s.Retention < 1 {
+		errs = append(errs, fmt.Errorf("snapshots retention %d is not a positive number", s.Retention))
+	}
+	if s.Restore != "" && !snapshotNamePattern.MatchString(s.Restore) {
+		errs = append(errs, fmt.Errorf("snapshots restore %q is not a snapshot name", s.Restore))
+	}
+	return errs
+}
+
+// validateSnapshotCron checks the shape of a k3s snapshot schedule: five
+// cron fields, a descriptor such as "@daily", or "@every <duration>".
+// k3s itself rejects anything subtler when it starts.
+func validateSnapshotCron(expr string) error {
+	if every, ok := strings.CutPrefix(expr, "@every "); ok {
+		if d, err := time.ParseDuration(every); err != nil || d <= 0 {
+			return fmt.Errorf("%q is not a positive duration", every)
+		}
+		return nil
+	}
+	if strings.HasPrefix(expr, "@") {
+		for _, d := range cronDescriptors {
+			if expr == d {
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown descriptor, want one of %s", strings.Join(cronDescriptors, ", "))
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return fmt.Errorf("want 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+	for _, f := range fields {
+		if !cronFieldPattern.MatchString(f) {
+			return fmt.Errorf("field %q has unexpected characters", f)
+		}
+	}
+	return nil
+}
+
+// dropIn returns the k3s config that points a server's snapshots at bucket.
+// k3s reaches the bucket with the instance role. With clusterInit, a single
+// server also runs embedded etcd, migrating its SQLite datastore in place the
+// next time k3s starts.
+func (s *Snapshots) dropIn(bucket pulumi.StringOutput, region string, clusterInit bool) pulumi.StringOutput {
+	init := ""
+	if clusterInit {
+		init = "cluster-init: true\n"
+	}
+	return pulumi.Sprintf(`%setcd-snapshot-schedule-cron: "%s"
+etcd-snapshot-retention: %d
+etcd-s3: true
+etcd-s3-bucket: %s
+etcd-s3-region: %s
+etcd-s3-folder: %s
+`, init, s.Cron, s.Retention, bucket, region, snapshotFolder)
+}
+
+// snapshotSyncScript writes dropIn to snapshotDropIn on a running server and
+// restarts k3s if it changed, so tuning the schedule doesn't touch the
+// instance.
+func snapshotSyncScript(dropIn pulumi.StringOutput) pulumi.StringOutput {
+	return pulumi.Sprintf(`set -eu
+mkdir -p %[1]s
+tmp=$(mktemp -p %[1]s)
+trap 'rm -f "$tmp"' EXIT
+cat > "$tmp" <<'EOF'
+%[3]sEOF
+if ! cmp -s "$tmp" %[2]s; then
+  mv "$tmp" %[2]s
+  chmod 600 %[2]s
+  if systemctl is-active --quiet k3s; then systemctl restart k3s; fi
+fi
+`, path.Dir(snapshotDropIn), snapshotDropIn, dropIn)
+}
+
+// createSnapshotAssociation keeps dropIn written on the servers named
+// servers with a State Manager association. Like the credential provider's,
+// it runs on one server at a time, so HA servers restart in turn, and stops
+// at the first failure.
+func createSnapshotAssociation(ctx *pulumi.Context, dropIn pulumi.StringOutput, servers pulumi.StringArray) error {
+	_, err := ssm.NewAssociation(ctx, "k3s-snapshots", &ssm.AssociationArgs{
+		AssociationName: pulumi.String("k3s-snapshots"),
+		Name:            pulumi.String("AWS-RunShellScript"),
+		Parameters: pulumi.StringMap{
+			"commands": snapshotSyncScript(dropIn),
+		},
+		Targets: ssm.AssociationTargetArray{
+			ssm.AssociationTargetArgs{
+				Key:    pulumi.String("tag:Name"),
+				Values: servers,
+			},
+		},
+		MaxConcurrency: pulumi.String("1"),
+		MaxErrors:      pulumi.String("0"),
+	})
+	return err
+}
+
+// checkDatastore refuses snapshots on a single server that already runs
+// SQLite, since starting it with embedded etcd migrates its datastore, unless
+// MigrateSQLite says that's intended. Servers running etcd carry
+// datastoreTag.
+func checkDatastore(ctx *pulumi.Context, s *Snapshots, ha HA) error {
+	if s == nil || ha.Enabled || s.MigrateSQLite {
+		return nil
+	}
+	sqlite, err := serversWithout(ctx, datastoreTag, "etcd")
+	if err != nil {
+		return err
+	}
+	if len(sqlite) > 0 {
+		return fmt.Errorf("snapshots: server %s runs SQLite and snapshots migrate it to embedded etcd; "+
+			"back up /var/lib/rancher/k3s/server/db and set snapshots.migrateSqlite to true", sqlite[0])
+	}
+	return nil
+}
+
+// installEnv returns the extra k3s install script environment: when
+// restoring, k3s is installed but not started until the snapshot is in place.
+func (s *Snapshots) installEnv() string {
+	if s == nil || s.Restore == "" {
+		return ""
+	}
+	return " INSTALL_K3S_SKIP_START=true"
+}
+
+// restore returns the user data that resets the new first server's etcd to
+// the Restore snapshot, fetched from bucket, and then starts k3s. The
+// snapshot must have been taken by a cluster with the same node token.
+func (s *Snapshots) restore(token, bucket pulumi.StringOutput, region string) pulumi.StringOutput {
+	if s == nil || s.Restore == "" {
+		return pulumi.String("").ToStringOutput()
+	}
+	return pulumi.Sprintf(`# Bootstrap the datastore from snapshot %[1]s before k3s first starts
+K3S_TOKEN=%[2]s k3s server --cluster-init --cluster-reset --cluster-reset-restore-path=%[1]s \
+  --etcd-s3 --etcd-s3-bucket=%[3]s --etcd-s3-region=%[4]s --etcd-s3-folder=%[5]s
+systemctl start k3s
+`, s.Restore, token, bucket, region, snapshotFolder)
+}
+
+// restored returns the Restore snapshot name, or "" without one.
+func (s *Snapshots) restored() string {
+	if s == nil {
+		return ""
+	}
+	return s.Restore
+}
+
+// createSnapshotBucket registers the versioned, encrypted, private bucket the
+// servers' snapshots go to and lets the instance role use it. Pruned
+// snapshots stay recoverable for noncurrentSnapshotDays. Destroying the stack
+// fails while the bucket holds snapshots, so they can't be lost by accident.
+func createSnapshotBucket(ctx *pulumi.Context, role *iam.Role) (*s3.BucketV2, error) {
+	bucket, err := s3.NewBucketV2(ctx, "k3s-snapshots", &s3.BucketV2Args{
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String("k3s-snapshots"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s3.NewBucketPublicAccessBlock(ctx, "k3s-snapshots", &s3.BucketPublicAccessBlockArgs{
+		Bucket:                bucket.ID(),
+		BlockPublicAcls:       pulumi.Bool(true),
+		BlockPublicPolicy:     pulumi.Bool(true),
+		IgnorePublicAcls:      pulumi.Bool(true),
+		RestrictPublicBuckets: pulumi.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s3.NewBucketVersioningV2(ctx, "k3s-snapshots", &s3.BucketVersioningV2Args{
+		Bucket: bucket.ID(),
+		VersioningConfiguration: &s3.BucketVersioningV2VersioningConfigurationArgs{
+			Status: pulumi.String("Enabled"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s3.NewBucketServerSideEncryptionConfigurationV2(ctx, "k3s-snapshots", &s3.BucketServerSideEncryptionConfigurationV2Args{
+		Bucket: bucket.ID(),
+		Rules: s3.BucketServerSideEncryptionConfigurationV2RuleArray{
+			&s3.BucketServerSideEncryptionConfigurationV2RuleArgs{
+				ApplyServerSideEncryptionByDefault: &s3.BucketServerSideEncryptionConfigurationV2RuleApplyServerSideEncryptionByDefaultArgs{
+					SseAlgorithm: pulumi.String("AES256"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s3.NewBucketLifecycleConfigurationV2(ctx, "k3s-snapshots", &s3.BucketLifecycleConfigurationV2Args{
+		Bucket: bucket.ID(),
+		Rules: s3.BucketLifecycleConfigurationV2RuleArray{
+			&s3.BucketLifecycleConfigurationV2RuleArgs{
+				Id:     pulumi.String("expire-pruned-snapshots"),
+				Status: pulumi.String("Enabled"),
+				Filter: &s3.BucketLifecycleConfigurationV2RuleFilterArgs{},
+				NoncurrentVersionExpiration: &s3.BucketLifecycleConfigurationV2RuleNoncurrentVersionExpirationArgs{
+					NoncurrentDays: pulumi.Int(noncurrentSnapshotDays),
+				},
+				AbortIncompleteMultipartUpload: &s3.BucketLifecycleConfigurationV2RuleAbortIncompleteMultipartUploadArgs{
+					DaysAfterInitiation: pulumi.Int(1),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s3.NewBucketPolicy(ctx, "k3s-snapshots", &s3.BucketPolicyArgs{
+		Bucket: bucket.ID(),
+		Policy: bucket.Arn.ApplyT(func(arn string) (string, error) {
+			return policyDocument(map[string]interface{}{
+				"Sid":       "DenyInsecureTransport",
+				"Effect":    "Deny",
+				"Principal": "*",
+				"Action":    "s3:*",
+				"Resource":  []string{arn, arn + "/*"},
+				"Condition": map[string]interface{}{
+					"Bool": map[string]string{"aws:SecureTransport": "false"},
+				},
+			})
+		}).(pulumi.StringOutput),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = iam.NewRolePolicy(ctx, "k3s-snapshots", &iam.RolePolicyArgs{
+		Role: role.Name,
+		Policy: bucket.Arn.ApplyT(func(arn string) (string, error) {
+			return policyDocument(map[string]interface{}{
+				"Effect":   "Allow",
+				"Action":   []string{"s3:ListBucket", "s3:GetBucketLocation"},
+				"Resource": arn,
+			}, map[string]interface{}{
+				"Effect":   "Allow",
+				"Action":   []string{"s3:GetObject", "s3:PutObject", "s3:DeleteObject"},
+				"Resource": arn + "/" + snapshotFolder + "/*",
+			})
+		}).(pulumi.StringOutput),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestSnapshotsValidate(t *testing.T) {
+	valid := []Snapshots{
+		{},
+		{Cron: "30 2 * * MON-FRI", Retention: 10},
+		{Cron: "@daily"},
+		{Cron: "@every 4h", Restore: "etcd-snapshot-ip-10-0-0-10-1760600000"},
+		{MigrateSQLite: true},
+	}
+	for _, s := range valid {
+		if errs := s.withDefaults().Validate(); len(errs) > 0 {
+			t.Errorf("%+v: unexpected errors %v", s, errs)
+		}
+	}
+
+	invalid := []struct {
+		snapshots Snapshots
+		want      string
+	}{
+		{Snapshots{Cron: "0 */6 * * * *"}, "want 5 fields"},
+		{Snapshots{Cron: "0 6 * * $"}, "unexpected characters"},
+		{Snapshots{Cron: "@fortnightly"}, "unknown descriptor"},
+		{Snapshots{Cron: "@every soon"}, "not a positive duration"},
+		{Snapshots{Retention: -1}, "retention -1"},
+		{Snapshots{Restore: "../other/snapshot"}, "is not a snapshot name"},
+	}
+	for _, c := range invalid {
+		errs := c.snapshots.withDefaults().Validate()
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), c.want) {
+			t.Errorf("%+v: expected an error mentioning %q, got %v", c.snapshots, c.want, errs)
+		}
+	}
+}
+
+// runSnapshots runs the whole platform program with the given config.
+func runSnapshots(t *testing.T, values map[string]string) *mocks {
+	t.Helper()
+	setConfig(t, values)
+	m := &mocks{}
+	if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// replacementTrigger returns the replacement trigger r was registered with,
+// or "" if none.
+func replacementTrigger(r pulumi.MockResourceArgs) string {
+	if r.RegisterRPC == nil {
+		return ""
+	}
+	return r.RegisterRPC.GetReplacementTrigger().GetStringValue()
+}
+
+func TestProgramWithoutSnapshots(t *testing.T) {
+	m := runSnapshots(t, nil)
+	if got := len(m.byType("aws:s3/bucketV2:BucketV2")); got != 0 {
+		t.Errorf("expected no snapshot bucket, got %d", got)
+	}
+	if _, ok := m.byType("aws:ssm/association:Association")["k3s-snapshots"]; ok {
+		t.Error("servers are configured for snapshots")
+	}
+	server := m.byType("aws:ec2/instance:Instance")["k3s-server-v6"]
+	userData := secretString(server.Inputs["userData"])
+	if strings.Contains(userData, "--cluster-init") || strings.Contains(userData, "INSTALL_K3S_SKIP_START") {
+		t.Errorf("server runs etcd without snapshots:\n%s", userData)
+	}
+	if trigger := replacementTrigger(server); !strings.HasSuffix(trigger, " restore=") {
+		t.Errorf("server replacement trigger %q", trigger)
+	}
+}
+
+func TestProgramSnapshots(t *testing.T) {
+	m := runSnapshots(t, map[string]string{"snapshots": `{"cron": "@hourly"}`})
+
+	if _, ok := m.byType("aws:s3/bucketV2:BucketV2")["k3s-snapshots"]; !ok {
+		t.Fatal("no snapshot bucket")
+	}
+	versioning := m.byType("aws:s3/bucketVersioningV2:BucketVersioningV2")["k3s-snapshots"]
+	if got := versioning.Inputs["versioningConfiguration"].ObjectValue()["status"].StringValue(); got != "Enabled" {
+		t.Errorf("bucket versioning %q", got)
+	}
+	encryption := m.byType("aws:s3/bucketServerSideEncryptionConfigurationV2:BucketServerSideEncryptionConfigurationV2")["k3s-snapshots"]
+	rule := encryption.Inputs["rules"].ArrayValue()[0].ObjectValue()
+	if got := rule["applyServerSideEncryptionByDefault"].ObjectValue()["sseAlgorithm"].StringValue(); got != "AES256" {
+		t.Errorf("bucket encryption %q", got)
+	}
+	block := m.byType("aws:s3/bucketPublicAccessBlock:BucketPublicAccessBlock")["k3s-snapshots"]
+	for _, key := range []string{"blockPublicAcls", "blockPublicPolicy", "ignorePublicAcls", "restrictPublicBuckets"} {
+		if !block.Inputs[resource.PropertyKey(key)].BoolValue() {
+			t.Errorf("bucket public access block: %s is off", key)
+		}
+	}
+
+	policy := m.byType("aws:iam/rolePolicy:RolePolicy")["k3s-snapshots"].Inputs["policy"].StringValue()
+	if !strings.Contains(policy, `"arn:aws:s3:::k3s-snapshots-bucket/k3s/*"`) {
+		t.Errorf("instance role policy is not scoped to the snapshot folder:\n%s", policy)
+	}
+
+	// The schedule reaches the running server through the association, not
+	// first-boot user data, and the single server migrates to etcd in place.
+	association, ok := m.byType("aws:ssm/association:Association")["k3s-snapshots"]
+	if !ok {
+		t.Fatal("no snapshot association")
+	}
+	script := association.Inputs["parameters"].ObjectValue()["commands"].StringValue()
+	for _, want := range []string{
+		snapshotDropIn,
+		"cluster-init: true\n",
+		`etcd-snapshot-schedule-cron: "@hourly"`,
+		"etcd-snapshot-retention: 28",
+		"etcd-s3-bucket: k3s-snapshots-bucket",
+		"etcd-s3-region: " + mockRegion,
+		"systemctl restart k3s",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("association script has no %q:\n%s", want, script)
+		}
+	}
+	target := association.Inputs["targets"].ArrayValue()[0].ObjectValue()
+	if got := stringList(target["values"]); strings.Join(got, ",") != "k3s-server-v6" {
+		t.Errorf("association targets %v", got)
+	}
+
+	server := m.byType("aws:ec2/instance:Instance")["k3s-server-v6"]
+	userData := secretString(server.Inputs["userData"])
+	for _, unwanted := range []string{"etcd-s3", "--cluster-init", "--cluster-reset"} {
+		if strings.Contains(userData, unwanted) {
+			t.Errorf("server user data has %q:\n%s", unwanted, userData)
+		}
+	}
+	if got := server.Inputs["tags"].ObjectValue()[datastoreTag].StringValue(); got != "etcd" {
+		t.Errorf("server %s tag %q", datastoreTag, got)
+	}
+	if got := replacementTrigger(server); !strings.HasSuffix(got, " restore=") {
+		t.Errorf("server replacement trigger %q", got)
+	}
+}
+
+func TestProgramSnapshotsOnSQLiteServer(t *testing.T) {
+	run := func(m *mocks, snapshots string) error {
+		setConfig(t, map[string]string{"snapshots": snapshots})
+		return pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	}
+
+	m := &mocks{servers: []string{"i-0123"}}
+	err := run(m, `{}`)
+	if err == nil || !strings.Contains(err.Error(), "i-0123 runs SQLite") || !strings.Contains(err.Error(), "snapshots.migrateSqlite") {
+		t.Errorf("expected a SQLite server error, got %v", err)
+	}
+	if got := len(m.all()); got != 0 {
+		t.Errorf("expected no resources before the datastore is checked, got %d", got)
+	}
+
+	if err := run(&mocks{servers: []string{"i-0123"}}, `{"migrateSqlite": true}`); err != nil {
+		t.Errorf("migrating: %v", err)
+	}
+	etcd := map[string][]string{datastoreTag: {"i-0123"}}
+	if err := run(&mocks{servers: []string{"i-0123"}, serverTags: etcd}, `{}`); err != nil {
+		t.Errorf("server on etcd: %v", err)
+	}
+}
+
+func TestProgramRestoreSnapshot(t *testing.T) {
+	m := runSnapshots(t, map[string]string{
+		"snapshots": `{"restore": "etcd-snapshot-ip-10-0-0-10-1760600000"}`,
+	})
+	server := m.byType("aws:ec2/instance:Instance")["k3s-server-v6"]
+	userData := secretString(server.Inputs["userData"])
+	for _, want := range []string{
+		"K3S_TOKEN=" + mockNodeToken + " INSTALL_K3S_SKIP_START=true sh -s -",
+		"k3s server --cluster-init --cluster-reset --cluster-reset-restore-path=etcd-snapshot-ip-10-0-0-10-1760600000 \\\n" +
+			"  --etcd-s3 --etcd-s3-bucket=k3s-snapshots-bucket --etcd-s3-region=" + mockRegion + " --etcd-s3-folder=k3s\n" +
+			"systemctl start k3s\n",
+	} {
+		if !strings.Contains(userData, want) {
+			t.Errorf("server user data has no %q:\n%s", want, userData)
+		}
+	}
+	if got := replacementTrigger(server); !strings.HasSuffix(got, " restore=etcd-snapshot-ip-10-0-0-10-1760600000") {
+		t.Errorf("server replacement trigger %q", got)
+	}
+}
+
+func TestProgramRestoreSnapshotHA(t *testing.T) {
+	m := runSnapshots(t, map[string]string{
+		"snapshots": `{"restore": "etcd-snapshot-ip-10-0-0-10-1760600000"}`,
+		"ha":        `{"enabled": true}`,
+	})
+	instances := m.byType("aws:ec2/instance:Instance")
+
+	first := secretString(instances["k3s-server-v6"].Inputs["userData"])
+	if !strings.Contains(first, "--cluster-reset-restore-path=etcd-snapshot-ip-10-0-0-10-1760600000") {
+		t.Errorf("first server does not restore the snapshot:\n%s", first)
+	}
+
+	// The joining servers are re-created along with the first, so they
+	// drop their old etcd membership and join the restored cluster.
+	for _, name := range []string{"k3s-server-v6", "k3s-server-1", "k3s-server-2"} {
+		if got := replacementTrigger(instances[name]); !strings.Contains(got, " restore=etcd-snapshot-ip-10-0-0-10-1760600000") {
+			t.Errorf("%s replacement trigger %q", name, got)
+		}
+	}
+	for _, name := range []string{"k3s-server-1", "k3s-server-2"} {
+		userData := secretString(instances[name].Inputs["userData"])
+		if !strings.Contains(userData, "--server https://"+mockPrivateIP+":6443") {
+			t.Errorf("%s does not join the first server:\n%s", name, userData)
+		}
+		if strings.Contains(userData, "--cluster-reset") {
+			t.Errorf("%s restores a snapshot instead of joining:\n%s", name, userData)
+		}
+	}
+
+	// Every server takes snapshots; the first already runs etcd.
+	association := m.byType("aws:ssm/association:Association")["k3s-snapshots"]
+	target := association.Inputs["targets"].ArrayValue()[0].ObjectValue()
+	if got := stringList(target["values"]); strings.Join(got, ",") != "k3s-server-v6,k3s-server-1,k3s-server-2" {
+		t.Errorf("association targets %v", got)
+	}
+	if script := association.Inputs["parameters"].ObjectValue()["commands"].StringValue(); strings.Contains(script, "cluster-init") {
+		t.Errorf("HA servers get cluster-init from the association:\n%s", script)
+	}
+}
+
+func TestProgramRefusesBadSnapshots(t *testing.T) {
+	setConfig(t, map[string]string{"snapshots": `{"cron": "every day"}`})
+	m := &mocks{}
+	err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err == nil || !strings.Contains(err.Error(), "invalid snapshots") {
+		t.Errorf("expected an invalid snapshots error, got %v", err)
+	}
+	if got := len(m.all()); got != 0 {
+		t.Errorf("expected no resources before snapshots are valid, got %d", got)
+	}
+}
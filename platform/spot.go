@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ssm"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// ephemeralLabel marks the nodes of Spot agents. They come and go with their
+// Auto Scaling group, so the spot handler runs on them and reaps their Node
+// objects once they stop reporting.
+const ephemeralLabel = "chikyn.io/ephemeral"
+
+// defaultSpotHandlerImage is published by the Build Spot Handler workflow
+// from tools/spot-handler.
+const defaultSpotHandlerImage = "ghcr.io/joshuamdhayes/teamchikynbitts/spot-handler:latest"
+
+// onDemandFallbackDocument switches an Auto Scaling group to on-demand.
+const onDemandFallbackDocument = `schemaVersion: "0.3"
+description: Switch a Spot agent group to on-demand after a Spot launch fails.
+assumeRole: "{{ AutomationAssumeRole }}"
+parameters:
+  AutoScalingGroupName:
+    type: String
+  AutomationAssumeRole:
+    type: String
+mainSteps:
+- name: onDemand
+  action: aws:executeAwsApi
+  inputs:
+    Service: autoscaling
+    Api: UpdateAutoScalingGroup
+    AutoScalingGroupName: "{{ AutoScalingGroupName }}"
+    MixedInstancesPolicy:
+      InstancesDistribution:
+        OnDemandPercentageAboveBaseCapacity: 100
+`
+
+// spotHandlerImage returns the spotHandlerImage config value or the default.
+func spotHandlerImage(cfg *config.Config) string {
+	if image := cfg.Get("spotHandlerImage"); image != "" {
+		return image
+	}
+	return defaultSpotHandlerImage
+}
+
+// createSpotAgents launches the agent pool as an Auto Scaling group of Spot
+// Instances, from base, the arguments shared with the server, across both
+// subnets and the pool's instance types. Agents are labelled ephemeral, and
+// scale-in waits up to two minutes so the spot handler can drain an agent, as
+// it does on an interruption notice. If a Spot launch fails, the group
+// switches itself to on-demand until the next daily revert. The instance
+// profile is passed by name, since base only holds it as an input, along with
+// its role.
+func createSpotAgents(ctx *pulumi.Context, a Agents, base ec2.InstanceArgs, role *iam.Role, profile pulumi.StringOutput, subnets pulumi.StringArrayOutput, server *ec2.Instance, token pulumi.StringOutput, tags map[string]string) (*autoscaling.Group, error) {
+	userData := agentUserData(server, token, fmt.Sprintf(" --node-label %s=true", ephemeralLabel))
+	instanceTags := pulumi.StringMap{"Name": pulumi.String("k3s-agent-spot")}
+	for key, value := range tags {
+		instanceTags[key] = pulumi.String(value)
+	}
+	template, err := ec2.NewLaunchTemplate(ctx, "k3s-agent-spot", &ec2.LaunchTemplateArgs{
+		ImageId: base.Ami,
+		KeyName: base.KeyName,
+		IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileArgs{
+			Name: profile,
+		},
+		NetworkInterfaces: ec2.LaunchTemplateNetworkInterfaceArray{
+			&ec2.LaunchTemplateNetworkInterfaceArgs{
+				AssociatePublicIpAddress: pulumi.String("true"),
+				SecurityGroups:           base.VpcSecurityGroupIds,
+			},
+		},
+		UserData: userData.ApplyT(func(script string) string {
+			return base64.StdEncoding.EncodeToString([]byte(script))
+		}).(pulumi.StringOutput),
+		UpdateDefaultVersion: pulumi.Bool(true),
+		// Instances launched by the group are tagged here, not by the stack's transformation.
+		TagSpecifications: ec2.LaunchTemplateTagSpecificationArray{
+			&ec2.LaunchTemplateTagSpecificationArgs{
+				ResourceType: pulumi.String("instance"),
+				Tags:         instanceTags,
+			},
+			&ec2.LaunchTemplateTagSpecificationArgs{
+				ResourceType: pulumi.String("volume"),
+				Tags:         instanceTags,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The group takes its tags as a list, not a map, so the stack's transformation skips it.
+	// They don't propagate: its instances and volumes are tagged by the template.
+	var groupTags autoscaling.GroupTagArray
+	for _, key := range sortedKeys(tags) {
+		groupTags = append(groupTags, &autoscaling.GroupTagArgs{
+			Key:               pulumi.String(key),
+			Value:             pulumi.String(tags[key]),
+			PropagateAtLaunch: pulumi.Bool(false),
+		})
+	}
+
+	var overrides autoscaling.GroupMixedInstancesPolicyLaunchTemplateOverrideArray
+	for _, instanceType := range append([]string{a.InstanceType}, a.SpotTypes...) {
+		overrides = append(overrides, &autoscaling.GroupMixedInstancesPolicyLaunchTemplateOverrideArgs{
+			InstanceType: pulumi.String(instanceType),
+		})
+	}
+	group, err := autoscaling.NewGroup(ctx, "k3s-agent-spot", &autoscaling.GroupArgs{
+		// No desired capacity: it starts at MinSize, and the schedule may empty the group.
+		MinSize:            pulumi.Int(a.Count),
+		MaxSize:            pulumi.Int(a.Count),
+		VpcZoneIdentifiers: subnets,
+		// Replace instances AWS says are at risk of interruption before they are interrupted.
+		CapacityRebalance: pulumi.Bool(true),
+		MixedInstancesPolicy: &autoscaling.GroupMixedInstancesPolicyArgs{
+			InstancesDistribution: &autoscaling.GroupMixedInstancesPolicyInstancesDistributionArgs{
+				OnDemandBaseCapacity:                pulumi.Int(0),
+				OnDemandPercentageAboveBaseCapacity: pulumi.Int(0),
+				SpotAllocationStrategy:              pulumi.String("price-capacity-optimized"),
+			},
+			LaunchTemplate: &autoscaling.GroupMixedInstancesPolicyLaunchTemplateArgs{
+				LaunchTemplateSpecification: &autoscaling.GroupMixedInstancesPolicyLaunchTemplateLaunchTemplateSpecificationArgs{
+					LaunchTemplateId: template.ID(),
+					Version:          pulumi.String("$Latest"),
+				},
+				Overrides: overrides,
+			},
+		},
+		Tags: groupTags,
+		InitialLifecycleHooks: autoscaling.GroupInitialLifecycleHookArray{
+			&autoscaling.GroupInitialLifecycleHookArgs{
+				Name:                pulumi.String("drain"),
+				LifecycleTransition: pulumi.String("autoscaling:EC2_INSTANCE_TERMINATING"),
+				HeartbeatTimeout:    pulumi.Int(120),
+				DefaultResult:       pulumi.String("CONTINUE"),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	launch := launchTarget{
+		template:       template,
+		subnets:        subnets,
+		securityGroups: base.VpcSecurityGroupIds.ToStringArrayOutput(),
+		role:           role,
+	}
+	if err := createOnDemandFallback(ctx, group, launch); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// spotRevertExpr is when, in UTC, a group that fell back to on-demand is put
+// back on Spot. If Spot is still short, the next failed launch falls back
+// again.
+const spotRevertExpr = "0 6 * * ? *"
+
+// launchTarget is what a Spot agent group launches from: its launch template,
+// the subnets and security groups the template's instances use, and the role
+// of their instance profile.
+type launchTarget struct {
+	template       *ec2.LaunchTemplate
+	subnets        pulumi.StringArrayOutput
+	securityGroups pulumi.StringArrayOutput
+	role           *iam.Role
+}
+
+// policy renders a policy that may update group and launch its instances
+// from l and nothing else. Auto Scaling checks that whoever changes a group's
+// mixed instances policy could launch from its launch template themselves.
+func (l launchTarget) policy(group *autoscaling.Group, region, accountID string) pulumi.StringOutput {
+	return pulumi.All(group.Arn, l.template.Arn, l.template.ImageId, l.subnets, l.securityGroups, l.role.Arn).ApplyT(func(args []interface{}) (string, error) {
+		ec2ARN := func(resource string) string {
+			return fmt.Sprintf("arn:aws:ec2:%s:%s:%s", region, accountID, resource)
+		}
+		template := args[1].(string)
+		image := "*"
+		if id, _ := args[2].(*string); id != nil {
+			image = *id
+		}
+		used := []string{template, fmt.Sprintf("arn:aws:ec2:%s::image/%s", region, image)}
+		for _, id := range args[3].([]string) {
+			used = append(used, ec2ARN("subnet/"+id))
+		}
+		for _, id := range args[4].([]string) {
+			used = append(used, ec2ARN("security-group/"+id))
+		}
+		launched := []string{ec2ARN("instance/*"), ec2ARN("volume/*"), ec2ARN("network-interface/*"), ec2ARN("key-pair/*")}
+		return policyDocument(map[string]interface{}{
+			"Effect":   "Allow",
+			"Action":   "autoscaling:UpdateAutoScalingGroup",
+			"Resource": args[0].(string),
+		}, map[string]interface{}{
+			"Effect":   "Allow",
+			"Action":   "ec2:RunInstances",
+			"Resource": used,
+		}, map[string]interface{}{
+			// Whatever the launch creates, only from the agents' launch template.
+			"Effect":   "Allow",
+			"Action":   "ec2:RunInstances",
+			"Resource": launched,
+			"Condition": map[string]interface{}{
+				"ArnEquals": map[string]string{"ec2:LaunchTemplate": template},
+			},
+		}, map[string]interface{}{
+			"Effect":   "Allow",
+			"Action":   "ec2:CreateTags",
+			"Resource": launched[:3],
+			"Condition": map[string]interface{}{
+				"StringEquals": map[string]string{"ec2:CreateAction": "RunInstances"},
+			},
+		}, map[string]interface{}{
+			"Effect":   "Allow",
+			"Action":   "iam:PassRole",
+			"Resource": args[5].(string),
+			"Condition": map[string]interface{}{
+				"StringEquals": map[string]string{"iam:PassedToService": "ec2.amazonaws.com"},
+			},
+		})
+	}).(pulumi.StringOutput)
+}
+
+// createOnDemandFallback runs onDemandFallbackDocument whenever group fails
+// to launch an instance from launch, so a shortage of Spot capacity doesn't
+// leave the cluster short of agents. A daily schedule puts the group back on
+// Spot at spotRevertExpr.
+func createOnDemandFallback(ctx *pulumi.Context, group *autoscaling.Group, launch launchTarget) error {
+	identity, err := aws.GetCallerIdentity(ctx, nil, nil)
+	if err != nil {
+		return err
+	}
+	region, err := aws.GetRegion(ctx, nil, nil)
+	if err != nil {
+		return err
+	}
+	policy := launch.policy(group, region.Name, identity.AccountId)
+
+	doc, err := ssm.NewDocument(ctx, "k3s-agent-on-demand", &ssm.DocumentArgs{
+		DocumentType:   pulumi.String("Automation"),
+		DocumentFormat: pulumi.String("YAML"),
+		Content:        pulumi.String(onDemandFallbackDocument),
+	})
+	if err != nil {
+		return err
+	}
+	automationRole, err := serviceRole(ctx, "k3s-agent-on-demand", "ssm.amazonaws.com", policy)
+	if err != nil {
+		return err
+	}
+
+	definition := pulumi.Sprintf("arn:aws:ssm:%s:%s:automation-definition/%s", region.Name, identity.AccountId, doc.Name)
+	eventsRole, err := serviceRole(ctx, "k3s-agent-launch-failed", "events.amazonaws.com",
+		pulumi.All(definition, automationRole.Arn).ApplyT(func(args []interface{}) (string, error) {
+			return policyDocument(map[string]interface{}{
+				"Effect":   "Allow",
+				"Action":   "ssm:StartAutomationExecution",
+				"Resource": args[0].(string) + ":*",
+			}, map[string]interface{}{
+				"Effect":   "Allow",
+				"Action":   "iam:PassRole",
+				"Resource": args[1].(string),
+			})
+		}).(pulumi.StringOutput))
+	if err != nil {
+		return err
+	}
+
+	rule, err := cloudwatch.NewEventRule(ctx, "k3s-agent-launch-failed", &cloudwatch.EventRuleArgs{
+		Description: pulumi.String("A Spot agent failed to launch"),
+		EventPattern: group.Name.ApplyT(func(name string) (string, error) {
+			pattern, err := json.Marshal(map[string]interface{}{
+				"source":      []string{"aws.autoscaling"},
+				"detail-type": []string{"EC2 Instance Launch Unsuccessful"},
+				"detail":      map[string][]string{"AutoScalingGroupName": {name}},
+			})
+			return string(pattern), err
+		}).(pulumi.StringOutput),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cloudwatch.NewEventTarget(ctx, "k3s-agent-launch-failed", &cloudwatch.EventTargetArgs{
+		Rule:    rule.Name,
+		Arn:     pulumi.Sprintf("%s:$DEFAULT", definition),
+		RoleArn: eventsRole.Arn,
+		Input: pulumi.All(group.Name, automationRole.Arn).ApplyT(func(args []interface{}) (string, error) {
+			input, err := json.Marshal(map[string][]string{
+				"AutoScalingGroupName": {args[0].(string)},
+				"AutomationAssumeRole": {args[1].(string)},
+			})
+			return string(input), err
+		}).(pulumi.StringOutput),
+	})
+	if err != nil {
+		return err
+	}
+
+	revertRole, err := serviceRole(ctx, "k3s-agent-spot-revert", "scheduler.amazonaws.com", policy)
+	if err != nil {
+		return err
+	}
+	input := group.Name.ApplyT(func(name string) (string, error) {
+		doc, err := json.Marshal(map[string]interface{}{
+			"AutoScalingGroupName": name,
+			"MixedInstancesPolicy": map[string]interface{}{
+				"InstancesDistribution": map[string]int{"OnDemandPercentageAboveBaseCapacity": 0},
+			},
+		})
+		return string(doc), err
+	}).(pulumi.StringOutput)
+	return newSchedule(ctx, "k3s-agent-spot-revert", Schedule{Timezone: defaultScheduleTimezone}, spotRevertExpr,
+		"autoscaling:updateAutoScalingGroup", revertRole, input)
+}
+
+// serviceRole registers a role service may assume, with an inline policy.
+func serviceRole(ctx *pulumi.Context, name, service string, policy pulumi.StringOutput) (*iam.Role, error) {
+	role, err := iam.NewRole(ctx, name, &iam.RoleArgs{
+		AssumeRolePolicy: pulumi.String(fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Action": "sts:AssumeRole",
+				"Effect": "Allow",
+				"Principal": {
+					"Service": "%s"
+				}
+			}]
+		}`, service)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = iam.NewRolePolicy(ctx, name, &iam.RolePolicyArgs{
+		Role:   role.Name,
+		Policy: policy,
+	})
+	return role, err
+}
+
+// policyDocument renders an IAM policy with the given statements.
+func policyDocument(statements ...map[string]interface{}) (string, error) {
+	doc, err := json.Marshal(map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": statements,
+	})
+	return string(doc), err
+}
+
+// spotHandlerYAML renders the spot handler: a DaemonSet on the Spot agents
+// that cordons and drains its node on an interruption notice or scale-in,
+// read from IMDSv2 on the host network, and reaps the nodes of agents that
+// are gone.
+func spotHandlerYAML(image string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: spot-handler
+  namespace: kube-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: spot-handler
+rules:
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get", "list", "patch", "delete"]
+- apiGroups: [""]
+  resources: ["pods"]
+  verbs: ["list"]
+- apiGroups: [""]
+  resources: ["pods/eviction"]
+  verbs: ["create"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: spot-handler
+subjects:
+- kind: ServiceAccount
+  name: spot-handler
+  namespace: kube-system
+roleRef:
+  kind: ClusterRole
+  name: spot-handler
+  apiGroup: rbac.authorization.k8s.io
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: spot-handler
+  namespace: kube-system
+spec:
+  selector:
+    matchLabels:
+      app: spot-handler
+  template:
+    metadata:
+      labels:
+        app: spot-handler
+    spec:
+      serviceAccountName: spot-handler
+      priorityClassName: system-node-critical
+      hostNetwork: true
+      dnsPolicy: ClusterFirstWithHostNet
+      nodeSelector:
+        %s: "true"
+      tolerations:
+      - operator: Exists
+      containers:
+      - name: handler
+        image: %s
+        env:
+        - name: NODE_NAME
+          valueFrom:
+            fieldRef:
+              fieldPath: spec.nodeName
+        resources:
+          requests:
+            cpu: 10m
+            memory: 32Mi
+          limits:
+            memory: 64Mi
+        securityContext:
+          allowPrivilegeEscalation: false
+          readOnlyRootFilesystem: true
+          runAsNonRoot: true
+`, ephemeralLabel, image)
+}
+
+// createSpotHandler deploys the spot handler.
+func createSpotHandler(ctx *pulumi.Context, image string, provider *kubernetes.Provider) error {
+	_, err := yaml.NewConfigGroup(ctx, "spot-handler", &yaml.ConfigGroupArgs{
+		YAML: []string{spotHandlerYAML(image)},
+	}, pulumi.Provider(provider))
+	return err
+}
+
+// sortedKeys returns the keys of m in order, so tag lists built from it are stable.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"teamchikynbitts-tagging"
+)
+
+func TestProgramSpotAgents(t *testing.T) {
+	setConfig(t, map[string]string{
+		"agents":   `{"count": 2, "spot": true, "spotTypes": ["t3a.small"]}`,
+		"schedule": `{}`,
+	})
+	m := &mocks{}
+	if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.byType("aws:ec2/instance:Instance")); got != 1 {
+		t.Errorf("expected only the server as an instance, got %d", got)
+	}
+
+	group, ok := m.byType("aws:autoscaling/group:Group")["k3s-agent-spot"]
+	if !ok {
+		t.Fatal("no Spot agent group")
+	}
+	if min, max := group.Inputs["minSize"].NumberValue(), group.Inputs["maxSize"].NumberValue(); min != 2 || max != 2 {
+		t.Errorf("group size %v-%v, want 2", min, max)
+	}
+	if got := stringList(group.Inputs["vpcZoneIdentifiers"]); strings.Join(got, ",") != "subnet-a,subnet-b" {
+		t.Errorf("group subnets %v", got)
+	}
+	policy := group.Inputs["mixedInstancesPolicy"].ObjectValue()
+	distribution := policy["instancesDistribution"].ObjectValue()
+	if got := distribution["onDemandPercentageAboveBaseCapacity"].NumberValue(); got != 0 {
+		t.Errorf("on-demand percentage %v, want 0", got)
+	}
+	var types []string
+	for _, o := range policy["launchTemplate"].ObjectValue()["overrides"].ArrayValue() {
+		types = append(types, o.ObjectValue()["instanceType"].StringValue())
+	}
+	if strings.Join(types, ",") != "t3.small,t3a.small" {
+		t.Errorf("instance types %v", types)
+	}
+	hooks := group.Inputs["initialLifecycleHooks"].ArrayValue()
+	if len(hooks) != 1 || hooks[0].ObjectValue()["lifecycleTransition"].StringValue() != "autoscaling:EC2_INSTANCE_TERMINATING" {
+		t.Errorf("expected a termination hook to drain on scale-in, got %v", hooks)
+	}
+
+	template := m.byType("aws:ec2/launchTemplate:LaunchTemplate")["k3s-agent-spot"]
+	if !template.Inputs["userData"].IsSecret() {
+		t.Error("launch template user data holding the node token is not secret")
+	}
+	userData, err := base64.StdEncoding.DecodeString(secretString(template.Inputs["userData"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(userData), "K3S_TOKEN="+mockNodeToken+" sh -s - agent --node-label chikyn.io/ephemeral=true") {
+		t.Errorf("Spot agents are not labelled ephemeral:\n%s", userData)
+	}
+	var tagged []string
+	for _, spec := range template.Inputs["tagSpecifications"].ArrayValue() {
+		tags := spec.ObjectValue()["tags"].ObjectValue()
+		tagged = append(tagged, spec.ObjectValue()["resourceType"].StringValue())
+		if tags["Name"].StringValue() != "k3s-agent-spot" || tags[tagging.KeyOwner].StringValue() != "platform-engineers" {
+			t.Errorf("%s tags %v", spec.ObjectValue()["resourceType"].StringValue(), tags)
+		}
+	}
+	if strings.Join(tagged, ",") != "instance,volume" {
+		t.Errorf("launch template tags %v, want instances and volumes", tagged)
+	}
+	groupTags := map[string]string{}
+	for _, tag := range group.Inputs["tags"].ArrayValue() {
+		tag := tag.ObjectValue()
+		groupTags[tag["key"].StringValue()] = tag["value"].StringValue()
+		if tag["propagateAtLaunch"].BoolValue() {
+			t.Errorf("group propagates %s over the launch template's tags", tag["key"].StringValue())
+		}
+	}
+	for _, key := range tagging.Keys {
+		if groupTags[key] == "" {
+			t.Errorf("group is not tagged with %s: %v", key, groupTags)
+		}
+	}
+
+	rule := m.byType("aws:cloudwatch/eventRule:EventRule")["k3s-agent-launch-failed"]
+	var pattern struct {
+		DetailType []string `json:"detail-type"`
+		Detail     struct{ AutoScalingGroupName []string }
+	}
+	if err := json.Unmarshal([]byte(rule.Inputs["eventPattern"].StringValue()), &pattern); err != nil {
+		t.Fatal(err)
+	}
+	if pattern.DetailType[0] != "EC2 Instance Launch Unsuccessful" || pattern.Detail.AutoScalingGroupName[0] != "k3s-agent-spot" {
+		t.Errorf("unexpected event pattern %+v", pattern)
+	}
+	target := m.byType("aws:cloudwatch/eventTarget:EventTarget")["k3s-agent-launch-failed"]
+	want := "arn:aws:ssm:" + mockRegion + ":" + mockAccountID + ":automation-definition/k3s-agent-on-demand:$DEFAULT"
+	if got := target.Inputs["arn"].StringValue(); got != want {
+		t.Errorf("fallback target %q, want %q", got, want)
+	}
+	if !strings.Contains(target.Inputs["input"].StringValue(), `"AutoScalingGroupName":["k3s-agent-spot"]`) {
+		t.Errorf("fallback input %s", target.Inputs["input"].StringValue())
+	}
+
+	// The fallback and its revert may only launch from the agents' template.
+	policies := m.byType("aws:iam/rolePolicy:RolePolicy")
+	for _, name := range []string{"k3s-agent-on-demand", "k3s-agent-spot-revert"} {
+		var policy struct {
+			Statement []struct {
+				Action    string
+				Resource  interface{}
+				Condition map[string]map[string]string
+			}
+		}
+		if err := json.Unmarshal([]byte(policies[name].Inputs["policy"].StringValue()), &policy); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		templateARN := "arn:aws:ec2:" + mockRegion + ":" + mockAccountID + ":launch-template/k3s-agent-spot_id"
+		actions := map[string]bool{}
+		for _, s := range policy.Statement {
+			actions[s.Action] = true
+			if s.Resource == "*" {
+				t.Errorf("%s: %s on every resource", name, s.Action)
+			}
+			switch s.Action {
+			case "iam:PassRole":
+				if s.Resource != "arn:aws:iam::"+mockAccountID+":role/k3s-role" || s.Condition["StringEquals"]["iam:PassedToService"] != "ec2.amazonaws.com" {
+					t.Errorf("%s: passes %v %v", name, s.Resource, s.Condition)
+				}
+			case "ec2:RunInstances":
+				resources, _ := json.Marshal(s.Resource)
+				if s.Condition == nil {
+					for _, want := range []string{templateARN, ":subnet/subnet-a", ":subnet/subnet-b", ":security-group/k3s-sg_id"} {
+						if !strings.Contains(string(resources), want) {
+							t.Errorf("%s: launches without %s: %s", name, want, resources)
+						}
+					}
+				} else if s.Condition["ArnEquals"]["ec2:LaunchTemplate"] != templateARN {
+					t.Errorf("%s: launches %s from %v", name, resources, s.Condition)
+				}
+			case "ec2:CreateTags":
+				if s.Condition["StringEquals"]["ec2:CreateAction"] != "RunInstances" {
+					t.Errorf("%s: tags outside a launch: %v", name, s.Condition)
+				}
+			}
+		}
+		for _, want := range []string{"autoscaling:UpdateAutoScalingGroup", "ec2:RunInstances", "ec2:CreateTags", "iam:PassRole"} {
+			if !actions[want] {
+				t.Errorf("%s: no %s", name, want)
+			}
+		}
+	}
+
+	revert := m.byType("aws:scheduler/schedule:Schedule")["k3s-agent-spot-revert"].Inputs["target"].ObjectValue()
+	if got := revert["input"].StringValue(); got != `{"AutoScalingGroupName":"k3s-agent-spot","MixedInstancesPolicy":{"InstancesDistribution":{"OnDemandPercentageAboveBaseCapacity":0}}}` {
+		t.Errorf("revert input %s", got)
+	}
+
+	daemonSets := m.byType("kubernetes:apps/v1:DaemonSet")
+	if len(daemonSets) != 1 {
+		t.Fatalf("expected the spot handler DaemonSet, got %d", len(daemonSets))
+	}
+	for _, ds := range daemonSets {
+		pod := ds.Inputs["spec"].ObjectValue()["template"].ObjectValue()["spec"].ObjectValue()
+		if got := pod["containers"].ArrayValue()[0].ObjectValue()["image"].StringValue(); got != defaultSpotHandlerImage {
+			t.Errorf("handler image %q", got)
+		}
+		if got := pod["nodeSelector"].ObjectValue()[ephemeralLabel].StringValue(); got != "true" {
+			t.Errorf("handler runs on %v", pod["nodeSelector"])
+		}
+	}
+
+	schedules := m.byType("aws:scheduler/schedule:Schedule")
+	if got := schedules["k3s-stop"].Inputs["target"].ObjectValue()["input"].StringValue(); got != `{"InstanceIds":["k3s-server-v6_id"]}` {
+		t.Errorf("schedule stops %s", got)
+	}
+	for name, want := range map[string]string{
+		"k3s-agent-spot-start": `{"AutoScalingGroupName":"k3s-agent-spot","DesiredCapacity":2,"MinSize":2}`,
+		"k3s-agent-spot-stop":  `{"AutoScalingGroupName":"k3s-agent-spot","DesiredCapacity":0,"MinSize":0}`,
+	} {
+		target := schedules[name].Inputs["target"].ObjectValue()
+		if got := target["arn"].StringValue(); got != "arn:aws:scheduler:::aws-sdk:autoscaling:updateAutoScalingGroup" {
+			t.Errorf("%s: target %q", name, got)
+		}
+		if got := target["input"].StringValue(); got != want {
+			t.Errorf("%s: input %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestProgramOnDemandAgentsHaveNoHandler(t *testing.T) {
+	setConfig(t, map[string]string{"agents": `{"count": 1}`, "spotHandlerImage": "example.com/spot-handler:1"})
+	m := &mocks{}
+	if err := pulumi.RunErr(program, pulumi.WithMocks("teamchikynbitts-platform", "dev", m)); err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"aws:autoscaling/group:Group", "aws:cloudwatch/eventRule:EventRule", "kubernetes:apps/v1:DaemonSet"} {
+		if got := len(m.byType(typ)); got != 0 {
+			t.Errorf("on-demand agents created %d %s", got, typ)
+		}
+	}
+}
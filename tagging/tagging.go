@@ -0,0 +1,124 @@
+// Package tagging applies the cost-allocation tags shared by the foundation
+// and platform stacks, so Cost Explorer and tag budgets can split spend by
+// stack.
+package tagging
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// Tag keys set on every taggable resource.
+const (
+	KeyProject   = "Project"
+	KeyStack     = "Stack"
+	KeyOwner     = "Owner"
+	KeyManagedBy = "ManagedBy"
+)
+
+// Keys lists every tag key, in the order they are activated for cost
+// allocation.
+var Keys = []string{KeyProject, KeyStack, KeyOwner, KeyManagedBy}
+
+// project is the prefix of every Pulumi project in the repository; what
+// follows it is the stack's Stack tag ("teamchikynbitts-platform" is
+// "platform").
+const project = "teamchikynbitts"
+
+// tagFields are the resource arguments tags are merged into. VolumeTags
+// carries them to an instance's EBS volumes.
+var tagFields = []string{"Tags", "VolumeTags"}
+
+var stringMapInput = reflect.TypeOf((*pulumi.StringMapInput)(nil)).Elem()
+
+// Tags returns the tags for ctx's stack. Owner is the "owner" stack config
+// value, or defaultOwner if unset.
+func Tags(ctx *pulumi.Context, defaultOwner string) map[string]string {
+	owner := config.New(ctx, "").Get("owner")
+	if owner == "" {
+		owner = defaultOwner
+	}
+	return map[string]string{
+		KeyProject:   project,
+		KeyStack:     strings.TrimPrefix(ctx.Project(), project+"-"),
+		KeyOwner:     owner,
+		KeyManagedBy: "pulumi",
+	}
+}
+
+// Register adds a stack transformation that merges tags into every resource
+// registered afterwards that takes tags. The resource's own tags are kept, but
+// the shared keys always win, so a hard-coded ManagedBy or Owner can't split
+// cost reports.
+func Register(ctx *pulumi.Context, tags map[string]string) error {
+	return ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		props, ok := withTags(args.Props, tags)
+		if !ok {
+			return nil
+		}
+		return &pulumi.ResourceTransformationResult{Props: props, Opts: args.Opts}
+	})
+}
+
+// Taggable reports whether resource arguments take tags.
+func Taggable(props pulumi.Input) bool {
+	v := reflect.ValueOf(props)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	field := v.Elem().FieldByName("Tags")
+	return field.IsValid() && field.Type() == stringMapInput
+}
+
+// withTags returns a copy of props with tags merged into its tag fields, or
+// false if the resource takes no tags.
+func withTags(props pulumi.Input, tags map[string]string) (pulumi.Input, bool) {
+	if !Taggable(props) {
+		return nil, false
+	}
+	v := reflect.ValueOf(props).Elem()
+	copied := reflect.New(v.Type())
+	copied.Elem().Set(v)
+	for _, name := range tagFields {
+		field := copied.Elem().FieldByName(name)
+		if !field.IsValid() || field.Type() != stringMapInput {
+			continue
+		}
+		var existing pulumi.StringMapInput
+		if !field.IsNil() {
+			existing = field.Interface().(pulumi.StringMapInput)
+		}
+		field.Set(reflect.ValueOf(merge(existing, tags)))
+	}
+	return copied.Interface().(pulumi.Input), true
+}
+
+// merge returns existing overlaid by tags.
+func merge(existing pulumi.StringMapInput, tags map[string]string) pulumi.StringMapInput {
+	merged := pulumi.StringMap{}
+	switch existing := existing.(type) {
+	case nil:
+	case pulumi.StringMap:
+		for key, value := range existing {
+			merged[key] = value
+		}
+	default:
+		return existing.ToStringMapOutput().ApplyT(func(set map[string]string) map[string]string {
+			out := make(map[string]string, len(tags)+len(set))
+			for key, value := range set {
+				out[key] = value
+			}
+			for key, value := range tags {
+				out[key] = value
+			}
+			return out
+		}).(pulumi.StringMapOutput)
+	}
+	for key, value := range tags {
+		merged[key] = pulumi.String(value)
+	}
+	return merged
+}
@@ -0,0 +1,136 @@
+package tagging
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type mocks struct {
+	mu     sync.Mutex
+	inputs map[string]resource.PropertyMap
+}
+
+func (m *mocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.inputs == nil {
+		m.inputs = map[string]resource.PropertyMap{}
+	}
+	m.inputs[args.Name] = args.Inputs
+	return args.Name + "_id", args.Inputs, nil
+}
+
+func (m *mocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	return args.Args, nil
+}
+
+// bucket and key stand in for a provider resource that takes tags and one
+// that doesn't.
+type bucket struct {
+	pulumi.CustomResourceState
+	Tags pulumi.StringMapOutput `pulumi:"tags"`
+}
+
+type bucketArgs struct {
+	Tags       pulumi.StringMapInput
+	VolumeTags pulumi.StringMapInput
+}
+
+type bucketState struct {
+	Tags       map[string]string `pulumi:"tags"`
+	VolumeTags map[string]string `pulumi:"volumeTags"`
+}
+
+func (bucketArgs) ElementType() reflect.Type { return reflect.TypeOf((*bucketState)(nil)).Elem() }
+
+type key struct {
+	pulumi.CustomResourceState
+}
+
+type keyArgs struct {
+	Name pulumi.StringInput
+}
+
+type keyState struct {
+	Name string `pulumi:"name"`
+}
+
+func (keyArgs) ElementType() reflect.Type { return reflect.TypeOf((*keyState)(nil)).Elem() }
+
+func TestRegister(t *testing.T) {
+	t.Setenv("PULUMI_CONFIG", `{"teamchikynbitts-platform:owner": "platform-engineers"}`)
+	m := &mocks{}
+	err := pulumi.RunErr(func(ctx *pulumi.Context) error {
+		tags := Tags(ctx, "nobody")
+		if err := Register(ctx, tags); err != nil {
+			return err
+		}
+		var b bucket
+		if err := ctx.RegisterResource("test:index:Bucket", "plain", &bucketArgs{}, &b); err != nil {
+			return err
+		}
+		if err := ctx.RegisterResource("test:index:Bucket", "named", &bucketArgs{
+			Tags: pulumi.StringMap{"Name": pulumi.String("named"), KeyOwner: pulumi.String("someone-else")},
+		}, &b); err != nil {
+			return err
+		}
+		if err := ctx.RegisterResource("test:index:Bucket", "output", &bucketArgs{
+			Tags: pulumi.StringMap{"Name": pulumi.String("output"), KeyManagedBy: pulumi.String("Pulumi")}.ToStringMapOutput(),
+		}, &b); err != nil {
+			return err
+		}
+		var k key
+		return ctx.RegisterResource("test:index:Key", "key", &keyArgs{Name: pulumi.String("key")}, &k)
+	}, pulumi.WithMocks("teamchikynbitts-platform", "dev", m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]map[string]string{
+		"plain":  {},
+		"named":  {"Name": "named"},
+		"output": {"Name": "output"},
+	}
+	for name, extra := range want {
+		shared := map[string]string{
+			KeyProject:   "teamchikynbitts",
+			KeyStack:     "platform",
+			KeyOwner:     "platform-engineers",
+			KeyManagedBy: "pulumi",
+		}
+		// The shared keys win over the resource's own.
+		expected := map[string]string{}
+		for _, set := range []map[string]string{extra, shared} {
+			for k, v := range set {
+				expected[k] = v
+			}
+		}
+		for field, want := range map[resource.PropertyKey]map[string]string{"tags": expected, "volumeTags": shared} {
+			got := map[string]string{}
+			if v, ok := m.inputs[name][field]; ok {
+				for k, v := range v.ObjectValue() {
+					got[string(k)] = v.StringValue()
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %s: got %v, want %v", name, field, got, want)
+			}
+		}
+	}
+	if _, ok := m.inputs["key"]["tags"]; ok {
+		t.Error("resource without tags was tagged")
+	}
+}
+
+func TestTaggable(t *testing.T) {
+	if !Taggable(&bucketArgs{}) {
+		t.Error("bucketArgs should be taggable")
+	}
+	if Taggable(&keyArgs{}) || Taggable(nil) || Taggable(pulumi.String("x")) {
+		t.Error("only arguments with Tags should be taggable")
+	}
+}
@@ -0,0 +1,242 @@
+// Package findings reads the ECR scan findings of an image and checks them
+// against a severity policy, so a build can refuse to ship a vulnerable
+// image. Both basic (ScanOnPush) and enhanced (Inspector) scanning results
+// are understood.
+package findings
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// Severity orders the ECR finding severities.
+type Severity int
+
+const (
+	Undefined Severity = iota
+	Informational
+	Low
+	Medium
+	High
+	Critical
+)
+
+var severityNames = []string{"UNDEFINED", "INFORMATIONAL", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// ParseSeverity parses a severity name, in any case.
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if strings.EqualFold(name, n) {
+			return Severity(i), nil
+		}
+	}
+	return Undefined, fmt.Errorf("unknown severity %q (want one of %s)", name, strings.Join(severityNames[1:], ", "))
+}
+
+// Finding is one vulnerability in the image.
+type Finding struct {
+	ID          string
+	Severity    Severity
+	Package     string
+	Version     string
+	FixedIn     string
+	URI         string
+	Description string
+}
+
+// Report holds the findings of one image, most severe first.
+type Report struct {
+	Repository string
+	Tag        string
+	Findings   []Finding
+}
+
+// Image returns "repository:tag", or "repository@digest" for a digest.
+func (r Report) Image() string {
+	if strings.HasPrefix(r.Tag, "sha256:") {
+		return r.Repository + "@" + r.Tag
+	}
+	return r.Repository + ":" + r.Tag
+}
+
+// Policy fails an image with any finding at or above Threshold, except the
+// finding IDs (e.g. "CVE-2024-1234") in Ignore.
+type Policy struct {
+	Threshold Severity
+	Ignore    []string
+}
+
+// Violates reports whether the finding fails the policy.
+func (p Policy) Violates(f Finding) bool {
+	if f.Severity < p.Threshold {
+		return false
+	}
+	for _, id := range p.Ignore {
+		if strings.EqualFold(id, f.ID) {
+			return false
+		}
+	}
+	return true
+}
+
+// Violations returns the findings that fail the policy.
+func (p Policy) Violations(r Report) []Finding {
+	var failed []Finding
+	for _, f := range r.Findings {
+		if p.Violates(f) {
+			failed = append(failed, f)
+		}
+	}
+	return failed
+}
+
+// defaultPoll is how often Scanner checks a scan that hasn't finished.
+const defaultPoll = 5 * time.Second
+
+// Scanner fetches scan findings through the ECR API.
+type Scanner struct {
+	ECR ecr.DescribeImageScanFindingsAPIClient
+	// Poll is the wait between checks while the scan is in progress
+	// (default 5s). The context bounds the total wait.
+	Poll time.Duration
+}
+
+// Fetch returns the findings for repository at tag (or "sha256:" digest),
+// waiting for a scan in progress to finish.
+func (s Scanner) Fetch(ctx context.Context, repository, tag string) (Report, error) {
+	report := Report{Repository: repository, Tag: tag}
+	input := &ecr.DescribeImageScanFindingsInput{
+		RepositoryName: aws.String(repository),
+		ImageId:        imageID(tag),
+	}
+
+	first, err := s.waitForScan(ctx, report.Image(), input)
+	if err != nil {
+		return report, err
+	}
+	report.Findings = append(report.Findings, convert(first)...)
+	for token := first.NextToken; token != nil; {
+		next := *input
+		next.NextToken = token
+		page, err := s.ECR.DescribeImageScanFindings(ctx, &next)
+		if err != nil {
+			return report, fmt.Errorf("reading findings for %s: %w", report.Image(), err)
+		}
+		report.Findings = append(report.Findings, convert(page)...)
+		token = page.NextToken
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		return a.ID < b.ID
+	})
+	return report, nil
+}
+
+// waitForScan returns the first page of findings once the scan is complete.
+func (s Scanner) waitForScan(ctx context.Context, image string, input *ecr.DescribeImageScanFindingsInput) (*ecr.DescribeImageScanFindingsOutput, error) {
+	poll := s.Poll
+	if poll <= 0 {
+		poll = defaultPoll
+	}
+	for {
+		page, err := s.ECR.DescribeImageScanFindings(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("reading findings for %s: %w", image, err)
+		}
+		var status types.ScanStatus
+		var description string
+		if page.ImageScanStatus != nil {
+			status = page.ImageScanStatus.Status
+			description = aws.ToString(page.ImageScanStatus.Description)
+		}
+		switch status {
+		case types.ScanStatusComplete, types.ScanStatusActive:
+			return page, nil
+		case types.ScanStatusInProgress, types.ScanStatusPending:
+		default:
+			if description != "" {
+				return nil, fmt.Errorf("scan of %s is %s: %s", image, status, description)
+			}
+			return nil, fmt.Errorf("scan of %s is %s", image, status)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for the scan of %s: %w", image, ctx.Err())
+		case <-time.After(poll):
+		}
+	}
+}
+
+// imageID identifies an image by tag or digest.
+func imageID(tag string) *types.ImageIdentifier {
+	if strings.HasPrefix(tag, "sha256:") {
+		return &types.ImageIdentifier{ImageDigest: aws.String(tag)}
+	}
+	return &types.ImageIdentifier{ImageTag: aws.String(tag)}
+}
+
+// convert turns one page of basic and enhanced findings into Findings.
+func convert(page *ecr.DescribeImageScanFindingsOutput) []Finding {
+	if page.ImageScanFindings == nil {
+		return nil
+	}
+	var found []Finding
+	for _, f := range page.ImageScanFindings.Findings {
+		severity, _ := ParseSeverity(string(f.Severity))
+		finding := Finding{
+			ID:          aws.ToString(f.Name),
+			Severity:    severity,
+			URI:         aws.ToString(f.Uri),
+			Description: aws.ToString(f.Description),
+		}
+		for _, attr := range f.Attributes {
+			switch aws.ToString(attr.Key) {
+			case "package_name":
+				finding.Package = aws.ToString(attr.Value)
+			case "package_version":
+				finding.Version = aws.ToString(attr.Value)
+			}
+		}
+		found = append(found, finding)
+	}
+	for _, f := range page.ImageScanFindings.EnhancedFindings {
+		severity, _ := ParseSeverity(aws.ToString(f.Severity))
+		finding := Finding{
+			ID:          aws.ToString(f.Title),
+			Severity:    severity,
+			Description: aws.ToString(f.Description),
+		}
+		if d := f.PackageVulnerabilityDetails; d != nil {
+			if id := aws.ToString(d.VulnerabilityId); id != "" {
+				finding.ID = id
+			}
+			finding.URI = aws.ToString(d.SourceUrl)
+			if len(d.VulnerablePackages) > 0 {
+				p := d.VulnerablePackages[0]
+				finding.Package = aws.ToString(p.Name)
+				finding.Version = aws.ToString(p.Version)
+				finding.FixedIn = aws.ToString(p.FixedInVersion)
+			}
+		}
+		found = append(found, finding)
+	}
+	return found
+}
@@ -0,0 +1,246 @@
+package findings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// fakeECR serves DescribeImageScanFindings from canned pages. statuses, if
+// set, are returned (without findings) before the pages, one per call.
+type fakeECR struct {
+	statuses []types.ScanStatus
+	pages    []*ecr.DescribeImageScanFindingsOutput
+	inputs   []*ecr.DescribeImageScanFindingsInput
+	err      error
+}
+
+func (f *fakeECR) DescribeImageScanFindings(_ context.Context, in *ecr.DescribeImageScanFindingsInput, _ ...func(*ecr.Options)) (*ecr.DescribeImageScanFindingsOutput, error) {
+	f.inputs = append(f.inputs, in)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		return &ecr.DescribeImageScanFindingsOutput{ImageScanStatus: &types.ImageScanStatus{Status: status}}, nil
+	}
+	page := 0
+	if in.NextToken != nil {
+		page = len(aws.ToString(in.NextToken))
+	}
+	return f.pages[page], nil
+}
+
+func complete(next string, findings ...types.ImageScanFinding) *ecr.DescribeImageScanFindingsOutput {
+	out := &ecr.DescribeImageScanFindingsOutput{
+		ImageScanStatus:   &types.ImageScanStatus{Status: types.ScanStatusComplete},
+		ImageScanFindings: &types.ImageScanFindings{Findings: findings},
+	}
+	if next != "" {
+		out.NextToken = aws.String(next)
+	}
+	return out
+}
+
+func basic(id string, severity types.FindingSeverity, pkg, version string) types.ImageScanFinding {
+	return types.ImageScanFinding{
+		Name:     aws.String(id),
+		Severity: severity,
+		Uri:      aws.String("https://security-tracker.debian.org/tracker/" + id),
+		Attributes: []types.Attribute{
+			{Key: aws.String("package_name"), Value: aws.String(pkg)},
+			{Key: aws.String("package_version"), Value: aws.String(version)},
+		},
+	}
+}
+
+func TestFetchPagesAndSorts(t *testing.T) {
+	// NextToken "x" leads to page 1 (the fake indexes pages by token length).
+	fake := &fakeECR{pages: []*ecr.DescribeImageScanFindingsOutput{
+		complete("x", basic("CVE-2024-0002", types.FindingSeverityMedium, "zlib", "1.2.13")),
+		complete("", basic("CVE-2024-0001", types.FindingSeverityCritical, "openssl", "3.0.2")),
+	}}
+	report, err := Scanner{ECR: fake}.Fetch(context.Background(), "josh-app", "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Findings) != 2 || report.Findings[0].ID != "CVE-2024-0001" || report.Findings[0].Severity != Critical {
+		t.Fatalf("findings not read or sorted: %+v", report.Findings)
+	}
+	if f := report.Findings[0]; f.Package != "openssl" || f.Version != "3.0.2" {
+		t.Errorf("package attributes not read: %+v", f)
+	}
+	if got := aws.ToString(fake.inputs[0].ImageId.ImageTag); got != "abc123" || aws.ToString(fake.inputs[0].RepositoryName) != "josh-app" {
+		t.Errorf("unexpected request: %+v", fake.inputs[0])
+	}
+}
+
+func TestFetchEnhancedFindings(t *testing.T) {
+	fake := &fakeECR{pages: []*ecr.DescribeImageScanFindingsOutput{{
+		ImageScanStatus: &types.ImageScanStatus{Status: types.ScanStatusActive},
+		ImageScanFindings: &types.ImageScanFindings{EnhancedFindings: []types.EnhancedImageScanFinding{{
+			Title:    aws.String("CVE-2024-0003 - golang.org/x/net"),
+			Severity: aws.String("HIGH"),
+			PackageVulnerabilityDetails: &types.PackageVulnerabilityDetails{
+				VulnerabilityId:    aws.String("CVE-2024-0003"),
+				VulnerablePackages: []types.VulnerablePackage{{Name: aws.String("golang.org/x/net"), Version: aws.String("0.1.0"), FixedInVersion: aws.String("0.23.0")}},
+			},
+		}}},
+	}}}
+	report, err := Scanner{ECR: fake}.Fetch(context.Background(), "josh-app", "sha256:abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Finding{ID: "CVE-2024-0003", Severity: High, Package: "golang.org/x/net", Version: "0.1.0", FixedIn: "0.23.0"}
+	if len(report.Findings) != 1 || report.Findings[0] != want {
+		t.Errorf("got %+v, want %+v", report.Findings, want)
+	}
+	if got := aws.ToString(fake.inputs[0].ImageId.ImageDigest); got != "sha256:abcd" {
+		t.Errorf("digest not used: %+v", fake.inputs[0].ImageId)
+	}
+	if report.Image() != "josh-app@sha256:abcd" {
+		t.Errorf("image %q", report.Image())
+	}
+}
+
+func TestFetchWaitsForScan(t *testing.T) {
+	fake := &fakeECR{
+		statuses: []types.ScanStatus{types.ScanStatusPending, types.ScanStatusInProgress},
+		pages:    []*ecr.DescribeImageScanFindingsOutput{complete("")},
+	}
+	report, err := Scanner{ECR: fake, Poll: time.Millisecond}.Fetch(context.Background(), "josh-app", "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.inputs) != 3 || len(report.Findings) != 0 {
+		t.Errorf("expected two polls and a clean report, got %d calls and %+v", len(fake.inputs), report.Findings)
+	}
+
+	fake = &fakeECR{statuses: []types.ScanStatus{types.ScanStatusInProgress, types.ScanStatusInProgress}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err = Scanner{ECR: fake, Poll: time.Hour}.Fetch(ctx, "josh-app", "abc123")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to time out, got %v", err)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	fake := &fakeECR{statuses: []types.ScanStatus{types.ScanStatusUnsupportedImage}}
+	if _, err := (Scanner{ECR: fake}).Fetch(context.Background(), "josh-app", "abc123"); err == nil ||
+		!strings.Contains(err.Error(), "UNSUPPORTED_IMAGE") {
+		t.Errorf("expected an unsupported image error, got %v", err)
+	}
+	fake = &fakeECR{err: errors.New("ImageNotFoundException")}
+	if _, err := (Scanner{ECR: fake}).Fetch(context.Background(), "josh-app", "abc123"); err == nil ||
+		!strings.Contains(err.Error(), "josh-app:abc123") {
+		t.Errorf("expected an API error naming the image, got %v", err)
+	}
+}
+
+func testReport() Report {
+	return Report{Repository: "josh-app", Tag: "abc123", Findings: []Finding{
+		{ID: "CVE-2024-0001", Severity: Critical, Package: "openssl", Version: "3.0.2", FixedIn: "3.0.13"},
+		{ID: "CVE-2024-0004", Severity: High, Package: "libc", Version: "2.36"},
+		{ID: "CVE-2024-0002", Severity: Medium, Package: "zlib", Version: "1.2.13"},
+	}}
+}
+
+func TestPolicy(t *testing.T) {
+	report := testReport()
+	p := Policy{Threshold: High, Ignore: []string{"cve-2024-0004"}}
+	if got := p.Violations(report); len(got) != 1 || got[0].ID != "CVE-2024-0001" {
+		t.Errorf("violations: %+v", got)
+	}
+	if got := (Policy{Threshold: Critical, Ignore: []string{"CVE-2024-0001"}}).Violations(report); len(got) != 0 {
+		t.Errorf("expected everything to pass, got %+v", got)
+	}
+	if _, err := ParseSeverity("severe"); err == nil {
+		t.Error("expected an unknown severity error")
+	}
+	if s, err := ParseSeverity("high"); err != nil || s != High {
+		t.Errorf("got %v, %v", s, err)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteTable(&out, testReport(), Policy{Threshold: High, Ignore: []string{"CVE-2024-0004"}}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected header, 3 rows and verdict:\n%s", out.String())
+	}
+	for i, want := range []string{"FAIL", "ignored", "ok"} {
+		if fields := strings.Fields(lines[i+1]); fields[len(fields)-1] != want {
+			t.Errorf("row %d: %q, want result %s", i+1, lines[i+1], want)
+		}
+	}
+	if want := "josh-app:abc123: 3 finding(s), 1 at or above HIGH: FAIL"; lines[4] != want {
+		t.Errorf("verdict %q, want %q", lines[4], want)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteSARIF(&out, testReport(), Policy{Threshold: High}, "app/josh-app/Dockerfile"); err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID         string                 `json:"id"`
+						Properties map[string]interface{} `json:"properties"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("not a SARIF 2.1.0 log:\n%s", out.String())
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 3 || run.Tool.Driver.Rules[0].Properties["security-severity"] != "9.5" {
+		t.Errorf("rules: %+v", run.Tool.Driver.Rules)
+	}
+	levels := map[string]string{}
+	for _, r := range run.Results {
+		levels[r.RuleID] = r.Level
+		if len(r.Locations) != 1 || r.Locations[0].PhysicalLocation.ArtifactLocation.URI != "app/josh-app/Dockerfile" {
+			t.Errorf("%s: locations %+v", r.RuleID, r.Locations)
+		}
+	}
+	want := map[string]string{"CVE-2024-0001": "error", "CVE-2024-0004": "error", "CVE-2024-0002": "warning"}
+	for id, level := range want {
+		if levels[id] != level {
+			t.Errorf("%s: level %q, want %q", id, levels[id], level)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package findings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteTable writes the findings as a table followed by a one-line verdict.
+func WriteTable(w io.Writer, r Report, p Policy) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if len(r.Findings) > 0 {
+		fmt.Fprintln(tw, "SEVERITY\tID\tPACKAGE\tVERSION\tFIXED IN\tRESULT")
+	}
+	for _, f := range r.Findings {
+		result := "ok"
+		switch {
+		case p.Violates(f):
+			result = "FAIL"
+		case f.Severity >= p.Threshold:
+			result = "ignored"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", f.Severity, f.ID, dash(f.Package), dash(f.Version), dash(f.FixedIn), result)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	failed := len(p.Violations(r))
+	verdict := "PASS"
+	if failed > 0 {
+		verdict = "FAIL"
+	}
+	_, err := fmt.Fprintf(w, "%s: %d finding(s), %d at or above %s: %s\n", r.Image(), len(r.Findings), failed, p.Threshold, verdict)
+	return err
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// sarifScore maps severities to the security-severity scores GitHub code
+// scanning uses to rank SARIF results.
+var sarifScore = map[Severity]string{
+	Critical:      "9.5",
+	High:          "8.0",
+	Medium:        "5.5",
+	Low:           "2.0",
+	Informational: "0.0",
+	Undefined:     "0.0",
+}
+
+// WriteSARIF writes the findings as a SARIF 2.1.0 log. Findings that fail
+// the policy are errors, the rest warnings or notes. Every result is located
+// at artifact (e.g. the app's Dockerfile), since code scanning requires a
+// file location.
+func WriteSARIF(w io.Writer, r Report, p Policy, artifact string) error {
+	type object = map[string]interface{}
+	var rules, results []object
+	seen := map[string]bool{}
+	for _, f := range r.Findings {
+		if !seen[f.ID] {
+			seen[f.ID] = true
+			rule := object{
+				"id":               f.ID,
+				"shortDescription": object{"text": f.ID},
+				"properties": object{
+					"security-severity": sarifScore[f.Severity],
+					"tags":              []string{"security", "container"},
+				},
+			}
+			if f.Description != "" {
+				rule["fullDescription"] = object{"text": f.Description}
+			}
+			if f.URI != "" {
+				rule["helpUri"] = f.URI
+			}
+			rules = append(rules, rule)
+		}
+
+		level := "note"
+		switch {
+		case p.Violates(f):
+			level = "error"
+		case f.Severity >= Medium:
+			level = "warning"
+		}
+		message := fmt.Sprintf("%s (%s) in %s", f.ID, f.Severity, r.Image())
+		if f.Package != "" {
+			message += fmt.Sprintf(": %s %s", f.Package, f.Version)
+			if f.FixedIn != "" {
+				message += ", fixed in " + f.FixedIn
+			}
+		}
+		results = append(results, object{
+			"ruleId":  f.ID,
+			"level":   level,
+			"message": object{"text": strings.TrimSpace(message)},
+			"locations": []object{{
+				"physicalLocation": object{"artifactLocation": object{"uri": artifact}},
+			}},
+		})
+	}
+	if rules == nil {
+		rules = []object{}
+	}
+	if results == nil {
+		results = []object{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(object{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []object{{
+			"tool": object{"driver": object{
+				"name":           "ecr-scan",
+				"informationUri": "https://docs.aws.amazon.com/AmazonECR/latest/userguide/image-scanning.html",
+				"rules":          rules,
+			}},
+			"results": results,
+		}},
+	})
+}
@@ -0,0 +1,113 @@
+// Command ecr-scan gates a build on the ECR scan findings of an image: it
+// waits for the scan of repository:tag to finish, prints the findings as a
+// table or SARIF and exits 1 if any is at or above the severity threshold.
+// It exits 2 if the findings can't be read.
+//
+//	go run . -repository josh-app -tag "$GITHUB_SHA" -severity HIGH
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+
+	"teamchikynbitts-ecr-scan/findings"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ecr-scan", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	repository := fs.String("repository", "", "ECR repository name (required)")
+	tag := fs.String("tag", "latest", "image tag or sha256: digest")
+	severity := fs.String("severity", "HIGH", "fail on findings at or above this severity")
+	ignore := fs.String("ignore", "", "comma-separated finding IDs to accept, e.g. CVE-2024-1234")
+	format := fs.String("format", "table", "output format: table or sarif")
+	out := fs.String("out", "", "write the report to this file instead of stdout")
+	artifact := fs.String("artifact", "Dockerfile", "file SARIF results are reported against")
+	wait := fs.Duration("wait", 5*time.Minute, "how long to wait for a scan in progress")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *repository == "" {
+		fmt.Fprintln(stderr, "ecr-scan: -repository is required")
+		return 2
+	}
+	threshold, err := findings.ParseSeverity(*severity)
+	if err != nil {
+		fmt.Fprintln(stderr, "ecr-scan:", err)
+		return 2
+	}
+	if *format != "table" && *format != "sarif" {
+		fmt.Fprintf(stderr, "ecr-scan: unknown format %q (want table or sarif)\n", *format)
+		return 2
+	}
+	policy := findings.Policy{Threshold: threshold, Ignore: splitList(*ignore)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *wait)
+	defer cancel()
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, "ecr-scan:", err)
+		return 2
+	}
+	scanner := findings.Scanner{ECR: ecr.NewFromConfig(awsCfg)}
+	report, err := scanner.Fetch(ctx, *repository, *tag)
+	if err != nil {
+		fmt.Fprintln(stderr, "ecr-scan:", err)
+		return 2
+	}
+	return write(report, policy, *format, *out, *artifact, stdout, stderr)
+}
+
+// write prints the report and returns the exit code for its verdict.
+func write(report findings.Report, policy findings.Policy, format, out, artifact string, stdout, stderr io.Writer) int {
+	w := stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			fmt.Fprintln(stderr, "ecr-scan:", err)
+			return 2
+		}
+		defer f.Close()
+		w = f
+	}
+	var err error
+	if format == "sarif" {
+		err = findings.WriteSARIF(w, report, policy, artifact)
+	} else {
+		err = findings.WriteTable(w, report, policy)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "ecr-scan:", err)
+		return 2
+	}
+	if failed := len(policy.Violations(report)); failed > 0 {
+		if format == "sarif" || out != "" {
+			fmt.Fprintf(stderr, "ecr-scan: %s has %d finding(s) at or above %s\n", report.Image(), failed, policy.Threshold)
+		}
+		return 1
+	}
+	return 0
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,216 @@
+// Package handler cordons and drains a node before its instance is taken
+// away, so its pods are rescheduled elsewhere rather than killed: on a Spot
+// interruption notice (two minutes' warning) or an Auto Scaling scale-in.
+// It also deletes ephemeral nodes that have been NotReady for a while, since
+// nothing else removes the Node objects of terminated agents.
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EphemeralLabel marks the nodes of agents that come and go with their Auto
+// Scaling group, which may be reaped once they stop reporting.
+const EphemeralLabel = "chikyn.io/ephemeral"
+
+// Defaults for the Handler fields.
+const (
+	defaultInterval  = 5 * time.Second
+	defaultReapAfter = 10 * time.Minute
+	defaultDrainFor  = 110 * time.Second
+	reapEvery        = time.Minute
+)
+
+// Handler watches for Notices and drains Node when one comes.
+type Handler struct {
+	Client  kubernetes.Interface
+	Notices NoticeSource
+	Node    string
+	// Interval is how often Notices is checked (default 5s). DrainFor is
+	// how long evictions are retried (default 110s, inside the two-minute
+	// Spot notice). ReapAfter is how long an ephemeral node may be NotReady
+	// before it is deleted (default 10m).
+	Interval  time.Duration
+	DrainFor  time.Duration
+	ReapAfter time.Duration
+
+	now func() time.Time
+}
+
+func (h *Handler) interval() time.Duration {
+	if h.Interval <= 0 {
+		return defaultInterval
+	}
+	return h.Interval
+}
+
+func (h *Handler) drainFor() time.Duration {
+	if h.DrainFor <= 0 {
+		return defaultDrainFor
+	}
+	return h.DrainFor
+}
+
+func (h *Handler) reapAfter() time.Duration {
+	if h.ReapAfter <= 0 {
+		return defaultReapAfter
+	}
+	return h.ReapAfter
+}
+
+func (h *Handler) clock() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+// Run checks for a notice every Interval until ctx is done, draining the
+// node once when one comes, and reaps dead ephemeral nodes every minute.
+func (h *Handler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(h.interval())
+	defer ticker.Stop()
+	drained := false
+	var reaped time.Time
+	for {
+		if !drained {
+			notice, err := h.Notices.Notice(ctx)
+			switch {
+			case err != nil:
+				log.Printf("checking for a notice: %v", err)
+			case notice != nil:
+				log.Printf("%s: draining %s", notice.Reason, h.Node)
+				if err := h.Drain(ctx); err != nil {
+					log.Printf("draining %s: %v", h.Node, err)
+				}
+				drained = true
+			}
+		}
+		if h.clock().Sub(reaped) >= reapEvery {
+			if err := h.Reap(ctx); err != nil {
+				log.Printf("reaping nodes: %v", err)
+			}
+			reaped = h.clock()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain cordons the node and evicts every pod on it but DaemonSet and
+// static pods, retrying evictions a PodDisruptionBudget refuses until
+// DrainFor is up.
+func (h *Handler) Drain(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, h.drainFor())
+	defer cancel()
+
+	_, err := h.Client.CoreV1().Nodes().Patch(ctx, h.Node, types.StrategicMergePatchType,
+		[]byte(`{"spec":{"unschedulable":true}}`), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("cordoning: %w", err)
+	}
+
+	pods, err := h.Client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", h.Node).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("listing pods: %w", err)
+	}
+	var errs []error
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != h.Node || !evictable(pod) {
+			continue
+		}
+		if err := h.evict(ctx, pod); err != nil {
+			errs = append(errs, fmt.Errorf("evicting %s/%s: %w", pod.Namespace, pod.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// evict evicts pod, retrying while a PodDisruptionBudget refuses it.
+func (h *Handler) evict(ctx context.Context, pod corev1.Pod) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	}
+	for {
+		err := h.Client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		switch {
+		case err == nil, apierrors.IsNotFound(err):
+			return nil
+		case !apierrors.IsTooManyRequests(err):
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(h.interval()):
+		}
+	}
+}
+
+// evictable reports whether a drain should evict pod: not if it is
+// finished, static (mirror) or owned by a DaemonSet, which would only be
+// put back on the node.
+func evictable(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
+
+// Reap deletes the other ephemeral nodes that have not been Ready for
+// ReapAfter: agents whose instances are gone.
+func (h *Handler) Reap(ctx context.Context) error {
+	nodes, err := h.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: EphemeralLabel + "=true",
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, node := range nodes.Items {
+		if node.Name == h.Node || !h.dead(node) {
+			continue
+		}
+		log.Printf("deleting %s: not ready for over %s", node.Name, h.reapAfter())
+		err := h.Client.CoreV1().Nodes().Delete(ctx, node.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dead reports whether node has not been Ready for ReapAfter.
+func (h *Handler) dead(node corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status != corev1.ConditionTrue && h.clock().Sub(c.LastTransitionTime.Time) > h.reapAfter()
+		}
+	}
+	return false
+}
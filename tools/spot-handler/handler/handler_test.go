@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func node(name string, ephemeral bool, ready corev1.ConditionStatus, since time.Time) *corev1.Node {
+	n := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{
+			Type:               corev1.NodeReady,
+			Status:             ready,
+			LastTransitionTime: metav1.NewTime(since),
+		}}},
+	}
+	if ephemeral {
+		n.Labels[EphemeralLabel] = "true"
+	}
+	return n
+}
+
+func pod(name, nodeName string, mutate func(*corev1.Pod)) *corev1.Pod {
+	p := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if mutate != nil {
+		mutate(p)
+	}
+	return p
+}
+
+// recordEvictions makes client record evictions, refusing the first refuse
+// of each pod as a PodDisruptionBudget would.
+func recordEvictions(client *fake.Clientset, refuse int) func() []string {
+	var mu sync.Mutex
+	var evicted []string
+	refused := map[string]int{}
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		name := action.(k8stesting.CreateAction).GetObject().(metav1.Object).GetName()
+		mu.Lock()
+		defer mu.Unlock()
+		if refused[name] < refuse {
+			refused[name]++
+			return true, nil, apierrors.NewTooManyRequests("disruption budget", 1)
+		}
+		evicted = append(evicted, name)
+		return true, nil, nil
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		sort.Strings(evicted)
+		return evicted
+	}
+}
+
+func TestDrain(t *testing.T) {
+	now := time.Now()
+	client := fake.NewSimpleClientset(
+		node("agent-a", true, corev1.ConditionTrue, now),
+		pod("web", "agent-a", nil),
+		pod("api", "agent-a", nil),
+		pod("elsewhere", "agent-b", nil),
+		pod("done", "agent-a", func(p *corev1.Pod) { p.Status.Phase = corev1.PodSucceeded }),
+		pod("static", "agent-a", func(p *corev1.Pod) {
+			p.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "x"}
+		}),
+		pod("handler", "agent-a", func(p *corev1.Pod) {
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "spot-handler"}}
+		}),
+	)
+	evicted := recordEvictions(client, 2)
+
+	h := &Handler{Client: client, Node: "agent-a", Interval: time.Millisecond}
+	if err := h.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := client.CoreV1().Nodes().Get(context.Background(), "agent-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Spec.Unschedulable {
+		t.Error("node is not cordoned")
+	}
+	if got := evicted(); len(got) != 2 || got[0] != "api" || got[1] != "web" {
+		t.Errorf("evicted %v, want [api web]", got)
+	}
+}
+
+func TestDrainGivesUp(t *testing.T) {
+	client := fake.NewSimpleClientset(node("agent-a", true, corev1.ConditionTrue, time.Now()), pod("web", "agent-a", nil))
+	recordEvictions(client, 1<<30)
+
+	h := &Handler{Client: client, Node: "agent-a", Interval: time.Millisecond, DrainFor: 20 * time.Millisecond}
+	err := h.Drain(context.Background())
+	if err == nil || !apierrors.IsTooManyRequests(err) {
+		t.Errorf("expected the disruption budget error once DrainFor is up, got %v", err)
+	}
+}
+
+func TestReap(t *testing.T) {
+	now := time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)
+	client := fake.NewSimpleClientset(
+		node("self", true, corev1.ConditionUnknown, now.Add(-time.Hour)),
+		node("gone", true, corev1.ConditionUnknown, now.Add(-time.Hour)),
+		node("flapping", true, corev1.ConditionFalse, now.Add(-time.Minute)),
+		node("healthy", true, corev1.ConditionTrue, now.Add(-time.Hour)),
+		node("server", false, corev1.ConditionUnknown, now.Add(-time.Hour)),
+	)
+	h := &Handler{Client: client, Node: "self", now: func() time.Time { return now }}
+	if err := h.Reap(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, n := range nodes.Items {
+		left = append(left, n.Name)
+	}
+	sort.Strings(left)
+	if want := []string{"flapping", "healthy", "self", "server"}; len(left) != len(want) || left[0] != want[0] || left[1] != want[1] || left[2] != want[2] || left[3] != want[3] {
+		t.Errorf("nodes left %v, want %v", left, want)
+	}
+}
+
+// notices returns no notice until the nth check, then one.
+type notices struct {
+	mu    sync.Mutex
+	n     int
+	calls int
+}
+
+func (s *notices) Notice(context.Context) (*Notice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls < s.n {
+		return nil, nil
+	}
+	return &Notice{Reason: "spot interruption (terminate)"}, nil
+}
+
+func (s *notices) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestRunDrainsOnNotice(t *testing.T) {
+	client := fake.NewSimpleClientset(node("agent-a", true, corev1.ConditionTrue, time.Now()), pod("web", "agent-a", nil))
+	evicted := recordEvictions(client, 0)
+	source := &notices{n: 3}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	h := &Handler{Client: client, Notices: source, Node: "agent-a", Interval: time.Millisecond}
+	go func() { done <- h.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(evicted()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	calls := source.count()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := evicted(); len(got) != 1 || got[0] != "web" {
+		t.Errorf("evicted %v, want [web]", got)
+	}
+	if source.count() != calls {
+		t.Error("kept checking for notices after draining")
+	}
+	evictions := 0
+	for _, a := range client.Actions() {
+		if a.GetResource() == (schema.GroupVersionResource{Version: "v1", Resource: "pods"}) && a.GetSubresource() == "eviction" {
+			evictions++
+		}
+	}
+	if evictions != 1 {
+		t.Errorf("drained %d times", evictions)
+	}
+}
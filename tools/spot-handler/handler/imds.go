@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEndpoint is the instance metadata service.
+const DefaultEndpoint = "http://169.254.169.254"
+
+// tokenTTL is how long an IMDSv2 session token is asked for.
+const tokenTTL = 6 * time.Hour
+
+// Notice says why and when the instance is going away.
+type Notice struct {
+	Reason string
+	Time   time.Time
+}
+
+// NoticeSource reports whether the instance is about to go away, or nil if
+// it isn't.
+type NoticeSource interface {
+	Notice(ctx context.Context) (*Notice, error)
+}
+
+// IMDS reads interruption and scale-in notices from the instance metadata
+// service, over IMDSv2 session tokens.
+type IMDS struct {
+	// Endpoint defaults to DefaultEndpoint and Client to http.DefaultClient.
+	Endpoint string
+	Client   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (m *IMDS) endpoint() string {
+	if m.Endpoint == "" {
+		return DefaultEndpoint
+	}
+	return strings.TrimSuffix(m.Endpoint, "/")
+}
+
+func (m *IMDS) client() *http.Client {
+	if m.Client == nil {
+		return http.DefaultClient
+	}
+	return m.Client
+}
+
+// Notice checks for a Spot interruption notice, which comes two minutes
+// before the instance is stopped or terminated, and for its Auto Scaling
+// group scaling it in.
+func (m *IMDS) Notice(ctx context.Context) (*Notice, error) {
+	body, ok, err := m.get(ctx, "/latest/meta-data/spot/instance-action")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		var action struct {
+			Action string    `json:"action"`
+			Time   time.Time `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(body), &action); err != nil {
+			return nil, fmt.Errorf("spot instance-action %q: %w", body, err)
+		}
+		return &Notice{Reason: "spot interruption (" + action.Action + ")", Time: action.Time}, nil
+	}
+
+	body, ok, err = m.get(ctx, "/latest/meta-data/autoscaling/target-lifecycle-state")
+	if err != nil {
+		return nil, err
+	}
+	if ok && strings.TrimSpace(body) == "Terminated" {
+		return &Notice{Reason: "scale-in"}, nil
+	}
+	return nil, nil
+}
+
+// get reads a metadata path. It returns false if the path doesn't exist,
+// which is how IMDS says there is no notice.
+func (m *IMDS) get(ctx context.Context, path string) (string, bool, error) {
+	token, err := m.sessionToken(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.endpoint()+path, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	resp, err := m.client().Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return string(body), true, nil
+	case http.StatusNotFound:
+		return "", false, nil
+	case http.StatusUnauthorized:
+		// The token was rejected; ask for a new one next time.
+		m.mu.Lock()
+		m.token = ""
+		m.mu.Unlock()
+	}
+	return "", false, fmt.Errorf("GET %s: %s", path, resp.Status)
+}
+
+// sessionToken returns the cached IMDSv2 token, asking for a new one when it
+// is close to expiring.
+func (m *IMDS) sessionToken(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != "" && time.Now().Before(m.expires.Add(-time.Minute)) {
+		return m.token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, m.endpoint()+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", fmt.Sprint(int(tokenTTL.Seconds())))
+	resp, err := m.client().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("IMDSv2 token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("IMDSv2 token: %s", resp.Status)
+	}
+	m.token = string(body)
+	m.expires = time.Now().Add(tokenTTL)
+	return m.token, nil
+}
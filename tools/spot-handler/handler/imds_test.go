@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeIMDS serves IMDSv2: a token from PUT /latest/api/token, then the
+// metadata paths, each only with that token.
+func fakeIMDS(t *testing.T, paths map[string]string) (*httptest.Server, *int) {
+	t.Helper()
+	tokens := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				http.Error(w, "bad token request", http.StatusBadRequest)
+				return
+			}
+			tokens++
+			w.Write([]byte("session"))
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "session" {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		body, ok := paths[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &tokens
+}
+
+func TestIMDSNoNotice(t *testing.T) {
+	srv, tokens := fakeIMDS(t, map[string]string{
+		"/latest/meta-data/autoscaling/target-lifecycle-state": "InService",
+	})
+	m := &IMDS{Endpoint: srv.URL}
+	for i := 0; i < 3; i++ {
+		notice, err := m.Notice(context.Background())
+		if err != nil || notice != nil {
+			t.Fatalf("expected no notice, got %+v, %v", notice, err)
+		}
+	}
+	if *tokens != 1 {
+		t.Errorf("expected the session token to be reused, got %d tokens", *tokens)
+	}
+}
+
+func TestIMDSSpotInterruption(t *testing.T) {
+	srv, _ := fakeIMDS(t, map[string]string{
+		"/latest/meta-data/spot/instance-action": `{"action": "terminate", "time": "2026-10-17T08:22:00Z"}`,
+	})
+	notice, err := (&IMDS{Endpoint: srv.URL}).Notice(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notice == nil || notice.Reason != "spot interruption (terminate)" ||
+		!notice.Time.Equal(time.Date(2026, 10, 17, 8, 22, 0, 0, time.UTC)) {
+		t.Errorf("unexpected notice %+v", notice)
+	}
+}
+
+func TestIMDSScaleIn(t *testing.T) {
+	srv, _ := fakeIMDS(t, map[string]string{
+		"/latest/meta-data/autoscaling/target-lifecycle-state": "Terminated",
+	})
+	notice, err := (&IMDS{Endpoint: srv.URL}).Notice(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notice == nil || notice.Reason != "scale-in" {
+		t.Errorf("unexpected notice %+v", notice)
+	}
+}
+
+func TestIMDSErrors(t *testing.T) {
+	srv, _ := fakeIMDS(t, map[string]string{
+		"/latest/meta-data/spot/instance-action": "not json",
+	})
+	if _, err := (&IMDS{Endpoint: srv.URL}).Notice(context.Background()); err == nil || !strings.Contains(err.Error(), "not json") {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+
+	srv.Close()
+	if _, err := (&IMDS{Endpoint: srv.URL}).Notice(context.Background()); err == nil || !strings.Contains(err.Error(), "IMDSv2 token") {
+		t.Errorf("expected a token error, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Command spot-handler cordons and drains the node it runs on when the
+// instance is about to go away: on a Spot interruption notice or an Auto
+// Scaling scale-in, both read from IMDSv2. It also deletes ephemeral nodes
+// that have stopped reporting. It runs as a DaemonSet on the host network, so
+// the default IMDSv2 hop limit of one is enough.
+//
+//	spot-handler -node "$NODE_NAME"
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"teamchikynbitts-spot-handler/handler"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("spot-handler", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	node := fs.String("node", os.Getenv("NODE_NAME"), "name of this node (default $NODE_NAME)")
+	endpoint := fs.String("imds", handler.DefaultEndpoint, "instance metadata endpoint")
+	interval := fs.Duration("interval", 5*time.Second, "how often to check for a notice")
+	drainFor := fs.Duration("drain-for", 110*time.Second, "how long to keep retrying evictions")
+	reapAfter := fs.Duration("reap-after", 10*time.Minute, "delete ephemeral nodes not ready for this long")
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig to use outside the cluster")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	log.SetOutput(stderr)
+	if *node == "" {
+		fmt.Fprintln(stderr, "spot-handler: -node (or $NODE_NAME) is required")
+		return 2
+	}
+
+	restConfig, err := kubeConfig(*kubeconfig)
+	if err != nil {
+		fmt.Fprintln(stderr, "spot-handler:", err)
+		return 2
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		fmt.Fprintln(stderr, "spot-handler:", err)
+		return 2
+	}
+
+	h := &handler.Handler{
+		Client:    client,
+		Notices:   &handler.IMDS{Endpoint: *endpoint},
+		Node:      *node,
+		Interval:  *interval,
+		DrainFor:  *drainFor,
+		ReapAfter: *reapAfter,
+	}
+	if err := h.Run(ctx); err != nil {
+		fmt.Fprintln(stderr, "spot-handler:", err)
+		return 1
+	}
+	return 0
+}
+
+// kubeConfig returns the in-cluster config, or path's when it is set.
+func kubeConfig(path string) (*rest.Config, error) {
+	if path != "" {
+		return clientcmd.BuildConfigFromFlags("", path)
+	}
+	return rest.InClusterConfig()
+}